@@ -0,0 +1,438 @@
+package alert
+
+import (
+  "context"
+  "fmt"
+  "strconv"
+  "sync"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  // How often rules are evaluated between readings, for 'for' durations and absent devices.
+  evaluationInterval = 15 * time.Second
+
+  // events waiting to be sent to a notifier, before new ones are dropped.
+  notifyQueueSize = 100
+  // How long notifiers get to send the events still queued on shutdown.
+  notifyShutdownTimeout = 10 * time.Second
+  notifyAttempts = 5
+  notifyBackoff = 2 * time.Second
+)
+
+type Status string
+
+const (
+  StatusFiring Status = "firing"
+  StatusResolved Status = "resolved"
+)
+
+// Event is an alert firing (again, if the rule repeats) or resolving.
+type Event struct {
+  Status Status
+  Rule Rule
+  Device string
+  // Identify the alert: alertname, device, severity, metric, probe (of temperatures only), along
+  // with the labels of the device and of the rule.
+  Labels map[string]string
+  // Last value of the metric, nil for absent devices and metrics no longer reported.
+  Value *float64
+  Summary string
+  StartsAt time.Time
+  // Zero while firing.
+  EndsAt time.Time
+}
+
+// Notifier sends alert events somewhere, e.g. to a webhook.
+type Notifier interface {
+  // Called from a single goroutine. Failed events are retried a few times.
+  Notify(ctx context.Context, ev Event) error
+}
+
+type notifierQueue struct {
+  name string
+  notifier Notifier
+  events chan Event
+}
+
+type alertKey struct {
+  // index of the rule.
+  rule int
+  device string
+}
+
+type alertState struct {
+  // since when the condition holds, zero if it doesn't.
+  pendingSince time.Time
+  firing bool
+  startsAt time.Time
+  // last time a firing event was sent.
+  notified time.Time
+}
+
+// Engine evaluates rules against the readings of the devices. It is a collector.Sink, and
+// evaluates rules on its own between readings once Run.
+type Engine struct {
+  rules []Rule
+  devices []device.Device
+  notifiers []*notifierQueue
+
+  mu sync.Mutex
+  // last reading of every device, by name. protected by mu.
+  last map[string]collector.SinkReading
+  // when devices started being watched, for absent devices which never sent a reading.
+  started time.Time
+  // whether this replica sends notifications, see Run(). protected by mu.
+  leading func() bool
+  // protected by mu.
+  alerts map[alertKey]*alertState
+}
+
+func NewEngine(rules []Rule, devices []device.Device) *Engine {
+  return &Engine{
+    rules: rules,
+    devices: devices,
+    last: make(map[string]collector.SinkReading),
+    started: time.Now(),
+    alerts: make(map[alertKey]*alertState),
+  }
+}
+
+// Send events to a notifier, named in logs and metrics. Must be called before Run().
+func (e *Engine) AddNotifier(name string, n Notifier) {
+  e.notifiers = append(e.notifiers, &notifierQueue{name: name, notifier: n, events: make(chan Event, notifyQueueSize)})
+
+  for _, outcome := range []string{"sent", "failed", "dropped"} {
+    notificationsCounter.WithLabelValues(name, outcome)
+  }
+}
+
+// *collector.Sink
+func (e *Engine) Deliver(ctx context.Context, readings []collector.SinkReading) error {
+  e.observe(readings)
+  e.tick()
+
+  return nil
+}
+
+// Evaluate rules and send notifications until ctx is done, then send the events still queued.
+// Alerts are only evaluated while leading returns true, so that replicas in standby don't notify
+// alerts already notified by the leader. leading may be nil outside of high-availability mode.
+func (e *Engine) Run(ctx context.Context, leading func() bool) {
+  e.mu.Lock()
+  e.leading = leading
+  e.mu.Unlock()
+
+  var notifiers sync.WaitGroup
+
+  for _, q := range e.notifiers {
+    notifiers.Add(1)
+
+    go func(q *notifierQueue) {
+      defer notifiers.Done()
+      q.run(ctx)
+    }(q)
+  }
+
+  defer notifiers.Wait()
+
+  ticker := time.NewTicker(evaluationInterval)
+  defer ticker.Stop()
+
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+      e.tick()
+    }
+  }
+}
+
+// Evaluate rules now and queue the events. Readings and the ticker evaluate rules concurrently:
+// events are queued under the lock, so that a resolved event is never queued before its firing one.
+func (e *Engine) tick() {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+
+  e.dispatch(e.evaluateLocked(time.Now()))
+}
+
+func (e *Engine) observe(readings []collector.SinkReading) {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+
+  for _, r := range readings {
+    if prev, ok := e.last[r.Device.Name()]; !ok || !r.Time.Before(prev.Time) {
+      e.last[r.Device.Name()] = r
+    }
+  }
+}
+
+// Evaluate every rule against every device it applies to, returning the events to send.
+func (e *Engine) evaluate(now time.Time) []Event {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+
+  return e.evaluateLocked(now)
+}
+
+// must be called with mu held.
+func (e *Engine) evaluateLocked(now time.Time) []Event {
+  var events []Event
+
+  for i, r := range e.rules {
+    for _, dev := range e.devices {
+      if !r.appliesTo(dev.Name()) {
+        continue
+      }
+
+      if ev, ok := e.evaluateRule(i, dev, now); ok {
+        events = append(events, ev)
+      }
+    }
+  }
+
+  return events
+}
+
+// must be called with mu held.
+func (e *Engine) evaluateRule(i int, dev device.Device, now time.Time) (Event, bool) {
+  r := e.rules[i]
+  key := alertKey{i, dev.Name()}
+  st, ok := e.alerts[key]
+
+  if !ok {
+    st = &alertState{}
+    e.alerts[key] = st
+  }
+
+  if e.leading != nil && !e.leading() {
+    // alerts are up to the leader: start over if leading again.
+    if st.firing {
+      firingGauge.WithLabelValues(r.Name, dev.Name()).Set(0)
+    }
+
+    *st = alertState{}
+    return Event{}, false
+  }
+
+  last, seen := e.last[dev.Name()]
+
+  var cond bool
+  var value *float64
+
+  if r.Metric == MetricAbsent {
+    since := e.started
+
+    if seen && last.Time.After(since) {
+      since = last.Time
+    }
+
+    // the absence itself lasts 'for', no need to wait any longer.
+    cond = now.Sub(since) >= r.For
+    st.pendingSince = since
+  } else {
+    if !seen {
+      return Event{}, false
+    }
+
+    // a metric which isn't reported anymore, e.g. the temperature of an unplugged probe, doesn't
+    // hold the condition: a firing alert resolves.
+    if v, ok := r.value(last.Reading); ok {
+      value = &v
+      cond = r.crossed(v, st.firing)
+    }
+
+    if !cond {
+      st.pendingSince = time.Time{}
+    } else if st.pendingSince.IsZero() {
+      st.pendingSince = now
+    }
+  }
+
+  switch {
+  case !st.firing && cond && (r.Metric == MetricAbsent || now.Sub(st.pendingSince) >= r.For):
+    st.firing, st.startsAt, st.notified = true, now, now
+  case st.firing && cond && r.Repeat > 0 && now.Sub(st.notified) >= r.Repeat:
+    st.notified = now
+  case st.firing && !cond:
+    st.firing, st.pendingSince = false, time.Time{}
+    ev := e.newEvent(r, dev, last, value, StatusResolved, st.startsAt)
+    ev.EndsAt = now
+
+    return ev, true
+  default:
+    return Event{}, false
+  }
+
+  return e.newEvent(r, dev, last, value, StatusFiring, st.startsAt), true
+}
+
+func (e *Engine) newEvent(r Rule, dev device.Device, last collector.SinkReading, value *float64, status Status, startsAt time.Time) Event {
+  labels := make(map[string]string, len(last.Labels) + len(r.Labels) + 5)
+
+  for k, v := range last.Labels {
+    labels[k] = v
+  }
+
+  for k, v := range r.Labels {
+    labels[k] = v
+  }
+
+  // identify the alert: free-form labels can't override these.
+  labels["alertname"] = r.Name
+  labels["device"] = dev.Name()
+  labels["severity"] = r.Severity
+  labels["metric"] = string(r.Metric)
+
+  if r.Metric == MetricTemperature {
+    labels["probe"] = strconv.Itoa(r.Probe)
+  }
+
+  ev := Event{
+    Status: status,
+    Rule: r,
+    Device: dev.Name(),
+    Labels: labels,
+    Value: value,
+    Summary: r.Summary,
+    StartsAt: startsAt,
+  }
+
+  if ev.Summary == "" {
+    ev.Summary = summary(r, dev.Name(), last, value, status)
+  }
+
+  return ev
+}
+
+// e.g. "freezer temperature is -12.5°C, above -15°C" or "no reading from freezer for 30m0s".
+func summary(r Rule, name string, last collector.SinkReading, value *float64, status Status) string {
+  if r.Metric == MetricAbsent {
+    if status == StatusResolved {
+      return fmt.Sprintf("%s is sending readings again", name)
+    }
+
+    return fmt.Sprintf("no reading from %s for %v", name, r.For)
+  }
+
+  metric := string(r.Metric)
+
+  if r.Metric == MetricTemperature && len(last.Temperatures) > 1 {
+    metric = fmt.Sprintf("temperature of probe %d", r.Probe)
+  } else if r.Metric == MetricRSSI {
+    metric = "signal strength"
+  }
+
+  direction := "below"
+
+  if r.Above {
+    direction = "above"
+  }
+
+  if status == StatusResolved && value == nil {
+    return fmt.Sprintf("%s %s is no longer reported", name, metric)
+  } else if status == StatusResolved {
+    return fmt.Sprintf("%s %s is back to %.1f%s", name, metric, *value, r.unit())
+  }
+
+  return fmt.Sprintf("%s %s is %.1f%s, %s %g%s", name, metric, *value, r.unit(), direction, r.Threshold, r.unit())
+}
+
+// Log events and queue them for every notifier. Never blocks, must be called with mu held.
+func (e *Engine) dispatch(events []Event) {
+  for _, ev := range events {
+    logEvent := log.Warn()
+
+    if ev.Status == StatusResolved {
+      logEvent = log.Info()
+    }
+
+    logEvent.
+      Str("Alert", ev.Rule.Name).
+      Str("Device", ev.Device).
+      Str("Status", string(ev.Status)).
+      Msg(ev.Summary)
+
+    firingGauge.WithLabelValues(ev.Rule.Name, ev.Device).Set(boolToFloat(ev.Status == StatusFiring))
+
+    for _, q := range e.notifiers {
+      select {
+      case q.events <- ev:
+      default:
+        log.Warn().Str("Notifier", q.name).Msg("Alert notification queue is full, dropping event")
+        notificationsCounter.WithLabelValues(q.name, "dropped").Inc()
+      }
+    }
+  }
+}
+
+// Send queued events until ctx is done, retrying failed ones with an exponential backoff. Then
+// send the events still queued, for a bounded time.
+func (q *notifierQueue) run(ctx context.Context) {
+  for {
+    select {
+    case <-ctx.Done():
+      // ctx is done already: the last events get their own deadline.
+      shutdownCtx, cancel := context.WithTimeout(context.Background(), notifyShutdownTimeout)
+      defer cancel()
+
+      for {
+        select {
+        case ev := <-q.events:
+          q.send(shutdownCtx, ev)
+        default:
+          return
+        }
+      }
+    case ev := <-q.events:
+      q.send(ctx, ev)
+    }
+  }
+}
+
+func (q *notifierQueue) send(ctx context.Context, ev Event) {
+  backoff := notifyBackoff
+
+  for attempt := 1; ; attempt += 1 {
+    err := q.notifier.Notify(ctx, ev)
+
+    if err == nil {
+      notificationsCounter.WithLabelValues(q.name, "sent").Inc()
+      return
+    }
+
+    log.Warn().
+      Str("Notifier", q.name).
+      Str("Alert", ev.Rule.Name).
+      Str("Device", ev.Device).
+      Int("Attempt", attempt).
+      Err(err).
+      Msg("Failed to send alert notification")
+
+    if attempt == notifyAttempts {
+      notificationsCounter.WithLabelValues(q.name, "failed").Inc()
+      return
+    }
+
+    select {
+    case <-ctx.Done():
+      return
+    case <-time.After(backoff):
+      backoff *= 2
+    }
+  }
+}
+
+func boolToFloat(b bool) float64 {
+  if b {
+    return 1
+  }
+
+  return 0
+}
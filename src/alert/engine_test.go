@@ -0,0 +1,192 @@
+package alert
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "slices"
+  "testing"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/internal/testutil"
+)
+
+func mustParseRule(t *testing.T, spec string) Rule {
+  t.Helper()
+
+  ds, err := device.ParseDeviceSpec(spec)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  r, err := ParseRule(ds)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  return r
+}
+
+func TestEngine(t *testing.T) {
+  t0 := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+  freezer, fridge := testutil.FakeDevice("freezer"), testutil.FakeDevice("fridge")
+
+  e := NewEngine([]Rule{
+    mustParseRule(t, "device=freezer, metric=temperature, above=-15, for=10m, hysteresis=1, repeat=1h, label.room=garage"),
+    mustParseRule(t, "metric=absent, for=2h"),
+  }, []device.Device{freezer, fridge})
+  e.started = t0
+
+  observe := func(at time.Duration, temp float32) {
+    e.observe([]collector.SinkReading{{
+      Device: freezer,
+      Sample: model.Sample{Reading: device.Reading{Temperatures: []float32{temp}}, Time: t0.Add(at)},
+    }})
+  }
+
+  expect := func(at time.Duration, want ...string) {
+    t.Helper()
+
+    var got []string
+
+    for _, ev := range e.evaluate(t0.Add(at)) {
+      got = append(got, ev.Labels["alertname"] + " " + ev.Device + " " + string(ev.Status))
+    }
+
+    if !slices.Equal(got, want) {
+      t.Errorf("at %v: got events %q, wanted %q", at, got, want)
+    }
+  }
+
+  observe(0, -16)
+  expect(0)
+
+  // pending for 10m.
+  observe(time.Minute, -14)
+  expect(time.Minute)
+  expect(10 * time.Minute)
+  expect(11 * time.Minute, "TemperatureHigh freezer firing")
+  expect(30 * time.Minute)
+
+  // within the hysteresis: still firing, and notified again after an hour.
+  observe(40 * time.Minute, -15.5)
+  expect(40 * time.Minute)
+  expect(71 * time.Minute, "TemperatureHigh freezer firing")
+
+  observe(80 * time.Minute, -16.5)
+  expect(80 * time.Minute, "TemperatureHigh freezer resolved")
+
+  // the fridge never sent a reading.
+  expect(2 * time.Hour, "NoReading fridge firing")
+
+  e.observe([]collector.SinkReading{{Device: fridge, Sample: model.Sample{Time: t0.Add(130 * time.Minute)}}})
+  expect(130 * time.Minute, "NoReading fridge resolved")
+
+  // the freezer reading at 80m is now 2h old.
+  expect(200 * time.Minute, "NoReading freezer firing")
+}
+
+func TestEngineUnreportedValueAndLeadership(t *testing.T) {
+  t0 := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+  grill := testutil.FakeDevice("grill")
+
+  e := NewEngine([]Rule{mustParseRule(t, "metric=temperature, probe=1, above=100, label.alertname=Other")}, []device.Device{grill})
+  leading := true
+  e.leading = func() bool { return leading }
+
+  observe := func(temps ...float32) {
+    e.observe([]collector.SinkReading{{
+      Device: grill,
+      Labels: map[string]string{"device": "other"},
+      Sample: model.Sample{Reading: device.Reading{Temperatures: temps}, Time: t0},
+    }})
+  }
+
+  observe(20, 120)
+
+  if evs := e.evaluate(t0); len(evs) != 1 || evs[0].Status != StatusFiring ||
+    evs[0].Labels["alertname"] != "TemperatureHigh" || evs[0].Labels["device"] != "grill" {
+    t.Fatalf("got %+v, wanted TemperatureHigh firing for grill", evs)
+  }
+
+  // the probe got unplugged.
+  observe(20)
+
+  if evs := e.evaluate(t0.Add(time.Minute)); len(evs) != 1 || evs[0].Status != StatusResolved || evs[0].Value != nil {
+    t.Fatalf("got %+v, wanted a resolved event without a value", evs)
+  }
+
+  observe(20, 120)
+  leading = false
+
+  if evs := e.evaluate(t0.Add(2 * time.Minute)); len(evs) != 0 {
+    t.Errorf("got %+v in standby, wanted no events", evs)
+  }
+}
+
+func TestParseRuleErrors(t *testing.T) {
+  for _, spec := range []string{
+    "metric=temperature",
+    "metric=temperature, above=1, below=0",
+    "metric=absent",
+    "metric=absent, for=10m, above=1",
+    "metric=pressure, above=1",
+  } {
+    ds, _ := device.ParseDeviceSpec(spec)
+
+    if _, err := ParseRule(ds); !errors.Is(err, device.ErrInvalidSpec) {
+      t.Errorf("%q: got %v, wanted an invalid spec", spec, err)
+    }
+  }
+}
+
+func TestWebhookAlertmanager(t *testing.T) {
+  var got alertmanagerPayload
+
+  srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if r.Header.Get("Authorization") != "Bearer secret" {
+      w.WriteHeader(http.StatusUnauthorized)
+      return
+    }
+
+    json.NewDecoder(r.Body).Decode(&got)
+  }))
+  defer srv.Close()
+
+  ds, _ := device.ParseDeviceSpec("url=" + srv.URL + ", format=alertmanager, header.Authorization='Bearer secret'")
+  w, err := NewWebhookFromSpec(ds)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  value := -12.5
+  ev := Event{
+    Status: StatusFiring,
+    Rule: Rule{Name: "FreezerTooWarm"},
+    Device: "freezer",
+    Labels: map[string]string{"alertname": "FreezerTooWarm", "device": "freezer"},
+    Value: &value,
+    Summary: "freezer temperature is -12.5°C, above -15°C",
+    StartsAt: time.Now(),
+  }
+
+  if err := w.Notify(context.Background(), ev); err != nil {
+    t.Fatal(err)
+  }
+
+  if got.Version != "4" || got.Status != StatusFiring || len(got.Alerts) != 1 {
+    t.Fatalf("unexpected payload %+v", got)
+  }
+
+  if a := got.Alerts[0]; a.Labels["device"] != "freezer" || a.Annotations["value"] != "-12.5" || a.Fingerprint == "" {
+    t.Errorf("unexpected alert %+v", a)
+  }
+}
@@ -0,0 +1,23 @@
+package alert
+
+import (
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+  firingGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+    Name: "inkbird_exporter_alert_firing",
+    Help: "Whether the alert is firing (1) or not (0) for the device.",
+  }, []string{"alert", "name"})
+  notificationsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+    Name: "inkbird_exporter_alert_notifications_total",
+    Help: "Total number of alert notifications handled by the notifier, by outcome (sent, failed or dropped).",
+  }, []string{"notifier", "outcome"})
+)
+
+func RegisterMetrics(reg prometheus.Registerer) {
+  reg.MustRegister(
+    firingGauge,
+    notificationsCounter,
+  )
+}
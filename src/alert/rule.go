@@ -0,0 +1,268 @@
+// Package alert evaluates threshold rules against the readings of the devices and notifies
+// webhooks when alerts fire and resolve, without depending on Prometheus or on an internet
+// connection.
+package alert
+
+import (
+  "fmt"
+  "strings"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/device"
+)
+
+// Metric a rule is evaluated on.
+type Metric string
+
+const (
+  MetricTemperature Metric = "temperature"
+  MetricHumidity Metric = "humidity"
+  MetricBattery Metric = "battery"
+  MetricRSSI Metric = "rssi"
+  // No reading received from the device for the duration of the rule.
+  MetricAbsent Metric = "absent"
+)
+
+var allMetrics = []string{
+  string(MetricTemperature),
+  string(MetricHumidity),
+  string(MetricBattery),
+  string(MetricRSSI),
+  string(MetricAbsent),
+}
+
+const (
+  specFieldName = "name"
+  specFieldDevice = "device"
+  specFieldMetric = "metric"
+  specFieldProbe = "probe"
+  specFieldAbove = "above"
+  specFieldBelow = "below"
+  specFieldFor = "for"
+  specFieldHysteresis = "hysteresis"
+  specFieldRepeat = "repeat"
+  specFieldSeverity = "severity"
+  specFieldSummary = "summary"
+)
+
+// RuleSchema lists the spec parameters of a rule. Free-form labels can be attached to its alerts
+// with 'label.<name>=<value>'.
+var RuleSchema = device.Schema{
+  {
+    Name: specFieldName,
+    Type: device.ParamTypeString,
+    Description: "Name of the alert, e.g. 'FreezerTooWarm'. Defaults to the metric and direction, e.g. 'TemperatureHigh'",
+  },
+  {
+    Name: specFieldDevice,
+    Type: device.ParamTypeString,
+    Description: "Name of the device the rule applies to. Applies to every device if not set",
+  },
+  {
+    Name: specFieldMetric,
+    Type: device.ParamTypeString,
+    Required: true,
+    AllowedValues: allMetrics,
+    Description: "Metric to watch (temperature in Celsius, humidity and battery in percent, rssi in dBm), or 'absent' to fire when no reading is received for the duration of 'for'",
+  },
+  {
+    Name: specFieldProbe,
+    Type: device.ParamTypeInt,
+    Default: "0",
+    Description: "Probe of the temperature to watch, starting from 0",
+  },
+  {
+    Name: specFieldAbove,
+    Type: device.ParamTypeFloat,
+    Description: "Fire when the metric is above this value",
+  },
+  {
+    Name: specFieldBelow,
+    Type: device.ParamTypeFloat,
+    Description: "Fire when the metric is below this value",
+  },
+  {
+    Name: specFieldFor,
+    Type: device.ParamTypeDuration,
+    Default: "0s",
+    Description: "How long the condition must hold before the alert fires. Required by 'absent'",
+  },
+  {
+    Name: specFieldHysteresis,
+    Type: device.ParamTypeFloat,
+    Default: "0",
+    Description: "How far back past the threshold the metric must go for the alert to resolve, to avoid flapping",
+  },
+  {
+    Name: specFieldRepeat,
+    Type: device.ParamTypeDuration,
+    Default: "0s",
+    Description: "Notify again at this interval while the alert is firing (0 to notify only once)",
+  },
+  {
+    Name: specFieldSeverity,
+    Type: device.ParamTypeString,
+    Default: "warning",
+    Description: "Severity label of the alert",
+  },
+  {
+    Name: specFieldSummary,
+    Type: device.ParamTypeString,
+    Description: "Summary of the alert. Defaults to a description of the condition and value",
+  },
+}
+
+// Rule fires an alert for a device when a metric crosses a threshold, or when the device stops
+// sending readings.
+type Rule struct {
+  Name string
+  // Device the rule applies to, every device if empty.
+  Device string
+  Metric Metric
+  Probe int
+  Threshold float64
+  // Fire when the metric is above the threshold, or below if false. Unused by MetricAbsent.
+  Above bool
+  For time.Duration
+  Hysteresis float64
+  Repeat time.Duration
+  Severity string
+  Summary string
+  Labels map[string]string
+}
+
+// ParseRule parses a rule from its spec.
+func ParseRule(spec device.DeviceSpec) (Rule, error) {
+  labels, err := spec.ExtractLabels()
+
+  if err != nil {
+    return Rule{}, err
+  }
+
+  params, err := RuleSchema.Parse(spec)
+
+  if err != nil {
+    return Rule{}, err
+  }
+
+  r := Rule{
+    Name: params.String(specFieldName),
+    Device: params.String(specFieldDevice),
+    Metric: Metric(params.String(specFieldMetric)),
+    Probe: params.Int(specFieldProbe),
+    For: params.Duration(specFieldFor),
+    Hysteresis: params.Float(specFieldHysteresis),
+    Repeat: params.Duration(specFieldRepeat),
+    Severity: params.String(specFieldSeverity),
+    Summary: params.String(specFieldSummary),
+    Labels: labels,
+  }
+
+  above, below := params.IsSet(specFieldAbove), params.IsSet(specFieldBelow)
+
+  switch {
+  case r.Metric == MetricAbsent:
+    if above || below {
+      return Rule{}, fmt.Errorf("%w: %s and %s don't apply to %s=%s", device.ErrInvalidSpec, specFieldAbove, specFieldBelow, specFieldMetric, r.Metric)
+    }
+
+    if r.For <= 0 {
+      return Rule{}, fmt.Errorf("%w: %s=%s requires a positive %s", device.ErrInvalidSpec, specFieldMetric, r.Metric, specFieldFor)
+    }
+  case above == below:
+    return Rule{}, fmt.Errorf("%w: exactly one of %s or %s is required", device.ErrInvalidSpec, specFieldAbove, specFieldBelow)
+  case above:
+    r.Above, r.Threshold = true, params.Float(specFieldAbove)
+  default:
+    r.Threshold = params.Float(specFieldBelow)
+  }
+
+  if r.Probe < 0 {
+    return Rule{}, fmt.Errorf("%w: %s must not be negative", device.ErrInvalidSpec, specFieldProbe)
+  }
+
+  if r.Hysteresis < 0 || r.For < 0 || r.Repeat < 0 {
+    return Rule{}, fmt.Errorf("%w: %s, %s and %s must not be negative", device.ErrInvalidSpec, specFieldHysteresis, specFieldFor, specFieldRepeat)
+  }
+
+  if r.Name == "" {
+    r.Name = r.defaultName()
+  }
+
+  return r, nil
+}
+
+// e.g. TemperatureHigh, BatteryLow or NoReading.
+func (r Rule) defaultName() string {
+  if r.Metric == MetricAbsent {
+    return "NoReading"
+  }
+
+  name := strings.ToUpper(string(r.Metric[:1])) + string(r.Metric[1:])
+
+  if r.Metric == MetricRSSI {
+    name = "RSSI"
+  }
+
+  if r.Above {
+    return name + "High"
+  }
+
+  return name + "Low"
+}
+
+// Whether the rule applies to the named device.
+func (r Rule) appliesTo(name string) bool {
+  return r.Device == "" || r.Device == name
+}
+
+// The value of the metric in the reading, if available.
+func (r Rule) value(reading device.Reading) (float64, bool) {
+  switch r.Metric {
+  case MetricTemperature:
+    if r.Probe < len(reading.Temperatures) {
+      return float64(reading.Temperatures[r.Probe]), true
+    }
+  case MetricHumidity:
+    return float64(reading.RelativeHumidity), reading.HasHumidity
+  case MetricBattery:
+    return float64(reading.BatteryLevel), reading.HasBatteryLevel
+  case MetricRSSI:
+    return float64(reading.RSSI), reading.HasRSSI
+  }
+
+  return 0, false
+}
+
+// Whether the value crosses the threshold. Once firing, the value must go back past the
+// hysteresis for the alert to resolve.
+func (r Rule) crossed(v float64, firing bool) bool {
+  threshold := r.Threshold
+
+  if firing {
+    if r.Above {
+      threshold -= r.Hysteresis
+    } else {
+      threshold += r.Hysteresis
+    }
+  }
+
+  if r.Above {
+    return v > threshold
+  }
+
+  return v < threshold
+}
+
+func (r Rule) unit() string {
+  switch r.Metric {
+  case MetricTemperature:
+    return "°C"
+  case MetricHumidity, MetricBattery:
+    return "%"
+  case MetricRSSI:
+    return " dBm"
+  }
+
+  return ""
+}
@@ -0,0 +1,234 @@
+package alert
+
+import (
+  "bytes"
+  "context"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/url"
+  "time"
+
+  "github.com/prometheus/common/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+)
+
+// Payload format of a webhook.
+type Format string
+
+const (
+  // A single event, see GenericPayload.
+  FormatGeneric Format = "generic"
+  // The payload sent by Alertmanager to its webhook receivers (version 4), for tools already
+  // integrated with it.
+  FormatAlertmanager Format = "alertmanager"
+)
+
+const (
+  webhookFieldURL = "url"
+  webhookFieldFormat = "format"
+  webhookFieldTimeout = "timeout"
+  webhookHeaderPrefix = "header."
+
+  // Receiver of the Alertmanager payloads.
+  alertmanagerReceiver = "inkbird-exporter"
+)
+
+// WebhookSchema lists the spec parameters of a webhook. Headers can be added to the requests with
+// 'header.<name>=<value>'.
+var WebhookSchema = device.Schema{
+  {
+    Name: webhookFieldURL,
+    Type: device.ParamTypeString,
+    Required: true,
+    Description: "URL receiving the alert events in POST requests",
+  },
+  {
+    Name: webhookFieldFormat,
+    Type: device.ParamTypeString,
+    Default: string(FormatGeneric),
+    AllowedValues: []string{string(FormatGeneric), string(FormatAlertmanager)},
+    Description: "Payload format: a single event, or the payload Alertmanager sends to webhook receivers",
+  },
+  {
+    Name: webhookFieldTimeout,
+    Type: device.ParamTypeDuration,
+    Default: "10s",
+    Description: "Timeout of each request",
+  },
+}
+
+// GenericPayload is the body of FormatGeneric requests.
+type GenericPayload struct {
+  Status Status `json:"status"`
+  Alert string `json:"alert"`
+  Device string `json:"device"`
+  Metric Metric `json:"metric"`
+  Probe *int `json:"probe,omitempty"`
+  Value *float64 `json:"value,omitempty"`
+  Threshold *float64 `json:"threshold,omitempty"`
+  Summary string `json:"summary"`
+  Labels map[string]string `json:"labels"`
+  StartsAt time.Time `json:"starts_at"`
+  EndsAt *time.Time `json:"ends_at,omitempty"`
+}
+
+type alertmanagerPayload struct {
+  Version string `json:"version"`
+  GroupKey string `json:"groupKey"`
+  TruncatedAlerts int `json:"truncatedAlerts"`
+  Status Status `json:"status"`
+  Receiver string `json:"receiver"`
+  GroupLabels map[string]string `json:"groupLabels"`
+  CommonLabels map[string]string `json:"commonLabels"`
+  CommonAnnotations map[string]string `json:"commonAnnotations"`
+  ExternalURL string `json:"externalURL"`
+  Alerts []alertmanagerAlert `json:"alerts"`
+}
+
+type alertmanagerAlert struct {
+  Status Status `json:"status"`
+  Labels map[string]string `json:"labels"`
+  Annotations map[string]string `json:"annotations"`
+  StartsAt time.Time `json:"startsAt"`
+  EndsAt time.Time `json:"endsAt"`
+  GeneratorURL string `json:"generatorURL"`
+  Fingerprint string `json:"fingerprint"`
+}
+
+// Webhook posts alert events to a URL.
+type Webhook struct {
+  url string
+  format Format
+  headers map[string]string
+  client *http.Client
+}
+
+// NewWebhookFromSpec creates a webhook from its spec, parsed with WebhookSchema.
+func NewWebhookFromSpec(spec device.DeviceSpec) (*Webhook, error) {
+  headers := spec.ExtractPrefixed(webhookHeaderPrefix)
+  params, err := WebhookSchema.Parse(spec)
+
+  if err != nil {
+    return nil, err
+  }
+
+  u, err := url.Parse(params.String(webhookFieldURL))
+
+  if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+    return nil, fmt.Errorf("%w: %s must be an absolute http(s) URL", device.ErrInvalidSpec, webhookFieldURL)
+  }
+
+  return &Webhook{
+    url: u.String(),
+    format: Format(params.String(webhookFieldFormat)),
+    headers: headers,
+    client: &http.Client{Timeout: params.Duration(webhookFieldTimeout)},
+  }, nil
+}
+
+// *Notifier
+func (w *Webhook) Notify(ctx context.Context, ev Event) error {
+  var body any
+
+  if w.format == FormatAlertmanager {
+    body = newAlertmanagerPayload(ev)
+  } else {
+    body = newGenericPayload(ev)
+  }
+
+  data, err := json.Marshal(body)
+
+  if err != nil {
+    return err
+  }
+
+  req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(data))
+
+  if err != nil {
+    return err
+  }
+
+  req.Header.Set("Content-Type", "application/json")
+
+  for k, v := range w.headers {
+    req.Header.Set(k, v)
+  }
+
+  resp, err := w.client.Do(req)
+
+  if err != nil {
+    return err
+  }
+
+  defer resp.Body.Close()
+
+  if resp.StatusCode < 200 || resp.StatusCode > 299 {
+    return fmt.Errorf("unexpected status %v", resp.Status)
+  }
+
+  return nil
+}
+
+func newGenericPayload(ev Event) GenericPayload {
+  p := GenericPayload{
+    Status: ev.Status,
+    Alert: ev.Rule.Name,
+    Device: ev.Device,
+    Metric: ev.Rule.Metric,
+    Value: ev.Value,
+    Summary: ev.Summary,
+    Labels: ev.Labels,
+    StartsAt: ev.StartsAt,
+  }
+
+  if ev.Rule.Metric == MetricTemperature {
+    probe := ev.Rule.Probe
+    p.Probe = &probe
+  }
+
+  if ev.Rule.Metric != MetricAbsent {
+    threshold := ev.Rule.Threshold
+    p.Threshold = &threshold
+  }
+
+  if !ev.EndsAt.IsZero() {
+    p.EndsAt = &ev.EndsAt
+  }
+
+  return p
+}
+
+func newAlertmanagerPayload(ev Event) alertmanagerPayload {
+  annotations := map[string]string{"summary": ev.Summary}
+
+  if ev.Value != nil {
+    annotations["value"] = fmt.Sprintf("%g", *ev.Value)
+  }
+
+  labels := make(model.LabelSet, len(ev.Labels))
+
+  for k, v := range ev.Labels {
+    labels[model.LabelName(k)] = model.LabelValue(v)
+  }
+
+  groupLabels := map[string]string{"alertname": ev.Rule.Name}
+
+  return alertmanagerPayload{
+    Version: "4",
+    GroupKey: fmt.Sprintf("{}:{alertname=%q}", ev.Rule.Name),
+    Status: ev.Status,
+    Receiver: alertmanagerReceiver,
+    GroupLabels: groupLabels,
+    CommonLabels: ev.Labels,
+    CommonAnnotations: annotations,
+    Alerts: []alertmanagerAlert{{
+      Status: ev.Status,
+      Labels: ev.Labels,
+      Annotations: annotations,
+      StartsAt: ev.StartsAt,
+      EndsAt: ev.EndsAt,
+      Fingerprint: labels.Fingerprint().String(),
+    }},
+  }
+}
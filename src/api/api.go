@@ -0,0 +1,116 @@
+// Package api serves the HTTP API of the exporter under /api/v1/.
+package api
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/url"
+  "strings"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/history"
+  "github.com/rs/zerolog/log"
+)
+
+const Prefix = "/api/v1/"
+
+// CSRFHeader must be set, to any value, on requests changing the state of the exporter. Browsers
+// only send custom headers to other origins after a CORS preflight, which the API never allows:
+// pages from other sites can't forge such requests, e.g. by submitting a form.
+const CSRFHeader = "X-Requested-By"
+
+type api struct {
+  coll *collector.Recurring
+  // nil if the history is disabled.
+  history *history.Store
+  // nil if streaming is disabled.
+  stream *Stream
+}
+
+// Handler serves the API, to be mounted on Prefix. store and stream may be nil. stream must be
+// added as a sink of coll.
+func Handler(coll *collector.Recurring, store *history.Store, stream *Stream) http.Handler {
+  a := &api{coll, store, stream}
+
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    path, ok := splitPath(r.URL)
+
+    if !ok {
+      writeError(w, http.StatusBadRequest, "malformed path")
+      return
+    }
+
+    switch {
+    case len(path) == 1 && path[0] == "stream":
+      a.serveStream(w, r)
+    case len(path) == 1 && path[0] == "devices":
+      a.serveDevices(w, r)
+    case len(path) == 3 && path[0] == "devices" && path[2] == "reading":
+      a.serveReading(w, r, path[1])
+    case len(path) == 3 && path[0] == "devices" && path[2] == "burst":
+      a.serveBurst(w, r, path[1])
+    case len(path) == 3 && path[0] == "devices" && path[2] == "history":
+      a.serveHistory(w, r, path[1])
+    default:
+      writeError(w, http.StatusNotFound, "not found")
+    }
+  })
+}
+
+// The unescaped segments of the path after Prefix.
+func splitPath(u *url.URL) (segments []string, ok bool) {
+  path, found := strings.CutPrefix(u.EscapedPath(), Prefix)
+
+  if !found {
+    return nil, false
+  }
+
+  for _, segment := range strings.Split(strings.TrimSuffix(path, "/"), "/") {
+    unescaped, err := url.PathUnescape(segment)
+
+    if err != nil {
+      return nil, false
+    }
+
+    segments = append(segments, unescaped)
+  }
+
+  return segments, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+
+  if err := json.NewEncoder(w).Encode(v); err != nil {
+    log.Debug().Err(err).Msg("api: failed to write response")
+  }
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+  writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// Reply with 403 to requests without CSRFHeader. Returns whether the request can be served.
+func requireCSRFHeader(w http.ResponseWriter, r *http.Request) bool {
+  if r.Header.Get(CSRFHeader) != "" {
+    return true
+  }
+
+  writeError(w, http.StatusForbidden, "missing " + CSRFHeader + " header")
+
+  return false
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+  for _, m := range methods {
+    if r.Method == m {
+      return true
+    }
+  }
+
+  w.Header().Set("Allow", strings.Join(methods, ", "))
+  writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+
+  return false
+}
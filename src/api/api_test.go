@@ -0,0 +1,312 @@
+package api_test
+
+import (
+  "bufio"
+  "context"
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/gorilla/websocket"
+  "github.com/robertof/go-inkbird-exporter/api"
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/history"
+  "github.com/robertof/go-inkbird-exporter/internal/testutil"
+  "github.com/robertof/go-inkbird-exporter/sink"
+)
+
+func do(t *testing.T, h http.Handler, method, path string, form url.Values) (int, api.BurstResponse) {
+  t.Helper()
+
+  req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+  req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+  req.Header.Set(api.CSRFHeader, "test")
+
+  rec := httptest.NewRecorder()
+  h.ServeHTTP(rec, req)
+
+  var resp api.BurstResponse
+  json.Unmarshal(rec.Body.Bytes(), &resp)
+
+  return rec.Code, resp
+}
+
+func TestBurst(t *testing.T) {
+  coll := collector.NewRecurring(nil, []device.Device{testutil.FakeDevice("bbq pit")})
+  h := api.Handler(coll, nil, nil)
+  path := api.Prefix + "devices/bbq%20pit/burst"
+
+  code, resp := do(t, h, http.MethodPost, path, url.Values{"interval": {"5s"}, "duration": {"1h"}})
+
+  if code != http.StatusOK || !resp.Active || resp.Interval != "5s" {
+    t.Fatalf("POST: got %d %+v, wanted an active burst every 5s", code, resp)
+  }
+
+  if code, resp := do(t, h, http.MethodGet, path, nil); code != http.StatusOK || !resp.Active {
+    t.Fatalf("GET: got %d %+v, wanted an active burst", code, resp)
+  }
+
+  if code, resp := do(t, h, http.MethodDelete, path, nil); code != http.StatusOK || resp.Active {
+    t.Fatalf("DELETE: got %d %+v, wanted an inactive burst", code, resp)
+  }
+
+  if _, ok := coll.Bursts()[testutil.FakeDevice("bbq pit")]; ok {
+    t.Fatal("burst still active after DELETE")
+  }
+
+  if code, _ := do(t, h, http.MethodPost, path, url.Values{"interval": {"5s"}}); code != http.StatusBadRequest {
+    t.Fatalf("POST without duration: got %d, wanted %d", code, http.StatusBadRequest)
+  }
+
+  if code, _ := do(t, h, http.MethodGet, api.Prefix + "devices/unknown/burst", nil); code != http.StatusNotFound {
+    t.Fatalf("GET on an unknown device: got %d, wanted %d", code, http.StatusNotFound)
+  }
+
+  // as submitted by a form on another site.
+  rec := httptest.NewRecorder()
+  req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("interval=1s&duration=1h"))
+  req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+  h.ServeHTTP(rec, req)
+
+  if rec.Code != http.StatusForbidden {
+    t.Fatalf("POST without %s: got %d, wanted %d", api.CSRFHeader, rec.Code, http.StatusForbidden)
+  }
+}
+
+func TestHistory(t *testing.T) {
+  coll := collector.NewRecurring(nil, []device.Device{testutil.FakeDevice("freezer")})
+  store, err := history.Open(filepath.Join(t.TempDir(), "history.db"), history.Options{Retention: time.Hour})
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  defer store.Close()
+
+  at := time.Now().Add(-10 * time.Minute)
+  err = store.Deliver(context.Background(), []collector.SinkReading{{
+    Device: testutil.FakeDevice("freezer"),
+    Sample: model.Sample{Reading: device.Reading{Temperatures: []float32{-18}}, Time: at},
+  }})
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  h := api.Handler(coll, store, nil)
+  get := func(path string) (int, api.HistoryResponse) {
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+    var resp api.HistoryResponse
+    json.Unmarshal(rec.Body.Bytes(), &resp)
+
+    return rec.Code, resp
+  }
+
+  code, resp := get(api.Prefix + "devices/freezer/history?from=1h&resolution=hour")
+
+  if code != http.StatusOK || len(resp.Series) != 1 || resp.Series[0].Points[0].Value != -18 {
+    t.Fatalf("got %d %+v, wanted the temperature of the freezer", code, resp)
+  }
+
+  if code, _ := get(api.Prefix + "devices/freezer/history?from=yesterday"); code != http.StatusBadRequest {
+    t.Errorf("invalid from: got %d, wanted %d", code, http.StatusBadRequest)
+  }
+
+  if code, _ := get(api.Prefix + "devices/freezer/history?from=1h&to=2h"); code != http.StatusBadRequest {
+    t.Errorf("from after to: got %d, wanted %d", code, http.StatusBadRequest)
+  }
+
+  if code, _ := get(api.Prefix + "devices/unknown/history"); code != http.StatusNotFound {
+    t.Errorf("unknown device: got %d, wanted %d", code, http.StatusNotFound)
+  }
+}
+
+func TestDevices(t *testing.T) {
+  coll := collector.NewRecurring(nil, []device.Device{testutil.FakeDevice("fridge"), testutil.FakeDevice("freezer")})
+  coll.Update(map[device.Device]device.Reading{testutil.FakeDevice("fridge"): {Temperatures: []float32{4}}})
+
+  h := api.Handler(coll, nil, nil)
+  get := func(path string, v any) int {
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+    json.Unmarshal(rec.Body.Bytes(), v)
+
+    return rec.Code
+  }
+
+  var devices api.DevicesResponse
+
+  if code := get(api.Prefix + "devices", &devices); code != http.StatusOK || len(devices.Devices) != 2 {
+    t.Fatalf("got %d %+v, wanted both devices", code, devices)
+  }
+
+  if d := devices.Devices[1]; d.Name != "freezer" || d.Health.Status != api.HealthUnknown || d.Health.Breaker != "closed" {
+    t.Errorf("unexpected device %+v", d)
+  }
+
+  var reading api.ReadingResponse
+
+  if code := get(api.Prefix + "devices/fridge/reading", &reading); code != http.StatusOK || reading.Reading == nil || reading.Reading.Temperatures[0] != 4 {
+    t.Fatalf("got %d %+v, wanted the temperature of the fridge", code, reading)
+  }
+
+  reading = api.ReadingResponse{}
+
+  if code := get(api.Prefix + "devices/freezer/reading", &reading); code != http.StatusOK || reading.Reading != nil {
+    t.Errorf("got %d %+v, wanted no reading yet", code, reading)
+  }
+
+  if code := get(api.Prefix + "devices/unknown/reading", &reading); code != http.StatusNotFound {
+    t.Errorf("unknown device: got %d, wanted %d", code, http.StatusNotFound)
+  }
+}
+
+func TestStream(t *testing.T) {
+  coll := collector.NewRecurring(nil, []device.Device{testutil.FakeDevice("fridge"), testutil.FakeDevice("freezer")})
+  coll.Update(map[device.Device]device.Reading{testutil.FakeDevice("fridge"): {Temperatures: []float32{4}}})
+
+  stream := api.NewStream()
+  srv := httptest.NewServer(api.Handler(coll, nil, stream))
+  defer srv.Close()
+
+  deliver := func(name string, temp float32) {
+    err := stream.Deliver(context.Background(), []collector.SinkReading{{
+      Device: testutil.FakeDevice(name),
+      Sample: model.Sample{Reading: device.Reading{Temperatures: []float32{temp}}, Time: time.Now()},
+    }})
+
+    if err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  decode := func(data []byte) sink.Reading {
+    var r sink.Reading
+
+    if err := json.Unmarshal(data, &r); err != nil {
+      t.Fatalf("invalid reading %q: %v", data, err)
+    }
+
+    return r
+  }
+
+  t.Run("events", func(t *testing.T) {
+    resp, err := http.Get(srv.URL + api.Prefix + "stream?device=fridge")
+
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    defer resp.Body.Close()
+
+    if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+      t.Fatalf("got content type %q", ct)
+    }
+
+    var events []sink.Reading
+    lines := bufio.NewScanner(resp.Body)
+
+    for len(events) < 2 && lines.Scan() {
+      if data, ok := strings.CutPrefix(lines.Text(), "data: "); ok {
+        events = append(events, decode([]byte(data)))
+      } else if lines.Text() == ": connected" {
+        // the freezer is filtered out.
+        deliver("freezer", -18)
+        deliver("fridge", 5)
+      }
+    }
+
+    if len(events) != 2 || events[0].Temperatures[0] != 4 || events[1].Device != "fridge" || events[1].Temperatures[0] != 5 {
+      t.Errorf("got events %+v, wanted the latest and the new reading of the fridge", events)
+    }
+  })
+
+  t.Run("websocket", func(t *testing.T) {
+    conn, _, err := websocket.DefaultDialer.Dial("ws" + strings.TrimPrefix(srv.URL, "http") + api.Prefix + "stream", nil)
+
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    defer conn.Close()
+    conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+    if _, data, err := conn.ReadMessage(); err != nil || decode(data).Device != "fridge" {
+      t.Fatalf("got %s, %v, wanted the latest reading of the fridge", data, err)
+    }
+
+    deliver("freezer", -18)
+
+    if _, data, err := conn.ReadMessage(); err != nil || decode(data).Temperatures[0] != -18 {
+      t.Fatalf("got %s, %v, wanted the new reading of the freezer", data, err)
+    }
+  })
+
+  if code, _ := do(t, api.Handler(coll, nil, stream), http.MethodGet, api.Prefix + "stream?device=unknown", nil); code != http.StatusNotFound {
+    t.Errorf("unknown device: got %d, wanted %d", code, http.StatusNotFound)
+  }
+}
+
+type fakeAdapter struct {
+  err error
+}
+
+func (a fakeAdapter) Check() error { return a.err }
+
+func TestHealthAndReadiness(t *testing.T) {
+  coll := collector.NewRecurring(nil, []device.Device{testutil.FakeDevice("fridge"), testutil.FakeDevice("freezer")})
+  coll.Update(map[device.Device]device.Reading{testutil.FakeDevice("fridge"): {Temperatures: []float32{4}}})
+
+  get := func(h http.Handler, v any) int {
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+    json.Unmarshal(rec.Body.Bytes(), v)
+
+    return rec.Code
+  }
+
+  var health api.HealthResponse
+
+  if code := get(api.Healthz(coll, fakeAdapter{}), &health); code != http.StatusOK || len(health.Devices) != 2 {
+    t.Errorf("healthy adapter: got %d %+v", code, health)
+  }
+
+  health = api.HealthResponse{}
+
+  if code := get(api.Healthz(coll, fakeAdapter{errors.New("gone")}), &health); code != http.StatusServiceUnavailable || health.Adapter.Error != "gone" {
+    t.Errorf("failing adapter: got %d %+v", code, health)
+  }
+
+  coll.StaleAfter = time.Hour
+  opts := api.ReadyOptions{MinFreshRatio: 0.5}
+
+  var ready api.ReadyResponse
+
+  // the freezer was never collected.
+  if code := get(api.Readyz(coll, opts), &ready); code != http.StatusServiceUnavailable || ready.InitialCollectionDone || ready.FreshDevices != 1 {
+    t.Errorf("before the initial collection: got %d %+v", code, ready)
+  }
+
+  coll.Update(map[device.Device]device.Reading{testutil.FakeDevice("freezer"): {Temperatures: []float32{-18}}})
+  ready = api.ReadyResponse{}
+
+  if code := get(api.Readyz(coll, opts), &ready); code != http.StatusOK || !ready.Devices[1].Fresh {
+    t.Errorf("after the initial collection: got %d %+v", code, ready)
+  }
+
+  coll.StaleAfter = time.Nanosecond
+
+  if code := get(api.Readyz(coll, opts), &ready); code != http.StatusServiceUnavailable || ready.FreshDevices != 0 {
+    t.Errorf("stale readings: got %d %+v", code, ready)
+  }
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+  "errors"
+  "net/http"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+)
+
+// BurstResponse describes the burst of a device.
+type BurstResponse struct {
+  Device string `json:"device"`
+  Active bool `json:"active"`
+  Interval string `json:"interval,omitempty"`
+  Until *time.Time `json:"until,omitempty"`
+}
+
+// GET returns the burst of the device, POST starts one with the "interval" and "duration" form
+// values, DELETE stops it. POST and DELETE require CSRFHeader.
+func (a *api) serveBurst(w http.ResponseWriter, r *http.Request, name string) {
+  if !allowMethods(w, r, http.MethodGet, http.MethodPost, http.MethodDelete) {
+    return
+  }
+
+  if r.Method != http.MethodGet && !requireCSRFHeader(w, r) {
+    return
+  }
+
+  switch r.Method {
+  case http.MethodGet:
+    dev, err := a.coll.Device(name)
+
+    if err != nil {
+      writeBurstError(w, err)
+      return
+    }
+
+    if burst, ok := a.coll.Bursts()[dev]; ok {
+      writeJSON(w, http.StatusOK, burstResponse(name, burst))
+    } else {
+      writeJSON(w, http.StatusOK, BurstResponse{Device: name})
+    }
+  case http.MethodPost:
+    interval, err := time.ParseDuration(r.FormValue("interval"))
+
+    if err != nil {
+      writeError(w, http.StatusBadRequest, "invalid interval: " + err.Error())
+      return
+    }
+
+    duration, err := time.ParseDuration(r.FormValue("duration"))
+
+    if err != nil {
+      writeError(w, http.StatusBadRequest, "invalid duration: " + err.Error())
+      return
+    }
+
+    burst, err := a.coll.StartBurst(name, interval, duration)
+
+    if err != nil {
+      writeBurstError(w, err)
+      return
+    }
+
+    writeJSON(w, http.StatusOK, burstResponse(name, burst))
+  case http.MethodDelete:
+    if _, err := a.coll.StopBurst(name); err != nil {
+      writeBurstError(w, err)
+      return
+    }
+
+    writeJSON(w, http.StatusOK, BurstResponse{Device: name})
+  }
+}
+
+func burstResponse(name string, burst collector.Burst) BurstResponse {
+  return BurstResponse{
+    Device: name,
+    Active: true,
+    Interval: burst.Interval.String(),
+    Until: &burst.Until,
+  }
+}
+
+func writeBurstError(w http.ResponseWriter, err error) {
+  switch {
+  case errors.Is(err, collector.ErrUnknownDevice):
+    writeError(w, http.StatusNotFound, err.Error())
+  case errors.Is(err, collector.ErrBurstUnavailable):
+    writeError(w, http.StatusConflict, err.Error())
+  default:
+    writeError(w, http.StatusBadRequest, err.Error())
+  }
+}
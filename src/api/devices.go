@@ -0,0 +1,145 @@
+package api
+
+import (
+  "errors"
+  "net/http"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/sink"
+)
+
+// Health of a device, as reported by the API.
+const (
+  HealthOK = "ok"
+  // the last collection of the device failed.
+  HealthFailing = "failing"
+  // the device hasn't been collected yet.
+  HealthUnknown = "unknown"
+  // the device is collected by another replica, see -ha-peer.
+  HealthDisabled = "disabled"
+)
+
+// DeviceHealth is the outcome of the last collection of a device.
+type DeviceHealth struct {
+  Status string `json:"status"`
+  LastAttempt *time.Time `json:"last_attempt,omitempty"`
+  LastError string `json:"last_error,omitempty"`
+  ConsecutiveFailures int `json:"consecutive_failures"`
+  Breaker string `json:"breaker"`
+  // Age after which the readings of the device are stale, see collector.DeviceStatus.StaleAfter.
+  // Unset while the schedule of the device suspends its collection.
+  StaleAfterSeconds *float64 `json:"stale_after_seconds,omitempty"`
+}
+
+// DeviceResponse describes a configured device.
+type DeviceResponse struct {
+  Name string `json:"name"`
+  Addr string `json:"addr"`
+  // "passive" if the device is read from its advertisements, "active" if connected to.
+  Backend string `json:"backend"`
+  Labels map[string]string `json:"labels,omitempty"`
+  Health DeviceHealth `json:"health"`
+}
+
+// DevicesResponse lists the configured devices.
+type DevicesResponse struct {
+  Devices []DeviceResponse `json:"devices"`
+}
+
+// ReadingResponse holds the latest reading of a device.
+type ReadingResponse struct {
+  Device string `json:"device"`
+  // nil until the device is collected successfully. Its time is the capture time.
+  Reading *sink.Reading `json:"reading"`
+  Health DeviceHealth `json:"health"`
+}
+
+// GET lists the configured devices, in configuration order.
+func (a *api) serveDevices(w http.ResponseWriter, r *http.Request) {
+  if !allowMethods(w, r, http.MethodGet) {
+    return
+  }
+
+  resp := DevicesResponse{Devices: []DeviceResponse{}}
+
+  for _, st := range a.coll.Devices() {
+    resp.Devices = append(resp.Devices, DeviceResponse{
+      Name: st.Device.Name(),
+      Addr: st.Device.Addr().String(),
+      Backend: backendType(st.Device.Backend()),
+      Labels: st.Labels,
+      Health: newDeviceHealth(st),
+    })
+  }
+
+  writeJSON(w, http.StatusOK, resp)
+}
+
+// GET returns the latest reading of the device. A reading is returned even if later collections
+// failed: see the health to tell whether it's stale.
+func (a *api) serveReading(w http.ResponseWriter, r *http.Request, name string) {
+  if !allowMethods(w, r, http.MethodGet) {
+    return
+  }
+
+  st, err := a.coll.Status(name)
+
+  if errors.Is(err, collector.ErrUnknownDevice) {
+    writeError(w, http.StatusNotFound, err.Error())
+    return
+  }
+
+  resp := ReadingResponse{Device: name, Health: newDeviceHealth(st)}
+
+  if sample, ok := a.coll.Latest(r.Context())[st.Device]; ok {
+    reading := sink.NewReading(collector.SinkReading{Device: st.Device, Sample: sample})
+    resp.Reading = &reading
+  }
+
+  writeJSON(w, http.StatusOK, resp)
+}
+
+func newDeviceHealth(st collector.DeviceStatus) DeviceHealth {
+  h := DeviceHealth{
+    Status: HealthOK,
+    ConsecutiveFailures: st.ConsecutiveFailures,
+    Breaker: st.Breaker.String(),
+  }
+
+  if !st.LastAttempt.IsZero() {
+    h.LastAttempt = &st.LastAttempt
+  }
+
+  if !st.Suspended && st.StaleAfter > 0 {
+    staleAfter := st.StaleAfter.Seconds()
+    h.StaleAfterSeconds = &staleAfter
+  }
+
+  switch {
+  case !st.Enabled:
+    h.Status = HealthDisabled
+  case st.LastAttempt.IsZero():
+    h.Status = HealthUnknown
+  case st.LastError != nil:
+    h.Status = HealthFailing
+  }
+
+  if st.LastError != nil {
+    h.LastError = st.LastError.Error()
+  }
+
+  return h
+}
+
+func backendType(b device.Backend) string {
+  switch b.(type) {
+  case device.PassiveBackend:
+    return "passive"
+  case device.ActiveBackend:
+    return "active"
+  default:
+    return "unknown"
+  }
+}
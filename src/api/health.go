@@ -0,0 +1,158 @@
+package api
+
+import (
+  "net/http"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+)
+
+// Adapter is the Bluetooth adapter, checked by Healthz(). Implemented by *ble.Handle.
+type Adapter interface {
+  Check() error
+}
+
+// Status of a health or readiness check.
+const (
+  CheckOK = "ok"
+  CheckFailed = "failed"
+)
+
+// HealthResponse is the body of /healthz.
+type HealthResponse struct {
+  Status string `json:"status"`
+  Adapter AdapterHealth `json:"adapter"`
+  Devices []DeviceResponse `json:"devices"`
+}
+
+type AdapterHealth struct {
+  Status string `json:"status"`
+  Error string `json:"error,omitempty"`
+}
+
+// Healthz replies with 200 if the process and the Bluetooth adapter are alive, and 503 otherwise.
+// Failing devices don't make the exporter unhealthy, but are listed in the response along with
+// their health.
+func Healthz(coll *collector.Recurring, adapter Adapter) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if !allowMethods(w, r, http.MethodGet, http.MethodHead) {
+      return
+    }
+
+    resp := HealthResponse{Status: CheckOK, Adapter: AdapterHealth{Status: CheckOK}, Devices: []DeviceResponse{}}
+
+    if err := adapter.Check(); err != nil {
+      resp.Status, resp.Adapter = CheckFailed, AdapterHealth{Status: CheckFailed, Error: err.Error()}
+    }
+
+    for _, st := range coll.Devices() {
+      resp.Devices = append(resp.Devices, DeviceResponse{
+        Name: st.Device.Name(),
+        Addr: st.Device.Addr().String(),
+        Backend: backendType(st.Device.Backend()),
+        Labels: st.Labels,
+        Health: newDeviceHealth(st),
+      })
+    }
+
+    writeJSON(w, statusCode(resp.Status), resp)
+  })
+}
+
+type ReadyOptions struct {
+  // Min share (0 to 1) of the enabled devices with a fresh reading.
+  MinFreshRatio float64
+}
+
+// ReadyResponse is the body of /readyz.
+type ReadyResponse struct {
+  Status string `json:"status"`
+  // Whether every enabled device has been collected at least once, successfully or not.
+  InitialCollectionDone bool `json:"initial_collection_done"`
+  // Whether the collector is suspended or collects on demand: readings are then expected to grow
+  // old, and freshness isn't checked.
+  Idle bool `json:"idle"`
+  EnabledDevices int `json:"enabled_devices"`
+  FreshDevices int `json:"fresh_devices"`
+  MinFreshRatio float64 `json:"min_fresh_ratio"`
+  Devices []DeviceReadiness `json:"devices"`
+}
+
+type DeviceReadiness struct {
+  Name string `json:"name"`
+  Enabled bool `json:"enabled"`
+  Collected bool `json:"collected"`
+  // Whether the reading is recent enough, see collector.DeviceStatus.StaleAfter. Always true while
+  // the schedule of the device suspends its collection.
+  Fresh bool `json:"fresh"`
+  Suspended bool `json:"suspended,omitempty"`
+  LastReading *time.Time `json:"last_reading,omitempty"`
+  AgeSeconds *float64 `json:"age_seconds,omitempty"`
+}
+
+// Readyz replies with 200 once every enabled device has been collected at least once, and as long
+// as enough of them have fresh readings. It replies with 503 otherwise. Devices collected by another
+// replica are not taken into account.
+func Readyz(coll *collector.Recurring, opts ReadyOptions) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if !allowMethods(w, r, http.MethodGet, http.MethodHead) {
+      return
+    }
+
+    now := time.Now()
+    samples := coll.Snapshot()
+    resp := ReadyResponse{
+      InitialCollectionDone: true,
+      Idle: coll.Idle(),
+      MinFreshRatio: opts.MinFreshRatio,
+      Devices: []DeviceReadiness{},
+    }
+
+    for _, st := range coll.Devices() {
+      d := DeviceReadiness{Name: st.Device.Name(), Enabled: st.Enabled, Suspended: st.Suspended}
+      sample, ok := samples[st.Device]
+
+      if ok {
+        age := now.Sub(sample.Time).Seconds()
+        d.LastReading, d.AgeSeconds = &sample.Time, &age
+        d.Fresh = now.Sub(sample.Time) <= st.StaleAfter
+      }
+
+      // readings don't grow stale while the schedule suspends collection.
+      d.Fresh = d.Fresh || st.Suspended
+
+      // readings collected on startup come before the first collection by the collector.
+      d.Collected = ok || !st.LastAttempt.IsZero()
+
+      if st.Enabled {
+        resp.EnabledDevices += 1
+        resp.InitialCollectionDone = resp.InitialCollectionDone && d.Collected
+
+        if d.Fresh {
+          resp.FreshDevices += 1
+        }
+      }
+
+      resp.Devices = append(resp.Devices, d)
+    }
+
+    fresh := resp.Idle || resp.EnabledDevices == 0 ||
+      float64(resp.FreshDevices) >= opts.MinFreshRatio * float64(resp.EnabledDevices)
+
+    resp.Status = CheckFailed
+
+    if resp.InitialCollectionDone && fresh {
+      resp.Status = CheckOK
+    }
+
+    writeJSON(w, statusCode(resp.Status), resp)
+  })
+}
+
+func statusCode(status string) int {
+  if status == CheckOK {
+    return http.StatusOK
+  }
+
+  return http.StatusServiceUnavailable
+}
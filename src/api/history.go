@@ -0,0 +1,96 @@
+package api
+
+import (
+  "errors"
+  "net/http"
+  "strings"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/history"
+)
+
+// HistoryResponse holds the past readings of a device.
+type HistoryResponse struct {
+  Device string `json:"device"`
+  From time.Time `json:"from"`
+  To time.Time `json:"to"`
+  Resolution history.Resolution `json:"resolution"`
+  Series []history.Series `json:"series"`
+}
+
+const defaultHistoryRange = 24 * time.Hour
+
+// GET returns the readings of the device between the "from" and "to" query parameters (the last
+// day by default), at the "resolution" given (raw by default).
+func (a *api) serveHistory(w http.ResponseWriter, r *http.Request, name string) {
+  if !allowMethods(w, r, http.MethodGet) {
+    return
+  }
+
+  if a.history == nil {
+    writeError(w, http.StatusNotFound, "history is disabled, see -history-db")
+    return
+  }
+
+  if _, err := a.coll.Device(name); errors.Is(err, collector.ErrUnknownDevice) {
+    writeError(w, http.StatusNotFound, err.Error())
+    return
+  }
+
+  now := time.Now()
+  resp := HistoryResponse{Device: name, From: now.Add(-defaultHistoryRange), To: now, Resolution: history.ResolutionRaw}
+  q := r.URL.Query()
+
+  for _, param := range []struct {
+    name string
+    t *time.Time
+  }{
+    {"from", &resp.From},
+    {"to", &resp.To},
+  } {
+    if v := q.Get(param.name); v != "" {
+      t, err := parseTime(v, now)
+
+      if err != nil {
+        writeError(w, http.StatusBadRequest, "invalid " + param.name + ": " + err.Error())
+        return
+      }
+
+      *param.t = t
+    }
+  }
+
+  if resp.From.After(resp.To) {
+    writeError(w, http.StatusBadRequest, "invalid range: from is after to")
+    return
+  }
+
+  if v := q.Get("resolution"); v != "" {
+    resp.Resolution = history.Resolution(v)
+  }
+
+  if resp.Resolution != history.ResolutionRaw && resp.Resolution != history.ResolutionHour {
+    writeError(w, http.StatusBadRequest, "invalid resolution: must be one of 'raw' or 'hour'")
+    return
+  }
+
+  series, err := a.history.Query(r.Context(), name, resp.From, resp.To, resp.Resolution)
+
+  if err != nil {
+    writeError(w, http.StatusInternalServerError, err.Error())
+    return
+  }
+
+  resp.Series = series
+  writeJSON(w, http.StatusOK, resp)
+}
+
+// Parse an RFC 3339 time, or a duration before now (e.g. "12h").
+func parseTime(v string, now time.Time) (time.Time, error) {
+  if d, err := time.ParseDuration(strings.TrimPrefix(v, "-")); err == nil {
+    return now.Add(-d), nil
+  }
+
+  return time.Parse(time.RFC3339, v)
+}
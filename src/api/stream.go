@@ -0,0 +1,269 @@
+package api
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/gorilla/websocket"
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/sink"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  // readings waiting to be sent to a subscriber, before it's dropped for falling behind.
+  streamBufferSize = 64
+  streamKeepalive = 30 * time.Second
+  streamWriteTimeout = 10 * time.Second
+)
+
+// Stream fans out new readings to the subscribers of /api/v1/stream. It is a collector.Sink: the
+// collector queues readings for it like for any other sink, so that subscribers never hold up
+// collections.
+type Stream struct {
+  mu sync.Mutex
+  subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+  // names of the devices to receive readings of, every device if nil.
+  devices map[string]bool
+  // encoded readings. closed when the subscriber is dropped for falling behind.
+  readings chan []byte
+}
+
+func NewStream() *Stream {
+  return &Stream{subscribers: make(map[*subscriber]struct{})}
+}
+
+// *collector.Sink
+func (s *Stream) Deliver(ctx context.Context, readings []collector.SinkReading) error {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if len(s.subscribers) == 0 {
+    return nil
+  }
+
+  for _, r := range readings {
+    // encoded once for every subscriber.
+    data, err := json.Marshal(sink.NewReading(r))
+
+    if err != nil {
+      return err
+    }
+
+    for sub := range s.subscribers {
+      if sub.devices != nil && !sub.devices[r.Device.Name()] {
+        continue
+      }
+
+      select {
+      case sub.readings <- data:
+      default:
+        log.Debug().Msg("api: dropping stream subscriber which fell behind")
+
+        delete(s.subscribers, sub)
+        close(sub.readings)
+      }
+    }
+  }
+
+  return nil
+}
+
+func (s *Stream) subscribe(devices map[string]bool) *subscriber {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  sub := &subscriber{devices: devices, readings: make(chan []byte, streamBufferSize)}
+  s.subscribers[sub] = struct{}{}
+
+  return sub
+}
+
+func (s *Stream) unsubscribe(sub *subscriber) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  delete(s.subscribers, sub)
+}
+
+var upgrader = websocket.Upgrader{}
+
+// GET streams readings as they are collected, starting with the latest reading of every device:
+// over a WebSocket if requested, as Server-Sent Events otherwise. The "device" query parameter
+// (repeated, or comma separated) restricts the stream to the given devices.
+func (a *api) serveStream(w http.ResponseWriter, r *http.Request) {
+  if !allowMethods(w, r, http.MethodGet) {
+    return
+  }
+
+  if a.stream == nil {
+    writeError(w, http.StatusNotFound, "stream is disabled")
+    return
+  }
+
+  var devices map[string]bool
+
+  for _, v := range r.URL.Query()["device"] {
+    for _, name := range strings.Split(v, ",") {
+      if _, err := a.coll.Device(name); errors.Is(err, collector.ErrUnknownDevice) {
+        writeError(w, http.StatusNotFound, err.Error())
+        return
+      }
+
+      if devices == nil {
+        devices = make(map[string]bool)
+      }
+
+      devices[name] = true
+    }
+  }
+
+  // subscribe before taking the latest readings, so that none is missed in between.
+  sub := a.stream.subscribe(devices)
+  defer a.stream.unsubscribe(sub)
+
+  latest := a.latestReadings(r.Context(), devices)
+
+  if websocket.IsWebSocketUpgrade(r) {
+    conn, err := upgrader.Upgrade(w, r, nil)
+
+    if err != nil {
+      // Upgrade() already replied.
+      return
+    }
+
+    defer conn.Close()
+    streamWebSocket(r.Context(), conn, latest, sub.readings)
+  } else {
+    streamEvents(w, r, latest, sub.readings)
+  }
+}
+
+// The encoded latest reading of the given devices (every device if nil), in configuration order.
+func (a *api) latestReadings(ctx context.Context, devices map[string]bool) [][]byte {
+  var out [][]byte
+  samples := a.coll.Latest(ctx)
+
+  for _, st := range a.coll.Devices() {
+    sample, ok := samples[st.Device]
+
+    if !ok || (devices != nil && !devices[st.Device.Name()]) {
+      continue
+    }
+
+    data, err := json.Marshal(sink.NewReading(collector.SinkReading{Device: st.Device, Labels: st.Labels, Sample: sample}))
+
+    if err == nil {
+      out = append(out, data)
+    }
+  }
+
+  return out
+}
+
+func streamEvents(w http.ResponseWriter, r *http.Request, latest [][]byte, readings <-chan []byte) {
+  rc := http.NewResponseController(w)
+
+  w.Header().Set("Content-Type", "text/event-stream")
+  w.Header().Set("Cache-Control", "no-cache")
+  w.WriteHeader(http.StatusOK)
+
+  write := func(msg string) bool {
+    // not supported by every ResponseWriter, in which case slow clients are only dropped once
+    // their buffer fills up.
+    rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+
+    if _, err := w.Write([]byte(msg)); err != nil {
+      return false
+    }
+
+    return rc.Flush() == nil
+  }
+
+  for _, data := range latest {
+    if !write("data: " + string(data) + "\n\n") {
+      return
+    }
+  }
+
+  if !write(": connected\n\n") {
+    return
+  }
+
+  keepalive := time.NewTicker(streamKeepalive)
+  defer keepalive.Stop()
+
+  for {
+    select {
+    case <-r.Context().Done():
+      return
+    case <-keepalive.C:
+      if !write(": keepalive\n\n") {
+        return
+      }
+    case data, ok := <-readings:
+      if !ok || !write("data: " + string(data) + "\n\n") {
+        return
+      }
+    }
+  }
+}
+
+func streamWebSocket(ctx context.Context, conn *websocket.Conn, latest [][]byte, readings <-chan []byte) {
+  ctx, cancel := context.WithCancel(ctx)
+  defer cancel()
+
+  // the client isn't expected to send anything, but reading is needed to handle pings and
+  // notice when the connection is closed.
+  go func() {
+    defer cancel()
+
+    for {
+      if _, _, err := conn.NextReader(); err != nil {
+        return
+      }
+    }
+  }()
+
+  write := func(messageType int, data []byte) bool {
+    conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+    return conn.WriteMessage(messageType, data) == nil
+  }
+
+  for _, data := range latest {
+    if !write(websocket.TextMessage, data) {
+      return
+    }
+  }
+
+  keepalive := time.NewTicker(streamKeepalive)
+  defer keepalive.Stop()
+
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case <-keepalive.C:
+      if !write(websocket.PingMessage, nil) {
+        return
+      }
+    case data, ok := <-readings:
+      if !ok {
+        write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "fell behind"))
+        return
+      }
+
+      if !write(websocket.TextMessage, data) {
+        return
+      }
+    }
+  }
+}
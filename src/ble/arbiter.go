@@ -0,0 +1,216 @@
+package ble
+
+import (
+  "context"
+  "fmt"
+  "slices"
+  "sync"
+
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+  queuedConnectionsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+    Name: "inkbird_exporter_ble_queued_connections",
+    Help: "Number of BLE connection attempts waiting for the radio or a free slot.",
+  })
+  scanPreemptionsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+    Name: "inkbird_exporter_ble_scan_preemptions_total",
+    Help: "Total number of scans paused to let a connection attempt through.",
+  })
+)
+
+// ArbitrationMode decides how scans and connection attempts share the radio.
+type ArbitrationMode string
+
+const (
+  // Scans and connection attempts run at the same time.
+  ArbitrationParallel ArbitrationMode = "parallel"
+  // Connection attempts pause running scans, which resume once no attempt is in progress.
+  ArbitrationInterleaved ArbitrationMode = "interleaved"
+  // Scans and connection attempts never overlap, and wait for each other to finish.
+  ArbitrationSequential ArbitrationMode = "sequential"
+)
+
+var allArbitrationModes = []ArbitrationMode{
+  ArbitrationParallel,
+  ArbitrationInterleaved,
+  ArbitrationSequential,
+}
+
+// *flag.Value
+func (m *ArbitrationMode) String() string {
+  return string(*m)
+}
+
+func (m *ArbitrationMode) Set(v string) error {
+  if v == "" {
+    *m = ArbitrationParallel
+    return nil
+  }
+
+  mode := ArbitrationMode(v)
+
+  if !slices.Contains(allArbitrationModes, mode) {
+    return fmt.Errorf("unknown arbitration mode %v (must be one of %v)", mode, allArbitrationModes)
+  }
+
+  *m = mode
+  return nil
+}
+
+// arbiter serializes access to the radio between scans and connection attempts according to the
+// arbitration mode, and caps the number of parallel connection attempts. Callers are queued
+// rather than failed, until their context expires.
+type arbiter struct {
+  mode ArbitrationMode
+  // nil when the number of parallel connection attempts is unbounded.
+  slots chan struct{}
+
+  mu sync.Mutex
+  scans map[*scanLease]struct{}
+  dials, waitingDials int
+  // closed and replaced on every state change to wake up waiters.
+  changed chan struct{}
+}
+
+type scanLease struct {
+  preempt context.CancelFunc
+  preempted bool
+}
+
+func newArbiter(mode ArbitrationMode, maxParallelConnections int) *arbiter {
+  a := &arbiter{
+    mode: mode,
+    scans: make(map[*scanLease]struct{}),
+    changed: make(chan struct{}),
+  }
+
+  if maxParallelConnections > 0 {
+    a.slots = make(chan struct{}, maxParallelConnections)
+  }
+
+  return a
+}
+
+// must be called with mu held.
+func (a *arbiter) notify() {
+  close(a.changed)
+  a.changed = make(chan struct{})
+}
+
+// Wait for the radio to be available for a scan. The returned context is canceled if the scan is
+// preempted by a connection attempt: in that case the scan should be restarted once the radio is
+// available again.
+func (a *arbiter) acquireScan(ctx context.Context) (context.Context, func(), error) {
+  if a.mode == ArbitrationParallel {
+    return ctx, func() {}, nil
+  }
+
+  for {
+    a.mu.Lock()
+
+    if a.dials == 0 && a.waitingDials == 0 {
+      scanCtx, cancel := context.WithCancel(ctx)
+      lease := &scanLease{preempt: cancel}
+      a.scans[lease] = struct{}{}
+      a.mu.Unlock()
+
+      release := func() {
+        cancel()
+
+        a.mu.Lock()
+        defer a.mu.Unlock()
+
+        delete(a.scans, lease)
+        a.notify()
+      }
+
+      return scanCtx, release, nil
+    }
+
+    changed := a.changed
+    a.mu.Unlock()
+
+    select {
+    case <-ctx.Done():
+      return nil, nil, ctx.Err()
+    case <-changed:
+    }
+  }
+}
+
+// Wait for a connection slot and for the radio to be available for a connection attempt,
+// preempting running scans in interleaved mode.
+func (a *arbiter) acquireDial(ctx context.Context) (func(), error) {
+  queuedConnectionsGauge.Inc()
+  defer queuedConnectionsGauge.Dec()
+
+  if a.slots != nil {
+    select {
+    case <-ctx.Done():
+      return nil, ctx.Err()
+    case a.slots <- struct{}{}:
+    }
+  }
+
+  releaseSlot := func() {
+    if a.slots != nil {
+      <-a.slots
+    }
+  }
+
+  if a.mode == ArbitrationParallel {
+    return releaseSlot, nil
+  }
+
+  a.mu.Lock()
+  a.waitingDials += 1
+  a.mu.Unlock()
+
+  for {
+    a.mu.Lock()
+
+    if len(a.scans) == 0 {
+      a.waitingDials -= 1
+      a.dials += 1
+      a.mu.Unlock()
+
+      release := func() {
+        a.mu.Lock()
+        defer a.mu.Unlock()
+
+        a.dials -= 1
+        a.notify()
+        releaseSlot()
+      }
+
+      return release, nil
+    }
+
+    if a.mode == ArbitrationInterleaved {
+      for lease := range a.scans {
+        if !lease.preempted {
+          lease.preempted = true
+          scanPreemptionsCounter.Inc()
+          lease.preempt()
+        }
+      }
+    }
+
+    changed := a.changed
+    a.mu.Unlock()
+
+    select {
+    case <-ctx.Done():
+      a.mu.Lock()
+      a.waitingDials -= 1
+      a.notify()
+      a.mu.Unlock()
+
+      releaseSlot()
+      return nil, ctx.Err()
+    case <-changed:
+    }
+  }
+}
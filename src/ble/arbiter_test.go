@@ -0,0 +1,101 @@
+package ble
+
+import (
+  "context"
+  "errors"
+  "testing"
+  "time"
+)
+
+func TestArbiter_Interleaved(t *testing.T) {
+  a := newArbiter(ArbitrationInterleaved, 0)
+
+  scanCtx, releaseScan, err := a.acquireScan(context.Background())
+  if err != nil {
+    t.Fatalf("acquireScan() got error: %v", err)
+  }
+
+  dialed := make(chan func())
+
+  go func() {
+    release, err := a.acquireDial(context.Background())
+    if err != nil {
+      t.Errorf("acquireDial() got error: %v", err)
+    }
+    dialed <- release
+  }()
+
+  select {
+  case <-scanCtx.Done():
+  case <-time.After(time.Second):
+    t.Fatal("scan was not preempted by the connection attempt")
+  }
+
+  releaseScan()
+  releaseDial := <-dialed
+
+  // scans wait for the connection attempt to be over.
+  ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+  defer cancel()
+
+  if _, _, err := a.acquireScan(ctx); !errors.Is(err, context.DeadlineExceeded) {
+    t.Fatalf("acquireScan() during a connection attempt: got %v, wanted deadline exceeded", err)
+  }
+
+  releaseDial()
+
+  if _, release, err := a.acquireScan(context.Background()); err != nil {
+    t.Fatalf("acquireScan() after the connection attempt got error: %v", err)
+  } else {
+    release()
+  }
+}
+
+func TestArbiter_Sequential(t *testing.T) {
+  a := newArbiter(ArbitrationSequential, 0)
+
+  scanCtx, releaseScan, _ := a.acquireScan(context.Background())
+
+  ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+  defer cancel()
+
+  if _, err := a.acquireDial(ctx); !errors.Is(err, context.DeadlineExceeded) {
+    t.Fatalf("acquireDial() during a scan: got %v, wanted deadline exceeded", err)
+  }
+
+  if scanCtx.Err() != nil {
+    t.Fatal("scan was preempted in sequential mode")
+  }
+
+  releaseScan()
+
+  if release, err := a.acquireDial(context.Background()); err != nil {
+    t.Fatalf("acquireDial() after the scan got error: %v", err)
+  } else {
+    release()
+  }
+}
+
+func TestArbiter_MaxParallelConnections(t *testing.T) {
+  a := newArbiter(ArbitrationParallel, 2)
+
+  release1, _ := a.acquireDial(context.Background())
+  release2, _ := a.acquireDial(context.Background())
+
+  ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+  defer cancel()
+
+  if _, err := a.acquireDial(ctx); !errors.Is(err, context.DeadlineExceeded) {
+    t.Fatalf("third acquireDial(): got %v, wanted deadline exceeded", err)
+  }
+
+  release1()
+
+  if release3, err := a.acquireDial(context.Background()); err != nil {
+    t.Fatalf("acquireDial() after a release got error: %v", err)
+  } else {
+    release3()
+  }
+
+  release2()
+}
@@ -3,6 +3,8 @@ package ble
 import (
   "fmt"
   "net"
+  "sync"
+  "time"
 
   "github.com/go-ble/ble"
   "github.com/go-ble/ble/linux"
@@ -24,8 +26,17 @@ type Client = ble.Client
 type Handle struct {
   dev *linux.Device
   connPool *connectionPool
+  arbiter *arbiter
+
+  // result of the last Check().
+  checkMu sync.Mutex
+  checkedAt time.Time
+  checkErr error
 }
 
+// How long the result of Check() is reused, so that frequent health checks don't flood the adapter.
+const checkTTL = 5 * time.Second
+
 func UUID16(i uint16) ble.UUID {
   return ble.UUID16(i)
 }
@@ -36,6 +47,8 @@ func RegisterMetrics(reg prometheus.Registerer) {
     failedConnectionsCounter,
     connectionsFromPoolCounter,
     disconnectsCounter,
+    queuedConnectionsGauge,
+    scanPreemptionsCounter,
   )
 }
 
@@ -87,6 +100,7 @@ func InitWithConnParams(deviceId int, connParams ConnParams, flags Flags) (*Hand
 
   h := &Handle{
     dev: dev,
+    arbiter: newArbiter(ArbitrationParallel, 0),
   }
 
   if flags & FlagPersistConnections == FlagPersistConnections {
@@ -148,6 +162,43 @@ func (h *Handle) SetAllowListedAddresses(a []net.HardwareAddr) error {
   return nil
 }
 
+// Configure how scans and connection attempts share the radio, and how many connection attempts
+// can run in parallel (0 for no limit). Must be called before scanning or connecting.
+func (h *Handle) SetArbitration(mode ArbitrationMode, maxParallelConnections int) {
+  log.Debug().
+    Str("Mode", string(mode)).
+    Int("MaxParallelConnections", maxParallelConnections).
+    Msg("Configuring radio arbitration")
+
+  h.arbiter = newArbiter(mode, maxParallelConnections)
+}
+
+func (h *Handle) ArbitrationMode() ArbitrationMode {
+  return h.arbiter.mode
+}
+
+// Check that the adapter is alive and responding, by reading its address. Blocks for up to 10
+// seconds if the adapter doesn't respond.
+func (h *Handle) Check() error {
+  h.checkMu.Lock()
+  defer h.checkMu.Unlock()
+
+  if time.Since(h.checkedAt) < checkTTL {
+    return h.checkErr
+  }
+
+  var res cmd.ReadBDADDRRP
+
+  h.checkErr = h.dev.HCI.Send(&cmd.ReadBDADDR{}, &res)
+  h.checkedAt = time.Now()
+
+  if h.checkErr != nil {
+    h.checkErr = fmt.Errorf("bluetooth adapter not responding: %w", h.checkErr)
+  }
+
+  return h.checkErr
+}
+
 func (h *Handle) Stop() {
   h.dev.Stop()
 }
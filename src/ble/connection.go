@@ -2,8 +2,10 @@ package ble
 
 import (
   "context"
+  "fmt"
   "net"
   "sync"
+  "time"
 
   "github.com/go-ble/ble"
   "github.com/prometheus/client_golang/prometheus"
@@ -29,17 +31,33 @@ type connectionPool struct {
   mu sync.Mutex
 
   connections map[string]Client
+  // connection attempts in progress, by address, closed once done: other callers connecting to
+  // the same address wait for them rather than dialing in parallel.
+  dialing map[string]chan struct{}
+  // bumped by DisconnectAll(), so that connections established meanwhile are closed.
+  gen uint64
 }
 
 func initConnectionPool() *connectionPool {
   return &connectionPool{
     connections: make(map[string]ble.Client),
+    dialing: make(map[string]chan struct{}),
   }
 }
 
 func (h *Handle) Connect(ctx context.Context, addr net.HardwareAddr) (Client, error) {
+  return h.ConnectWithTimeout(ctx, addr, 0)
+}
+
+// Like Connect, but the connection attempt is given up after the specified timeout (if positive).
+// The timeout includes waiting for the radio and for a free connection slot.
+func (h *Handle) ConnectWithTimeout(
+  ctx context.Context,
+  addr net.HardwareAddr,
+  timeout time.Duration,
+) (Client, error) {
   if h.connPool == nil {
-    c, err := ble.Dial(ctx, addr)
+    c, err := h.dial(ctx, addr, timeout)
 
     if err == nil {
       successfulConnectionsCounter.Inc()
@@ -53,15 +71,51 @@ func (h *Handle) Connect(ctx context.Context, addr net.HardwareAddr) (Client, er
   addrStr := addr.String()
 
   h.connPool.mu.Lock()
-  defer h.connPool.mu.Unlock()
 
-  if conn := h.connPool.connections[addrStr]; conn != nil {
-    connectionsFromPoolCounter.Inc()
-    log.Trace().Stringer("Addr", addr).Msg("ble: reusing connection from connection pool")
-    return conn, nil
+  for {
+    if conn := h.connPool.connections[addrStr]; conn != nil {
+      h.connPool.mu.Unlock()
+
+      connectionsFromPoolCounter.Inc()
+      log.Trace().Stringer("Addr", addr).Msg("ble: reusing connection from connection pool")
+      return conn, nil
+    }
+
+    dialing, ok := h.connPool.dialing[addrStr]
+
+    if !ok {
+      break
+    }
+
+    // the lock isn't held while dialing: wait for the attempt in progress, then either reuse its
+    // connection or make a new attempt if it failed.
+    h.connPool.mu.Unlock()
+
+    select {
+    case <-ctx.Done():
+      return nil, ctx.Err()
+    case <-dialing:
+    }
+
+    h.connPool.mu.Lock()
   }
 
-  conn, err := ble.Dial(ctx, addr)
+  done, gen := make(chan struct{}), h.connPool.gen
+  h.connPool.dialing[addrStr] = done
+  h.connPool.mu.Unlock()
+
+  conn, err := h.dial(ctx, addr, timeout)
+
+  h.connPool.mu.Lock()
+  defer h.connPool.mu.Unlock()
+
+  delete(h.connPool.dialing, addrStr)
+  close(done)
+
+  if err == nil && gen != h.connPool.gen {
+    conn.CancelConnection()
+    err = fmt.Errorf("connection to %v closed by DisconnectAll() while connecting", addr)
+  }
 
   if err != nil {
     failedConnectionsCounter.Inc()
@@ -93,6 +147,24 @@ func (h *Handle) Connect(ctx context.Context, addr net.HardwareAddr) (Client, er
   return conn, nil
 }
 
+func (h *Handle) dial(ctx context.Context, addr net.HardwareAddr, timeout time.Duration) (Client, error) {
+  if timeout > 0 {
+    var cancel func()
+    ctx, cancel = context.WithTimeout(ctx, timeout)
+    defer cancel()
+  }
+
+  release, err := h.arbiter.acquireDial(ctx)
+
+  if err != nil {
+    return nil, fmt.Errorf("gave up waiting for the radio: %w", err)
+  }
+
+  defer release()
+
+  return ble.Dial(ctx, addr)
+}
+
 // Clear the connection pool (if any) and close all connections.
 func (h *Handle) DisconnectAll() {
   if h.connPool == nil {
@@ -107,4 +179,5 @@ func (h *Handle) DisconnectAll() {
   }
 
   h.connPool.connections = make(map[string]ble.Client)
+  h.connPool.gen += 1
 }
@@ -32,10 +32,12 @@ func (h *Handle) ScanAll(ctx context.Context, onDevice func(Advertisement)) erro
 
 // Perform an active or passive scan for the specified addresses and pass it to
 // an handler that determines whether to accept it - ending scanning for that address -
-// or rejecting it.
+// or rejecting it. onScanning, if not nil, is called with true once the radio is granted to the
+// scan, and with false when the scan releases it or is preempted by a connection attempt.
 func (h *Handle) ScanAddresses(
   parentCtx context.Context,
   addresses []net.HardwareAddr,
+  onScanning func(scanning bool),
   onAdvertisement func(Advertisement) bool,
 ) error {
   addrMap := make(map[string]chan Advertisement)
@@ -111,12 +113,33 @@ func (h *Handle) ScanAddresses(
 
   defer cancel()
 
-  err := h.dev.Scan(ctx, false, callback)
+  for {
+    scanCtx, release, err := h.arbiter.acquireScan(ctx)
 
-  // swallow context.Canceled errors which are caused by our explicit cancellations.
-  if errors.Is(err, context.Canceled) {
-    err = nil
-  }
+    if err == nil {
+      if onScanning != nil {
+        onScanning(true)
+      }
+
+      err = h.dev.Scan(scanCtx, false, callback)
+      release()
 
-  return err
+      if onScanning != nil {
+        onScanning(false)
+      }
+
+      if scanCtx.Err() != nil && ctx.Err() == nil {
+        // preempted by a connection attempt: resume scanning as soon as the radio is free.
+        log.Trace().Msg("ble: scan preempted by connection attempt, waiting to resume")
+        continue
+      }
+    }
+
+    // swallow context.Canceled errors which are caused by our explicit cancellations.
+    if errors.Is(err, context.Canceled) {
+      err = nil
+    }
+
+    return err
+  }
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "flag"
+  "fmt"
+  "net/http"
+  "net/url"
+  "os"
+  "os/signal"
+  "strings"
+  "syscall"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/api"
+)
+
+// The "burst" command puts devices of a running exporter into burst mode through its HTTP API.
+// Returns the exit status.
+func runBurstCommand(args []string) int {
+  fs := flag.NewFlagSet("burst", flag.ExitOnError)
+
+  fs.Usage = func() {
+    fmt.Fprintf(fs.Output(), "Usage: %s burst [flags] <device name>...\n\n", os.Args[0])
+    fmt.Fprintln(fs.Output(), "Temporarily collect the named devices of a running exporter every -interval, for -duration.")
+    fs.PrintDefaults()
+  }
+
+  addr := fs.String("bind", "localhost:9102", "Address of the running exporter")
+  interval := fs.Duration("interval", 5 * time.Second, "Collection interval during the burst")
+  duration := fs.Duration("duration", 15 * time.Minute, "How long the burst lasts")
+  stop := fs.Bool("stop", false, "Stop the burst of the devices instead")
+
+  fs.Parse(args)
+
+  if fs.NArg() == 0 {
+    fs.Usage()
+    return 2
+  }
+
+  // interrupting the command only cancels the pending requests.
+  ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+  defer cancel()
+
+  client := &http.Client{Timeout: 10 * time.Second}
+  status := 0
+
+  for _, name := range fs.Args() {
+    method, form := http.MethodPost, url.Values{
+      "interval": {interval.String()},
+      "duration": {duration.String()},
+    }
+
+    if *stop {
+      method, form = http.MethodDelete, nil
+    }
+
+    burst, err := requestBurst(ctx, client, method, *addr, name, form)
+
+    switch {
+    case err != nil:
+      fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+      status = 1
+    case burst.Active:
+      fmt.Printf("%s: bursting every %s until %s\n", name, burst.Interval, burst.Until.Format(time.RFC3339))
+    default:
+      fmt.Printf("%s: not bursting\n", name)
+    }
+  }
+
+  return status
+}
+
+func requestBurst(
+  ctx context.Context,
+  client *http.Client,
+  method, addr, name string,
+  form url.Values,
+) (burst api.BurstResponse, err error) {
+  u := "http://" + addr + api.Prefix + "devices/" + url.PathEscape(name) + "/burst"
+
+  req, err := http.NewRequestWithContext(ctx, method, u, strings.NewReader(form.Encode()))
+
+  if err != nil {
+    return burst, err
+  }
+
+  req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+  req.Header.Set(api.CSRFHeader, "go-inkbird-exporter")
+
+  resp, err := client.Do(req)
+
+  if err != nil {
+    return burst, err
+  }
+
+  defer resp.Body.Close()
+
+  if resp.StatusCode != http.StatusOK {
+    var apiErr struct {
+      Error string `json:"error"`
+    }
+
+    if json.NewDecoder(resp.Body).Decode(&apiErr) != nil || apiErr.Error == "" {
+      apiErr.Error = resp.Status
+    }
+
+    return burst, fmt.Errorf("%s", apiErr.Error)
+  }
+
+  err = json.NewDecoder(resp.Body).Decode(&burst)
+
+  return burst, err
+}
@@ -0,0 +1,66 @@
+package collector
+
+import (
+  "math"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+)
+
+// AdaptiveOptions make the interval of a device follow how fast its readings change: the
+// interval is halved, down to MinInterval, whenever a reading changes faster than Threshold, and
+// grows by half, up to MaxInterval, whenever it changes slower.
+type AdaptiveOptions struct {
+  // Change per minute of any temperature (in Celsius) or of the relative humidity (in percentage
+  // points) above which readings are considered to be changing. Zero disables adaptive intervals.
+  Threshold float64
+  MinInterval, MaxInterval time.Duration
+}
+
+func (o AdaptiveOptions) enabled() bool {
+  return o.Threshold > 0
+}
+
+func (o AdaptiveOptions) clamp(interval time.Duration) time.Duration {
+  switch {
+  case interval < o.MinInterval:
+    return o.MinInterval
+  case interval > o.MaxInterval:
+    return o.MaxInterval
+  default:
+    return interval
+  }
+}
+
+// The interval following the given one, after readings changed at the given rate.
+func (o AdaptiveOptions) next(interval time.Duration, rate float64) time.Duration {
+  if rate > o.Threshold {
+    return o.clamp(interval / 2)
+  }
+
+  return o.clamp(interval + interval / 2)
+}
+
+// Fastest change per minute between two samples, across temperatures and humidity.
+func rateOfChange(prev model.Sample, cur device.Reading, now time.Time) float64 {
+  minutes := now.Sub(prev.Time).Minutes()
+
+  if minutes <= 0 {
+    return 0
+  }
+
+  var delta float64
+
+  for i, temp := range cur.Temperatures {
+    if i < len(prev.Temperatures) {
+      delta = math.Max(delta, math.Abs(float64(temp - prev.Temperatures[i])))
+    }
+  }
+
+  if cur.HasHumidity && prev.HasHumidity {
+    delta = math.Max(delta, math.Abs(float64(cur.RelativeHumidity - prev.RelativeHumidity)))
+  }
+
+  return delta / minutes
+}
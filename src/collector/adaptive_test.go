@@ -0,0 +1,44 @@
+package collector
+
+import (
+  "testing"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+)
+
+func TestAdaptiveInterval(t *testing.T) {
+  opts := AdaptiveOptions{
+    Threshold: 0.5,
+    MinInterval: time.Minute,
+    MaxInterval: 10 * time.Minute,
+  }
+
+  now := time.Unix(3600, 0)
+  prev := model.Sample{
+    Reading: device.Reading{Temperatures: []float32{4}},
+    Time: now.Add(-2 * time.Minute),
+  }
+
+  stable := rateOfChange(prev, device.Reading{Temperatures: []float32{4.5}}, now)
+  changing := rateOfChange(prev, device.Reading{Temperatures: []float32{2}}, now)
+
+  if stable != 0.25 || changing != 1 {
+    t.Fatalf("rateOfChange() = %v, %v, wanted 0.25, 1", stable, changing)
+  }
+
+  interval := 4 * time.Minute
+
+  for _, want := range []time.Duration{6 * time.Minute, 9 * time.Minute, 10 * time.Minute} {
+    if interval = opts.next(interval, stable); interval != want {
+      t.Fatalf("stable readings: got interval %v, wanted %v", interval, want)
+    }
+  }
+
+  for _, want := range []time.Duration{5 * time.Minute, 150 * time.Second, 75 * time.Second, time.Minute} {
+    if interval = opts.next(interval, changing); interval != want {
+      t.Fatalf("changing readings: got interval %v, wanted %v", interval, want)
+    }
+  }
+}
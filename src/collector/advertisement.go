@@ -2,10 +2,12 @@ package collector
 
 import (
   "context"
-  "errors"
+  "fmt"
   "net"
   "strings"
   "sync"
+  "sync/atomic"
+  "time"
 
   "github.com/robertof/go-inkbird-exporter/ble"
   "github.com/robertof/go-inkbird-exporter/collector/model"
@@ -13,6 +15,13 @@ import (
   "github.com/rs/zerolog/log"
 )
 
+// Scan for advertisements of the specified devices until either every device has been parsed
+// successfully or has hit its own timeout (if any). Devices hitting their timeout are reported
+// with an error wrapping context.DeadlineExceeded. The time to the first valid advertisement of
+// each device is recorded in its cadence, if any.
+//
+// Timeouts and cadences only count the time spent scanning: not the time spent waiting for the
+// radio, held by connection attempts.
 func collectViaScan(
   ctx context.Context,
   handle *ble.Handle,
@@ -21,25 +30,95 @@ func collectViaScan(
 ) error {
   type DeviceContext struct {
     deviceWithBackend[device.PassiveBackend]
-    sync.Once
+    resolved sync.Once
+    expired, succeeded atomic.Bool
   }
 
+  scanCtx, cancel := context.WithCancel(ctx)
+  defer cancel()
+
+  var mu sync.Mutex
   numLeft := len(devices)
   addresses := make([]net.HardwareAddr, len(devices))
   deviceMap := make(map[string]*DeviceContext)
 
+  // a device is resolved once parsed successfully or expired. stop scanning when all are.
+  resolve := func(deviceCtx *DeviceContext, expired bool) {
+    deviceCtx.resolved.Do(func() {
+      deviceCtx.expired.Store(expired)
+
+      mu.Lock()
+      defer mu.Unlock()
+
+      numLeft -= 1
+
+      if numLeft == 0 {
+        cancel()
+      }
+    })
+  }
+
   {
     i := 0
     for _, device := range devices {
-      addresses[i] = device.Addr()
-      deviceMap[strings.ToLower(device.Addr().String())] = &DeviceContext{
+      deviceCtx := &DeviceContext{
         deviceWithBackend: device,
       }
+
+      addresses[i] = device.Addr()
+      deviceMap[strings.ToLower(device.Addr().String())] = deviceCtx
       i += 1
     }
   }
 
-  err := handle.ScanAddresses(ctx, addresses, func(a ble.Advertisement) bool {
+  // the time spent scanning, paused while the radio is held by connection attempts.
+  var clockMu sync.Mutex
+  var scanned time.Duration
+  var scanStart time.Time // zero while paused.
+  var timers []*time.Timer
+
+  elapsed := func() time.Duration {
+    clockMu.Lock()
+    defer clockMu.Unlock()
+
+    if scanStart.IsZero() {
+      return scanned
+    }
+
+    return scanned + time.Since(scanStart)
+  }
+
+  // expire devices once scanned for their timeout.
+  onScanning := func(scanning bool) {
+    clockMu.Lock()
+    defer clockMu.Unlock()
+
+    if !scanning {
+      scanned += time.Since(scanStart)
+      scanStart = time.Time{}
+
+      for _, timer := range timers {
+        timer.Stop()
+      }
+
+      timers = nil
+      return
+    }
+
+    scanStart = time.Now()
+
+    for _, deviceCtx := range deviceMap {
+      if deviceCtx.timeout > 0 {
+        deviceCtx := deviceCtx
+
+        timers = append(timers, time.AfterFunc(deviceCtx.timeout - scanned, func() {
+          resolve(deviceCtx, true)
+        }))
+      }
+    }
+  }
+
+  err := handle.ScanAddresses(scanCtx, addresses, onScanning, func(a ble.Advertisement) bool {
     deviceCtx := deviceMap[strings.ToLower(a.Addr().String())]
 
     if deviceCtx == nil {
@@ -53,6 +132,10 @@ func collectViaScan(
       return false
     }
 
+    if deviceCtx.expired.Load() {
+      return true // too late for this attempt, stop processing advertisements from this device
+    }
+
     log.Trace().
       Stringer("Device", deviceCtx.Device).
       Str("LocalName", a.LocalName()).
@@ -62,6 +145,10 @@ func collectViaScan(
 
     reading, err := deviceCtx.backend.ParseAdvertisement(a)
 
+    if err == nil {
+      reading.RSSI, reading.HasRSSI = a.RSSI(), true
+    }
+
     log.Trace().
       Err(err).
       Stringer("Reading", reading).
@@ -77,21 +164,38 @@ func collectViaScan(
     }
 
     select {
-    case <-ctx.Done():
+    case <-scanCtx.Done():
       return true // context is canceled, let's get out of the way
     case ch <- result:
     }
 
-    deviceCtx.Do(func() {
-      numLeft -= 1
-    })
+    if err == nil && !deviceCtx.succeeded.Swap(true) {
+      if deviceCtx.cadence != nil {
+        deviceCtx.cadence.observe(elapsed(), false)
+      }
+
+      resolve(deviceCtx, false)
+    }
 
     return err == nil // consider ourselves happy when there is no error parsing the advertisement
   })
 
-  // swallow deadline exceeded errors if we got results for all devices
-  if errors.Is(err, context.DeadlineExceeded) && numLeft == 0 {
-    err = nil
+  // report devices which timed out. they might have sent unparseable advertisements in the
+  // meantime, but the timeout is what ultimately made the attempt fail.
+  for _, deviceCtx := range deviceMap {
+    if deviceCtx.expired.Load() && !deviceCtx.succeeded.Load() {
+      if deviceCtx.cadence != nil {
+        deviceCtx.cadence.observe(deviceCtx.timeout, true)
+      }
+
+      ch <- model.DeviceResult{
+        Device: deviceCtx.Device,
+        Result: model.Result{
+          Error: fmt.Errorf("no valid advertisement received within %v: %w",
+            deviceCtx.timeout, context.DeadlineExceeded),
+        },
+      }
+    }
   }
 
   return err
@@ -0,0 +1,99 @@
+package collector
+
+import (
+  "strconv"
+  "time"
+)
+
+const (
+  DefaultBreakerThreshold = 3
+  DefaultBreakerCooldown = 10 * time.Minute
+  DefaultBreakerMaxCooldown = time.Hour
+)
+
+// BreakerState is the state of the circuit breaker of a device.
+type BreakerState uint8
+
+const (
+  // The device is collected normally.
+  BreakerClosed BreakerState = iota
+  // The device is probed with a single attempt to check whether it recovered.
+  BreakerHalfOpen
+  // The device failed repeatedly and is not collected until its cooldown expires.
+  BreakerOpen
+)
+
+func (s BreakerState) String() string {
+  switch s {
+  case BreakerClosed:
+    return "closed"
+  case BreakerHalfOpen:
+    return "half-open"
+  case BreakerOpen:
+    return "open"
+  default:
+    panic("unknown breaker state: " + strconv.Itoa(int(s)))
+  }
+}
+
+// BreakerOptions configures the per-device circuit breakers of Recurring. Once a device fails
+// Threshold collections in a row (each including its retries), it is only probed once per
+// cooldown. The cooldown starts at Cooldown and doubles after each failed probe, up to
+// MaxCooldown. A successful probe closes the breaker again.
+type BreakerOptions struct {
+  // Zero disables the circuit breaker.
+  Threshold int
+  Cooldown time.Duration
+  MaxCooldown time.Duration
+}
+
+type breaker struct {
+  state BreakerState
+  failures int
+  cooldown time.Duration
+  openUntil time.Time
+}
+
+// Whether the device should be collected now. Moves open breakers to half-open once their
+// cooldown expires.
+func (b *breaker) allow(now time.Time) bool {
+  if b.state == BreakerOpen && !now.Before(b.openUntil) {
+    b.state = BreakerHalfOpen
+  }
+
+  return b.state != BreakerOpen
+}
+
+// Record the outcome of a collection, returning the previous state.
+func (b *breaker) record(opts BreakerOptions, success bool, now time.Time) (prev BreakerState) {
+  prev = b.state
+
+  if success {
+    b.state = BreakerClosed
+    b.failures = 0
+    b.cooldown = 0
+    return prev
+  }
+
+  b.failures += 1
+
+  switch {
+  case opts.Threshold <= 0:
+    return prev
+  case b.state == BreakerHalfOpen:
+    b.cooldown *= 2
+  case b.failures >= opts.Threshold:
+    b.cooldown = opts.Cooldown
+  default:
+    return prev
+  }
+
+  if opts.MaxCooldown > 0 && b.cooldown > opts.MaxCooldown {
+    b.cooldown = opts.MaxCooldown
+  }
+
+  b.state = BreakerOpen
+  b.openUntil = now.Add(b.cooldown)
+
+  return prev
+}
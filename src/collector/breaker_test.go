@@ -0,0 +1,71 @@
+package collector
+
+import (
+  "testing"
+  "time"
+)
+
+func TestBreaker(t *testing.T) {
+  opts := BreakerOptions{
+    Threshold: 2,
+    Cooldown: time.Minute,
+    MaxCooldown: 3 * time.Minute,
+  }
+
+  var b breaker
+  now := time.Unix(0, 0)
+
+  b.record(opts, false, now)
+
+  if b.state != BreakerClosed || !b.allow(now) {
+    t.Fatalf("after 1 failure: got state %v, wanted closed", b.state)
+  }
+
+  b.record(opts, false, now)
+
+  if b.state != BreakerOpen || b.allow(now.Add(59 * time.Second)) {
+    t.Fatalf("after 2 failures: got state %v, wanted open for 1m", b.state)
+  }
+
+  // cooldown expired: probe, fail, and back off further until MaxCooldown.
+  for i, want := range []time.Duration{2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+    now = b.openUntil
+
+    if !b.allow(now) || b.state != BreakerHalfOpen {
+      t.Fatalf("probe %d: got state %v, wanted half-open", i, b.state)
+    }
+
+    b.record(opts, false, now)
+
+    if b.state != BreakerOpen || b.cooldown != want {
+      t.Fatalf("probe %d: got state %v with cooldown %v, wanted open with %v", i, b.state, b.cooldown, want)
+    }
+  }
+
+  now = b.openUntil
+  b.allow(now)
+
+  if prev := b.record(opts, true, now); prev != BreakerHalfOpen || b.state != BreakerClosed {
+    t.Fatalf("successful probe: got transition %v -> %v, wanted half-open -> closed", prev, b.state)
+  }
+}
+
+func TestBackoff(t *testing.T) {
+  opts := CollectionOptions{
+    BackoffFactor: time.Second,
+    MaxBackoff: 5 * time.Second,
+    BackoffJitter: 0.5,
+  }
+
+  for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+    for i := 0; i < 100; i += 1 {
+      if got := opts.backoff(attempt); got > want || got < want / 2 {
+        t.Fatalf("backoff(%d) = %v, wanted between %v and %v", attempt, got, want / 2, want)
+      }
+    }
+  }
+
+  if got := opts.backoff(200); got > opts.MaxBackoff || got <= 0 {
+    t.Fatalf("backoff(200) = %v, wanted capped to %v", got, opts.MaxBackoff)
+  }
+}
@@ -0,0 +1,157 @@
+package collector
+
+import (
+  "errors"
+  "fmt"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/rs/zerolog/log"
+)
+
+var (
+  ErrUnknownDevice = errors.New("unknown device")
+  ErrBurstUnavailable = errors.New("burst mode is not available in on-demand mode")
+)
+
+// Burst temporarily overrides the collection interval of a device.
+type Burst struct {
+  Interval time.Duration
+  Until time.Time
+}
+
+func (b Burst) activeAt(t time.Time) bool {
+  return t.Before(b.Until)
+}
+
+// StartBurst collects the named device every interval until the duration elapses, regardless of
+// its policy and schedule, then switches back to normal collection. Starting a burst on a device
+// which is already bursting replaces the previous burst. Bursts keep the collector awake.
+func (s *Recurring) StartBurst(name string, interval, duration time.Duration) (Burst, error) {
+  if s.OnDemandTTL > 0 {
+    return Burst{}, ErrBurstUnavailable
+  }
+
+  if interval <= 0 || duration <= 0 {
+    return Burst{}, fmt.Errorf("burst interval and duration must be positive, got %v and %v", interval, duration)
+  }
+
+  dev, err := s.Device(name)
+
+  if err != nil {
+    return Burst{}, err
+  }
+
+  burst := Burst{
+    Interval: interval,
+    Until: time.Now().Add(duration),
+  }
+
+  s.mu.Lock()
+  s.bursts[dev] = burst
+  s.lastRead = time.Now()
+  s.mu.Unlock()
+
+  log.Info().
+    Stringer("Device", dev).
+    Dur("Interval", interval).
+    Time("Until", burst.Until).
+    Msg("Starting burst collection for device")
+
+  s.notify()
+
+  return burst, nil
+}
+
+// StopBurst ends the burst of the named device early, if any. Returns whether the device was
+// bursting.
+func (s *Recurring) StopBurst(name string) (bool, error) {
+  dev, err := s.Device(name)
+
+  if err != nil {
+    return false, err
+  }
+
+  s.mu.Lock()
+  burst, ok := s.bursts[dev]
+  delete(s.bursts, dev)
+  s.mu.Unlock()
+
+  if ok && burst.activeAt(time.Now()) {
+    log.Info().Stringer("Device", dev).Msg("Stopping burst collection for device")
+    s.notify()
+
+    return true, nil
+  }
+
+  return false, nil
+}
+
+// Bursts returns the devices currently bursting.
+func (s *Recurring) Bursts() map[device.Device]Burst {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  now := time.Now()
+  bursts := make(map[device.Device]Burst)
+
+  for dev, burst := range s.bursts {
+    if burst.activeAt(now) {
+      bursts[dev] = burst
+    }
+  }
+
+  return bursts
+}
+
+// Device returns the device with the given name.
+func (s *Recurring) Device(name string) (device.Device, error) {
+  for _, dev := range s.devices {
+    if dev.Name() == name {
+      return dev, nil
+    }
+  }
+
+  return nil, fmt.Errorf("%w: %q", ErrUnknownDevice, name)
+}
+
+// Apply started, stopped and expired bursts to the scheduling state of the devices. Returns the
+// time at which the earliest active burst expires, if any.
+func (s *Recurring) applyBursts(now time.Time) (expiry time.Time) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  for _, dev := range s.devices {
+    state := s.states[dev]
+    burst, ok := s.bursts[dev]
+
+    if ok && !burst.activeAt(now) {
+      delete(s.bursts, dev)
+      ok = false
+    }
+
+    switch {
+    case ok && (state.burst == nil || *state.burst != burst):
+      state.burst = &burst
+      // collect right away.
+      state.nextDue = now
+
+      burstActiveGauge.WithLabelValues(dev.Name()).Set(1)
+      intervalGauge.WithLabelValues(dev.Name()).Set(burst.Interval.Seconds())
+    case !ok && state.burst != nil:
+      log.Info().Stringer("Device", dev).Msg("Burst collection ended for device")
+
+      state.burst = nil
+      state.nextDue = state.nextDueAfter(now)
+
+      burstActiveGauge.WithLabelValues(dev.Name()).Set(0)
+      intervalGauge.WithLabelValues(dev.Name()).Set(state.interval.Seconds())
+    }
+
+    if ok && (expiry.IsZero() || burst.Until.Before(expiry)) {
+      expiry = burst.Until
+    }
+  }
+
+  return expiry
+}
@@ -0,0 +1,122 @@
+package collector
+
+import (
+  "math"
+  "sort"
+  "sync"
+  "time"
+)
+
+const (
+  DefaultMinScanTimeout = time.Second
+  DefaultMaxScanTimeout = 30 * time.Second
+
+  // number of recent scans the timeout is learned from.
+  cadenceWindow = 20
+  // scans to observe before trusting the learned timeout over TimeoutPerAttempt.
+  cadenceMinSamples = 5
+  // headroom on top of the percentile, to absorb jitter in the advertising interval.
+  cadenceMargin = 1.25
+)
+
+// CadenceOptions configure scan timeouts learned from the advertising cadence of a device.
+type CadenceOptions struct {
+  // Percentile (0 to 1) of the recent times to the first valid advertisement of the device used as
+  // its scan timeout. Zero disables learning.
+  Percentile float64
+  MinTimeout, MaxTimeout time.Duration
+}
+
+func (o CadenceOptions) enabled() bool {
+  return o.Percentile > 0
+}
+
+// Cadence learns how long a device takes to send its first valid advertisement once a scan starts,
+// to size its scan timeouts.
+type Cadence struct {
+  opts CadenceOptions
+
+  mu sync.Mutex
+  // ring buffer of the most recent observations.
+  samples []cadenceSample
+  next int
+}
+
+type cadenceSample struct {
+  d time.Duration
+  // the scan gave up on the device after d.
+  timedOut bool
+}
+
+func NewCadence(opts CadenceOptions) *Cadence {
+  return &Cadence{opts: opts}
+}
+
+// Record the time to the first valid advertisement of a scan. Scans giving up on the device are
+// recorded with their timeout: the actual time is longer, so the timeout keeps growing until the
+// device is given enough time. Once the device is heard from again, the timeouts recorded decay
+// towards its actual cadence, so that a few missed scans don't keep the timeout long.
+func (c *Cadence) observe(d time.Duration, timedOut bool) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if !timedOut {
+    for i, s := range c.samples {
+      if s.timedOut {
+        c.samples[i].d = time.Duration(float64(s.d) / cadenceMargin)
+
+        if c.samples[i].d < d {
+          c.samples[i].d = d
+        }
+      }
+    }
+  }
+
+  sample := cadenceSample{d: d, timedOut: timedOut}
+
+  if len(c.samples) < cadenceWindow {
+    c.samples = append(c.samples, sample)
+  } else {
+    c.samples[c.next] = sample
+    c.next = (c.next + 1) % cadenceWindow
+  }
+}
+
+// The scan timeout to use, or fallback until enough scans have been observed.
+func (c *Cadence) timeout(fallback time.Duration) time.Duration {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if len(c.samples) < cadenceMinSamples {
+    return fallback
+  }
+
+  sorted := make([]time.Duration, len(c.samples))
+
+  for i, s := range c.samples {
+    sorted[i] = s.d
+  }
+
+  sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+  // nearest rank.
+  rank := int(math.Ceil(c.opts.Percentile * float64(len(sorted))))
+
+  if rank < 1 {
+    rank = 1
+  } else if rank > len(sorted) {
+    rank = len(sorted)
+  }
+
+  timeout := time.Duration(float64(sorted[rank - 1]) * cadenceMargin)
+
+  if timeout < c.opts.MinTimeout {
+    timeout = c.opts.MinTimeout
+  }
+
+  if c.opts.MaxTimeout > 0 && timeout > c.opts.MaxTimeout {
+    timeout = c.opts.MaxTimeout
+  }
+
+  return timeout
+}
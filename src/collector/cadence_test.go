@@ -0,0 +1,53 @@
+package collector
+
+import (
+  "testing"
+  "time"
+)
+
+func TestCadence(t *testing.T) {
+  c := NewCadence(CadenceOptions{
+    Percentile: 0.9,
+    MinTimeout: time.Second,
+    MaxTimeout: 20 * time.Second,
+  })
+
+  for i := 0; i < cadenceMinSamples - 1; i += 1 {
+    c.observe(2 * time.Second, false)
+  }
+
+  if got := c.timeout(5 * time.Second); got != 5 * time.Second {
+    t.Fatalf("timeout() with too few samples = %v, wanted the fallback", got)
+  }
+
+  // a device advertising every ~2s, with the occasional slow scan.
+  for i := 0; i < cadenceWindow; i += 1 {
+    if i % 10 == 0 {
+      c.observe(8 * time.Second, false)
+    } else {
+      c.observe(time.Duration(i % 4) * 500 * time.Millisecond, false)
+    }
+  }
+
+  if got, want := c.timeout(5 * time.Second), 1875 * time.Millisecond; got != want {
+    t.Fatalf("timeout() = %v, wanted %v", got, want)
+  }
+
+  // a slow advertiser timing out: the timeout grows up to MaxTimeout.
+  for i := 0; i < 3 * cadenceWindow; i += 1 {
+    c.observe(c.timeout(5 * time.Second), true)
+  }
+
+  if got := c.timeout(5 * time.Second); got != 20 * time.Second {
+    t.Fatalf("timeout() after repeated timeouts = %v, wanted the max timeout", got)
+  }
+
+  // the device is heard from again: the timeouts decay long before leaving the window.
+  for i := 0; i < 5; i += 1 {
+    c.observe(2 * time.Second, false)
+  }
+
+  if got := c.timeout(5 * time.Second); got > 10 * time.Second {
+    t.Fatalf("timeout() after successful scans = %v, wanted it to decay", got)
+  }
+}
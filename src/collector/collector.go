@@ -3,6 +3,7 @@ package collector
 import (
   "context"
   "fmt"
+  "math/rand"
   "time"
 
   "github.com/robertof/go-inkbird-exporter/ble"
@@ -17,36 +18,91 @@ const (
   DefaultMaxRetries = 2
   DefaultTimeoutPerAttempt = 5 * time.Second
   DefaultBackoffFactor = 500 * time.Millisecond
+  DefaultMaxBackoff = 30 * time.Second
+  DefaultBackoffJitter = 0.2
 )
 
 type CollectionOptions struct {
   MaxRetries int
   TimeoutPerAttempt time.Duration
   BackoffFactor time.Duration
+  // Upper bound for the backoff between retries. Zero means unbounded.
+  MaxBackoff time.Duration
+  // Fraction (0 to 1) of each backoff which is randomized, so that retries of devices failing
+  // together spread out rather than hitting the radio in lockstep.
+  BackoffJitter float64
+  // If set, scan timeouts are learned from the advertising cadence of the device instead, with
+  // TimeoutPerAttempt as a fallback until enough scans have been observed.
+  Cadence *Cadence
+}
+
+func (o CollectionOptions) backoff(attempt int) time.Duration {
+  if o.BackoffFactor <= 0 {
+    return 0
+  }
+
+  backoff := o.BackoffFactor << int64(attempt)
+
+  if backoff < 0 || (backoff >> int64(attempt)) != o.BackoffFactor {
+    // overflow
+    backoff = o.MaxBackoff
+
+    if backoff <= 0 {
+      backoff = DefaultBackoffFactor
+    }
+  }
+
+  if o.MaxBackoff > 0 && backoff > o.MaxBackoff {
+    backoff = o.MaxBackoff
+  }
+
+  if o.BackoffJitter > 0 {
+    jitter := o.BackoffJitter
 
-  attempt int
+    if jitter > 1 {
+      jitter = 1
+    }
+
+    backoff -= time.Duration(float64(backoff) * jitter * rand.Float64())
+  }
+
+  return backoff
 }
 
 type deviceWithBackend[Backend any] struct {
   device.Device
   backend Backend
+  timeout time.Duration
+  cadence *Cadence
 }
 
-func selectDevicesByBackend(devices []device.Device) (
+func selectDevicesByBackend(devices []device.Device, optionsFor func(device.Device) CollectionOptions) (
   passive []deviceWithBackend[device.PassiveBackend],
   active []deviceWithBackend[device.ActiveBackend],
 ) {
   for _, dev := range devices {
+    options := optionsFor(dev)
+    timeout := options.TimeoutPerAttempt
+
     switch backend := dev.Backend().(type) {
     case device.ActiveBackend:
       active = append(active, deviceWithBackend[device.ActiveBackend]{
         Device: dev,
         backend: backend,
+        timeout: timeout,
       })
     case device.PassiveBackend:
+      if options.Cadence != nil {
+        timeout = options.Cadence.timeout(timeout)
+      }
+
+      scanTimeoutGauge.WithLabelValues(dev.Name()).Set(timeout.Seconds())
+
       passive = append(passive, deviceWithBackend[device.PassiveBackend]{
         Device: dev,
         backend: backend,
+        timeout: timeout,
+        cadence: options.Cadence,
       })
     default:
       panic(fmt.Sprintf(
@@ -83,38 +139,127 @@ func CollectReadingsWithOptions(
   parentCtx context.Context,
   devices []device.Device,
   options CollectionOptions,
+) (out map[device.Device]model.Result, err error) {
+  return CollectReadingsPerDevice(handle, parentCtx, devices, func(device.Device) CollectionOptions {
+    return options
+  })
+}
+
+// Like CollectReadingsWithOptions, but the options are picked separately for each device. Devices
+// are still collected together (e.g. in a single scan), but each one is given up on after its own
+// timeout and retried according to its own retry settings.
+func CollectReadingsPerDevice(
+  handle *ble.Handle,
+  parentCtx context.Context,
+  devices []device.Device,
+  optionsFor func(device.Device) CollectionOptions,
 ) (out map[device.Device]model.Result, err error) {
   out = make(map[device.Device]model.Result, len(devices))
 
-  log.Debug().
-    Array("Devices", utils.ToZeroLogArray(devices)).
-    Msg("Collecting readings from devices")
+  for attempt := 0; ; attempt += 1 {
+    var attemptOut map[device.Device]model.Result
 
-  passiveDevices, activeDevices := selectDevicesByBackend(devices)
+    attemptOut, err = collectAttempt(handle, parentCtx, devices, optionsFor)
 
-  // make sure signals are properly handled and we enforce the passed timeout.
-  var ctx context.Context
-  var cancel func()
+    for dev, result := range attemptOut {
+      out[dev] = result
+    }
 
-  if options.TimeoutPerAttempt > 0 {
-    ctx, cancel = context.WithTimeout(parentCtx, options.TimeoutPerAttempt)
-  } else {
-    ctx, cancel = context.WithCancel(parentCtx)
+    // analyze results, and retry if needed
+    var failedDevices []device.Device
+    var backoff time.Duration
+
+    for _, device := range devices {
+      options := optionsFor(device)
+      retriesLeft := options.MaxRetries - attempt
+
+      if retriesLeft <= 0 {
+        continue
+      }
+
+      if result, ok := attemptOut[device]; ok && result.Error != nil {
+        // parsing failed
+        log.Debug().
+          Stringer("Device", device).
+          Int("RetriesLeft", retriesLeft).
+          Err(result.Error).
+          Msg("Collection failed for device - will retry")
+      } else if !ok {
+        // never got a result for the device
+        log.Debug().
+          Stringer("Device", device).
+          Int("RetriesLeft", retriesLeft).
+          Err(err).
+          Msg("No data received for device (wrong MAC?) - will retry")
+      } else {
+        continue
+      }
+
+      failedDevices = append(failedDevices, device)
+
+      if b := options.backoff(attempt); b > backoff {
+        backoff = b
+      }
+    }
+
+    if len(failedDevices) == 0 {
+      return out, err
+    }
+
+    if backoff > 0 {
+      log.Trace().
+        Dur("Backoff", backoff).
+        Msg("Backing off before attempting retry")
+
+      select {
+      case <-parentCtx.Done():
+        log.Trace().Err(parentCtx.Err()).Msg("Retry aborted by context cancel")
+        return out, parentCtx.Err()
+      case <-time.After(backoff):
+      }
+    }
+
+    devices = failedDevices
   }
+}
+
+// Run a single collection attempt for all the specified devices.
+func collectAttempt(
+  handle *ble.Handle,
+  parentCtx context.Context,
+  devices []device.Device,
+  optionsFor func(device.Device) CollectionOptions,
+) (out map[device.Device]model.Result, err error) {
+  out = make(map[device.Device]model.Result, len(devices))
 
+  log.Debug().
+    Array("Devices", utils.ToZeroLogArray(devices)).
+    Msg("Collecting readings from devices")
+
+  passiveDevices, activeDevices := selectDevicesByBackend(devices, optionsFor)
+
+  // timeouts are enforced per device by the workers below.
+  ctx, cancel := context.WithCancel(parentCtx)
   defer cancel()
 
   // collect everything in parallel and gather results.
   var eg errgroup.Group
   resultCh := make(chan model.DeviceResult)
 
+  // in sequential mode, connections wait for the scan to be over. starting them right away would
+  // work too, but their timeouts would run while waiting for the radio.
+  scanDone := make(chan struct{})
+
   if len(passiveDevices) > 0 {
     log.Trace().
       Array("Devices", utils.ToZeroLogArray(passiveDevices)).
       Msg("Collecting data from devices via scan")
     eg.Go(func() error {
+      defer close(scanDone)
       return collectViaScan(ctx, handle, passiveDevices, resultCh)
     })
+  } else {
+    close(scanDone)
   }
 
   if len(activeDevices) > 0 {
@@ -122,6 +267,10 @@ func CollectReadingsWithOptions(
       Array("Devices", utils.ToZeroLogArray(activeDevices)).
       Msg("Collecting data from devices via direct connection")
     eg.Go(func() error {
+      if handle.ArbitrationMode() == ble.ArbitrationSequential {
+        <-scanDone
+      }
+
       return collectViaConnection(ctx, handle, activeDevices, resultCh)
     })
   }
@@ -140,71 +289,5 @@ func CollectReadingsWithOptions(
     out[v.Device] = v.Result
   }
 
-  // analyze results, and retry if needed
-  if options.MaxRetries > 0 {
-    var failedDevices []device.Device
-
-    for _, device := range devices {
-      if result, ok := out[device]; ok && result.Error != nil {
-        // parsing failed
-        failedDevices = append(failedDevices, device)
-
-        log.Debug().
-          Stringer("Device", device).
-          Int("RetriesLeft", options.MaxRetries).
-          Err(result.Error).
-          Msg("Collection failed for device - will retry")
-      } else if !ok {
-        // never got a result for the device
-        failedDevices = append(failedDevices, device)
-
-        log.Debug().
-          Stringer("Device", device).
-          Int("RetriesLeft", options.MaxRetries).
-          Err(err).
-          Msg("No data received for device (wrong MAC?) - will retry")
-      }
-    }
-
-    if len(failedDevices) > 0 {
-      if options.BackoffFactor > 0 {
-        backoff := options.BackoffFactor << int64(options.attempt)
-
-        if backoff < 0 {
-          backoff = DefaultBackoffFactor
-        }
-
-        log.Trace().
-          Dur("Backoff", backoff).
-          Msg("Backing off before attempting retry")
-
-        select {
-        case <-parentCtx.Done():
-          log.Trace().Err(ctx.Err()).Msg("Retry aborted by context cancel")
-          return out, ctx.Err()
-        case <-time.After(backoff):
-        }
-      }
-
-      options.MaxRetries -= 1
-      options.attempt += 1
-
-      retryOutput, err := CollectReadingsWithOptions(handle, parentCtx, failedDevices, options)
-
-      // merge old and new outputs
-      if retryOutput != nil {
-        for failedDevice := range retryOutput {
-          out[failedDevice] = retryOutput[failedDevice]
-        }
-      }
-
-      if err != nil {
-        return out, err
-      }
-
-      return out, nil
-    }
-  }
-
   return out, err
 }
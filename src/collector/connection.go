@@ -17,7 +17,9 @@ func connectAndCollect(
   handle *ble.Handle,
   device deviceWithBackend[device.ActiveBackend],
 ) (reading device.Reading, err error) {
-  conn, err := handle.Connect(ctx, device.Addr())
+  // the device timeout starts once the connection attempt does: attempts waiting for the radio
+  // are queued rather than failed.
+  conn, err := handle.ConnectWithTimeout(ctx, device.Addr(), device.timeout)
 
   if err != nil {
     return reading, fmt.Errorf("failed to connect to device: %w", err)
@@ -29,6 +31,11 @@ func connectAndCollect(
     return reading, fmt.Errorf("failed to read data from device: %w", err)
   }
 
+  // 0 means the RSSI couldn't be read.
+  if rssi := conn.ReadRSSI(); rssi != 0 {
+    reading.RSSI, reading.HasRSSI = rssi, true
+  }
+
   return reading, nil
 }
 
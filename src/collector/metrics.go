@@ -0,0 +1,48 @@
+package collector
+
+import (
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+  breakerStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+    Name: "inkbird_exporter_collector_breaker_state",
+    Help: "State of the circuit breaker of the device. 0 = closed, 1 = half-open, 2 = open.",
+  }, []string{"name"})
+  intervalGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+    Name: "inkbird_exporter_collector_interval_seconds",
+    Help: "Effective collection interval of the device, outside of schedule windows.",
+  }, []string{"name"})
+  burstActiveGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+    Name: "inkbird_exporter_collector_burst_active",
+    Help: "Whether the device is temporarily collected in burst mode (1) or not (0).",
+  }, []string{"name"})
+  scanTimeoutGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+    Name: "inkbird_exporter_collector_scan_timeout_seconds",
+    Help: "Timeout of the last scan for the device, possibly learned from its advertising cadence.",
+  }, []string{"name"})
+  sinkReadingsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+    Name: "inkbird_exporter_sink_readings_total",
+    Help: "Total number of readings handled by the sink, by outcome (delivered, failed or dropped).",
+  }, []string{"sink", "outcome"})
+  sinkQueueLengthGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+    Name: "inkbird_exporter_sink_queue_length",
+    Help: "Number of readings waiting to be delivered to the sink.",
+  }, []string{"sink"})
+  sinkDeliveryDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+    Name: "inkbird_exporter_sink_delivery_duration_seconds",
+    Help: "Time taken to deliver a batch of readings to the sink.",
+  }, []string{"sink"})
+)
+
+func RegisterMetrics(reg prometheus.Registerer) {
+  reg.MustRegister(
+    breakerStateGauge,
+    intervalGauge,
+    burstActiveGauge,
+    scanTimeoutGauge,
+    sinkReadingsCounter,
+    sinkQueueLengthGauge,
+    sinkDeliveryDurationHistogram,
+  )
+}
@@ -0,0 +1,13 @@
+package model
+
+import (
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/device"
+)
+
+// Sample is a reading along with the time it was collected at.
+type Sample struct {
+  device.Reading
+  Time time.Time
+}
@@ -0,0 +1,214 @@
+package collector
+
+import (
+  "fmt"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/device"
+)
+
+const (
+  PolicyFieldInterval = "interval"
+  PolicyFieldTimeout = "timeout"
+  PolicyFieldMaxRetries = "max-retries"
+  PolicyFieldBackoff = "backoff"
+  PolicyFieldMaxBackoff = "max-backoff"
+  PolicyFieldBackoffJitter = "backoff-jitter"
+  PolicyFieldAlign = "align"
+  PolicyFieldSchedule = "schedule"
+  PolicyFieldChangeThreshold = "change-threshold"
+  PolicyFieldMinInterval = "min-interval"
+  PolicyFieldMaxInterval = "max-interval"
+  PolicyFieldTimeoutPercentile = "timeout-percentile"
+  PolicyFieldMinTimeout = "min-timeout"
+  PolicyFieldMaxTimeout = "max-timeout"
+)
+
+// PolicySchema lists the spec parameters that override the collection policy of a single device.
+// They are accepted by every device type and default to the global flags.
+var PolicySchema = device.Schema{
+  {
+    Name: PolicyFieldInterval,
+    Type: device.ParamTypeDuration,
+    Description: "How frequently data is collected from this device. Overrides -interval",
+  },
+  {
+    Name: PolicyFieldTimeout,
+    Type: device.ParamTypeDuration,
+    Description: "Timeout for collections from this device (per retry attempt). Overrides -timeout",
+  },
+  {
+    Name: PolicyFieldMaxRetries,
+    Type: device.ParamTypeInt,
+    Description: "Max number of retries for this device. Overrides -max-retries",
+  },
+  {
+    Name: PolicyFieldBackoff,
+    Type: device.ParamTypeDuration,
+    Description: "Exponential backoff factor for retries of this device. Overrides -backoff",
+  },
+  {
+    Name: PolicyFieldMaxBackoff,
+    Type: device.ParamTypeDuration,
+    Description: "Upper bound for the backoff between retries of this device. Overrides -max-backoff",
+  },
+  {
+    Name: PolicyFieldBackoffJitter,
+    Type: device.ParamTypeFloat,
+    Description: "Randomized fraction (0 to 1) of each backoff for this device. Overrides -backoff-jitter",
+  },
+  {
+    Name: PolicyFieldAlign,
+    Type: device.ParamTypeBool,
+    Description: "Align collections from this device to wall-clock boundaries of its interval. Overrides -align",
+  },
+  {
+    Name: PolicyFieldSchedule,
+    Type: device.ParamTypeString,
+    Description: "Schedule windows with a different interval for this device, e.g. '22:00-06:00 off; sat,sun 08:00-20:00 15m'. Overrides -schedule",
+  },
+  {
+    Name: PolicyFieldChangeThreshold,
+    Type: device.ParamTypeFloat,
+    Description: "Change per minute of the readings of this device above which its interval is shortened. Overrides -change-threshold",
+  },
+  {
+    Name: PolicyFieldMinInterval,
+    Type: device.ParamTypeDuration,
+    Description: "Lower bound for the adaptive interval of this device. Overrides -min-interval",
+  },
+  {
+    Name: PolicyFieldMaxInterval,
+    Type: device.ParamTypeDuration,
+    Description: "Upper bound for the adaptive interval of this device. Overrides -max-interval",
+  },
+  {
+    Name: PolicyFieldTimeoutPercentile,
+    Type: device.ParamTypeFloat,
+    Description: "Percentile (0 to 1) of the recent advertising delays of this device used as its scan timeout. Overrides -timeout-percentile",
+  },
+  {
+    Name: PolicyFieldMinTimeout,
+    Type: device.ParamTypeDuration,
+    Description: "Lower bound for the learned scan timeout of this device. Overrides -min-timeout",
+  },
+  {
+    Name: PolicyFieldMaxTimeout,
+    Type: device.ParamTypeDuration,
+    Description: "Upper bound for the learned scan timeout of this device. Overrides -max-timeout",
+  },
+}
+
+// Policy controls how often and how persistently a device is collected by Recurring.
+type Policy struct {
+  Interval time.Duration
+  // Collect on wall-clock boundaries of the interval, counted from local midnight (e.g. every 5
+  // minutes on the minute), rather than an interval after the end of the previous collection.
+  Align bool
+  // Windows overriding Interval or suspending collection at given times of the day.
+  Schedule Schedule
+  // Adapt Interval to the rate of change of the readings.
+  Adaptive AdaptiveOptions
+  // Learn scan timeouts from the advertising cadence of the device.
+  Cadence CadenceOptions
+  CollectionOptions
+}
+
+// WithOverrides returns a copy of the policy with the parameters explicitly set in params (parsed
+// using PolicySchema) applied.
+func (p Policy) WithOverrides(params device.Params) (Policy, error) {
+  if params.IsSet(PolicyFieldInterval) {
+    p.Interval = params.Duration(PolicyFieldInterval)
+  }
+
+  if params.IsSet(PolicyFieldTimeout) {
+    p.TimeoutPerAttempt = params.Duration(PolicyFieldTimeout)
+  }
+
+  if params.IsSet(PolicyFieldMaxRetries) {
+    p.MaxRetries = params.Int(PolicyFieldMaxRetries)
+  }
+
+  if params.IsSet(PolicyFieldBackoff) {
+    p.BackoffFactor = params.Duration(PolicyFieldBackoff)
+  }
+
+  if params.IsSet(PolicyFieldMaxBackoff) {
+    p.MaxBackoff = params.Duration(PolicyFieldMaxBackoff)
+  }
+
+  if params.IsSet(PolicyFieldBackoffJitter) {
+    p.BackoffJitter = params.Float(PolicyFieldBackoffJitter)
+  }
+
+  if params.IsSet(PolicyFieldAlign) {
+    p.Align = params.Bool(PolicyFieldAlign)
+  }
+
+  if params.IsSet(PolicyFieldSchedule) {
+    schedule, err := ParseSchedule(params.String(PolicyFieldSchedule))
+
+    if err != nil {
+      return p, fmt.Errorf("%w: %s: %w", device.ErrInvalidSpec, PolicyFieldSchedule, err)
+    }
+
+    p.Schedule = schedule
+  }
+
+  if params.IsSet(PolicyFieldChangeThreshold) {
+    p.Adaptive.Threshold = params.Float(PolicyFieldChangeThreshold)
+  }
+
+  if params.IsSet(PolicyFieldMinInterval) {
+    p.Adaptive.MinInterval = params.Duration(PolicyFieldMinInterval)
+  }
+
+  if params.IsSet(PolicyFieldMaxInterval) {
+    p.Adaptive.MaxInterval = params.Duration(PolicyFieldMaxInterval)
+  }
+
+  if params.IsSet(PolicyFieldTimeoutPercentile) {
+    p.Cadence.Percentile = params.Float(PolicyFieldTimeoutPercentile)
+  }
+
+  if params.IsSet(PolicyFieldMinTimeout) {
+    p.Cadence.MinTimeout = params.Duration(PolicyFieldMinTimeout)
+  }
+
+  if params.IsSet(PolicyFieldMaxTimeout) {
+    p.Cadence.MaxTimeout = params.Duration(PolicyFieldMaxTimeout)
+  }
+
+  return p, p.validate()
+}
+
+func (p Policy) validate() error {
+  switch {
+  case p.Interval <= 0:
+    return fmt.Errorf("%w: %s must be positive, got %v", device.ErrInvalidSpec, PolicyFieldInterval, p.Interval)
+  case p.TimeoutPerAttempt < 0:
+    return fmt.Errorf("%w: %s must not be negative, got %v", device.ErrInvalidSpec, PolicyFieldTimeout, p.TimeoutPerAttempt)
+  case p.MaxRetries < 0:
+    return fmt.Errorf("%w: %s must not be negative, got %v", device.ErrInvalidSpec, PolicyFieldMaxRetries, p.MaxRetries)
+  case p.BackoffFactor < 0:
+    return fmt.Errorf("%w: %s must not be negative, got %v", device.ErrInvalidSpec, PolicyFieldBackoff, p.BackoffFactor)
+  case p.MaxBackoff < 0:
+    return fmt.Errorf("%w: %s must not be negative, got %v", device.ErrInvalidSpec, PolicyFieldMaxBackoff, p.MaxBackoff)
+  case p.BackoffJitter < 0 || p.BackoffJitter > 1:
+    return fmt.Errorf("%w: %s must be between 0 and 1, got %v", device.ErrInvalidSpec, PolicyFieldBackoffJitter, p.BackoffJitter)
+  case p.Adaptive.Threshold < 0:
+    return fmt.Errorf("%w: %s must not be negative, got %v", device.ErrInvalidSpec, PolicyFieldChangeThreshold, p.Adaptive.Threshold)
+  case p.Adaptive.enabled() && p.Adaptive.MinInterval <= 0:
+    return fmt.Errorf("%w: %s must be positive when %s is set, got %v", device.ErrInvalidSpec, PolicyFieldMinInterval, PolicyFieldChangeThreshold, p.Adaptive.MinInterval)
+  case p.Adaptive.enabled() && p.Adaptive.MaxInterval < p.Adaptive.MinInterval:
+    return fmt.Errorf("%w: %s must be at least %s, got %v", device.ErrInvalidSpec, PolicyFieldMaxInterval, PolicyFieldMinInterval, p.Adaptive.MaxInterval)
+  case p.Cadence.Percentile < 0 || p.Cadence.Percentile > 1:
+    return fmt.Errorf("%w: %s must be between 0 and 1, got %v", device.ErrInvalidSpec, PolicyFieldTimeoutPercentile, p.Cadence.Percentile)
+  case p.Cadence.MinTimeout < 0:
+    return fmt.Errorf("%w: %s must not be negative, got %v", device.ErrInvalidSpec, PolicyFieldMinTimeout, p.Cadence.MinTimeout)
+  case p.Cadence.MaxTimeout > 0 && p.Cadence.MaxTimeout < p.Cadence.MinTimeout:
+    return fmt.Errorf("%w: %s must be at least %s, got %v", device.ErrInvalidSpec, PolicyFieldMaxTimeout, PolicyFieldMinTimeout, p.Cadence.MaxTimeout)
+  }
+
+  return nil
+}
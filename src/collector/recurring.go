@@ -3,127 +3,348 @@ package collector
 import (
   "context"
   "sync"
-  "sync/atomic"
   "time"
 
   "github.com/robertof/go-inkbird-exporter/ble"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
   "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/utils"
   "github.com/rs/zerolog/log"
 )
 
-type signal uint8
+const DefaultBatchWindow = 2 * time.Second
+
+// Scheduling state of a device.
+type deviceState struct {
+  policy Policy
+  // effective interval: policy.Interval, unless adapted to the rate of change of the readings.
+  interval time.Duration
+  nextDue time.Time
+  breaker breaker
+  // active burst, if any.
+  burst *Burst
+  // nil unless scan timeouts are learned.
+  cadence *Cadence
+}
 
-const (
-  signalWakeUp signal = iota
-  signalCollectionFinished
-)
+// The time of the next collection of the device, last collected at t.
+func (st *deviceState) nextDueAfter(t time.Time) time.Time {
+  if st.burst != nil && st.burst.activeAt(t) {
+    return t.Add(st.burst.Interval)
+  }
+
+  p := st.policy
+  p.Interval = st.interval
+
+  return p.nextDue(t)
+}
+
+// Adapt the interval of the device to the rate of change between the previous sample and a new
+// reading, collected now.
+func (st *deviceState) adapt(dev device.Device, prev model.Sample, cur device.Reading, now time.Time) {
+  if !st.policy.Adaptive.enabled() || prev.Time.IsZero() {
+    return
+  }
+
+  rate := rateOfChange(prev, cur, now)
+  interval := st.policy.Adaptive.next(st.interval, rate)
+
+  if interval != st.interval {
+    log.Debug().
+      Stringer("Device", dev).
+      Float64("RatePerMinute", rate).
+      Dur("PreviousInterval", st.interval).
+      Dur("Interval", interval).
+      Msg("Adapted collection interval of device")
+
+    st.interval = interval
+  }
+
+  if st.burst == nil {
+    intervalGauge.WithLabelValues(dev.Name()).Set(interval.Seconds())
+  }
+}
+
+// A collection requested through WaitLatest(), either to wake up the collector or, in on-demand
+// mode, to refresh stale devices.
+type collectionRequest struct {
+  ctx context.Context
+  done chan struct{}
+}
 
 type Recurring struct {
   // If no call to Latest() has been executed for more than IdleTimeout seconds, the
   // collector will suspend and resume automatically when Latest() is called again.
   IdleTimeout time.Duration
 
-  readings map[device.Device]device.Reading
-  collectionTime time.Time
+  // Devices falling due within BatchWindow of each other are collected together, in a single
+  // scan, to save radio time.
+  BatchWindow time.Duration
+
+  // Circuit breaker settings, applied to each device separately.
+  Breaker BreakerOptions
+
+  // If set, switch to on-demand mode: instead of collecting periodically, collect devices whose
+  // data is older than OnDemandTTL when WaitLatest() is called. Concurrent callers share the same
+  // collection, which is bounded by the deadline of the context of the caller triggering it.
+  OnDemandTTL time.Duration
+
+  // Readings of a device grow stale once older than a few collection intervals of the device, or
+  // than StaleAfter if longer. See DeviceStatus.StaleAfter.
+  StaleAfter time.Duration
+
+  samples map[device.Device]model.Sample
 
   lastRead time.Time
 
   ble *ble.Handle
   devices []device.Device
+  policies map[device.Device]Policy
   mu sync.Mutex
 
+  // owned by the goroutine running Start().
+  states map[device.Device]*deviceState
+
+  requests chan *collectionRequest
+  // requested collection in progress, if any. protected by mu.
+  inflight *collectionRequest
+
   // collector has been Start()ed
   started bool
 
-  // collector is currently suspended due to inactivity
-  suspended atomic.Bool
-
-  signal chan signal
-  wakeUpMu sync.Mutex
+  // collector is currently suspended due to inactivity. protected by mu.
+  suspended bool
+
+  // protected by mu.
+  bursts map[device.Device]Burst
+  // devices allowed to be collected, nil if all are. protected by mu.
+  enabled func(device.Device) bool
+  // bumped on every change of enabled. protected by mu.
+  enabledGen uint64
+  // wakes up the schedule loop to apply changes to bursts or enabled devices.
+  changed chan struct{}
+
+  // queues of the sinks added with AddSink().
+  sinks []*sinkQueue
+  // labels of the devices, set with SetLabels().
+  labels map[device.Device]map[string]string
+  // outcome of the last collection of every device. protected by mu.
+  status map[device.Device]deviceStatus
 }
 
 func NewRecurring(h *ble.Handle, devices []device.Device) *Recurring {
   return &Recurring{
+    BatchWindow: DefaultBatchWindow,
+    Breaker: BreakerOptions{
+      Threshold: DefaultBreakerThreshold,
+      Cooldown: DefaultBreakerCooldown,
+      MaxCooldown: DefaultBreakerMaxCooldown,
+    },
     devices: devices,
+    policies: make(map[device.Device]Policy),
+    labels: make(map[device.Device]map[string]string),
+    states: make(map[device.Device]*deviceState),
+    status: make(map[device.Device]deviceStatus),
+    // a single requested collection can be in flight at a time, so sends never block.
+    requests: make(chan *collectionRequest, 1),
+    bursts: make(map[device.Device]Burst),
+    changed: make(chan struct{}, 1),
     ble: h,
     lastRead: time.Now(),
-    signal: make(chan signal),
   }
 }
 
-func (s *Recurring) Update(r map[device.Device]device.Reading) {
+// Restrict collection to the devices for which enabled returns true, or allow every device if
+// nil. Can be called at any time: connections are dropped once devices get disabled.
+func (s *Recurring) SetEnabled(enabled func(device.Device) bool) {
+  s.mu.Lock()
+  s.enabled = enabled
+  s.enabledGen += 1
+  s.mu.Unlock()
+
+  s.notify()
+}
+
+// must be called with mu held.
+func (s *Recurring) enabledLocked(dev device.Device) bool {
+  return s.enabled == nil || s.enabled(dev)
+}
+
+func (s *Recurring) isEnabled(dev device.Device) bool {
   s.mu.Lock()
   defer s.mu.Unlock()
 
+  return s.enabledLocked(dev)
+}
+
+func (s *Recurring) notify() {
+  select {
+  case s.changed <- struct{}{}:
+  default:
+  }
+}
+
+// Override the policy passed to Start() for a single device. Must be called before Start().
+func (s *Recurring) SetPolicy(dev device.Device, p Policy) {
+  if s.started {
+    panic("attempted to call collector.Recurring.SetPolicy() after Start()")
+  }
+
+  s.policies[dev] = p
+}
+
+// Store new readings, collected now, and queue them for the sinks. Devices missing from the map
+// keep their previous sample.
+func (s *Recurring) Update(r map[device.Device]device.Reading) {
   if r == nil {
     panic("attempted to set nil reading")
   }
 
-  s.readings = r
-  s.collectionTime = time.Now()
+  now := time.Now()
+
+  s.store(r, now)
+  s.publish(r, now)
 }
 
+func (s *Recurring) store(r map[device.Device]device.Reading, now time.Time) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  // copy on write, since get() hands out the current map without holding the lock.
+  samples := make(map[device.Device]model.Sample, len(s.devices))
 
-func (s *Recurring) wakeUpIfNeeded() bool {
-  if s.suspended.Load() {
-    s.signal <- signalWakeUp
+  for dev, sample := range s.samples {
+    samples[dev] = sample
+  }
 
-    return true
+  for dev, reading := range r {
+    samples[dev] = model.Sample{
+      Reading: reading,
+      Time: now,
+    }
   }
 
-  return false
+  s.samples = samples
 }
 
 
-func (s *Recurring) wakeUpAndBlockIfNeeded(ctx context.Context) {
-  // wait if another goroutine has already sent the wake up signal.
-  s.wakeUpMu.Lock()
-  defer s.wakeUpMu.Unlock()
+// Ask the collection loop for a collection, or join the one in progress. Returns nil if no
+// collection is needed: the collector is awake or, in on-demand mode, all data is fresh.
+func (s *Recurring) requestCollection(ctx context.Context) *collectionRequest {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if s.inflight != nil {
+    return s.inflight
+  }
+
+  if s.OnDemandTTL > 0 {
+    if !s.hasStaleLocked(time.Now()) {
+      return nil
+    }
+  } else if !s.suspended {
+    return nil
+  }
 
-  if s.wakeUpIfNeeded() {
-    // wait until wake up is complete to proceed and block other goroutines trying to do
-    // blocking reads.
-    select {
-    case <-ctx.Done():
-    case sig := <-s.signal:
-      if sig != signalCollectionFinished {
-        panic("unexpected signal")
-      }
+  s.inflight = &collectionRequest{
+    ctx: ctx,
+    done: make(chan struct{}),
+  }
+
+  s.requests <- s.inflight
+
+  return s.inflight
+}
+
+// must be called with mu held.
+func (s *Recurring) hasStaleLocked(now time.Time) bool {
+  for _, dev := range s.devices {
+    if !s.enabledLocked(dev) {
+      continue
+    }
+
+    if sample, ok := s.samples[dev]; !ok || now.Sub(sample.Time) >= s.OnDemandTTL {
+      return true
     }
   }
+
+  return false
 }
 
-func (s *Recurring) get() (map[device.Device]device.Reading, time.Time) {
+// Mark a requested collection as complete, waking up its waiters.
+func (s *Recurring) completeRequest(req *collectionRequest) {
+  s.mu.Lock()
+  s.inflight = nil
+  s.mu.Unlock()
+
+  close(req.done)
+}
+
+func (s *Recurring) get() map[device.Device]model.Sample {
   s.mu.Lock()
   defer s.mu.Unlock()
 
-  if s.readings == nil || s.collectionTime.IsZero() {
+  if s.samples == nil {
     panic("Latest() on collector.Recurring called when not initialised yet")
   }
 
   s.lastRead = time.Now()
 
   // safe to return as we replace the old map with a new one on update.
-  return s.readings, s.collectionTime
+  return s.samples
+}
+
+// Like get(), but for internal use: doesn't count as a read.
+func (s *Recurring) peek() map[device.Device]model.Sample {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  return s.samples
 }
 
-// Retrieve the latest collected value. Wakes up the collector if asleep.
+// Retrieve the latest collected samples. Wakes up the collector if asleep.
 // Doesn't wait for a new result if the collector is asleep and is waken up.
-func (s *Recurring) Latest() (map[device.Device]device.Reading, time.Time) {
-  s.wakeUpIfNeeded()
+//
+// In on-demand mode, the collection triggered is bounded by the deadline of ctx, like with
+// WaitLatest().
+func (s *Recurring) Latest(ctx context.Context) map[device.Device]model.Sample {
+  s.requestCollection(ctx)
 
   return s.get()
 }
 
-// Retrieve the latest collected value. Wakes up the collector if asleep and
+// Retrieve the latest collected samples. Wakes up the collector if asleep and
 // waits until it finishes the collection, otherwise, returns the last available
 // data without blocking.
-func (s *Recurring) WaitLatest(ctx context.Context) (map[device.Device]device.Reading, time.Time) {
-  s.wakeUpAndBlockIfNeeded(ctx)
+//
+// In on-demand mode, triggers a collection of the stale devices (or joins the one in progress)
+// and waits for it to finish.
+//
+// If the context expires first, the last available (stale) data is returned along with the
+// context error.
+func (s *Recurring) WaitLatest(ctx context.Context) (map[device.Device]model.Sample, error) {
+  if req := s.requestCollection(ctx); req != nil {
+    select {
+    case <-ctx.Done():
+      log.Debug().Err(ctx.Err()).Msg("Gave up waiting for collection, returning stale data")
+      return s.get(), ctx.Err()
+    case <-req.done:
+    }
+  }
 
-  return s.get()
+  return s.get(), nil
 }
 
+func (s *Recurring) setSuspended(suspended bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if s.suspended == suspended {
+    panic("collector.Recurring suspended twice or woken up while awake!?")
+  }
+
+  s.suspended = suspended
+}
 
 func (s *Recurring) shouldSuspend() (suspend bool, elapsed time.Duration) {
   if s.IdleTimeout == 0 {
@@ -133,17 +354,31 @@ func (s *Recurring) shouldSuspend() (suspend bool, elapsed time.Duration) {
   s.mu.Lock()
   defer s.mu.Unlock()
 
-  elapsed = time.Now().Sub(s.lastRead)
+  now := time.Now()
+  elapsed = now.Sub(s.lastRead)
+
+  // bursts keep the collector awake.
+  for _, burst := range s.bursts {
+    if burst.activeAt(now) {
+      return false, elapsed
+    }
+  }
 
   return elapsed > s.IdleTimeout, elapsed
 }
 
 func (s *Recurring) shutdown() {
   log.Info().Msg("Recurring collector is shutting down")
-
-  close(s.signal)
 }
 
+
+// Start collecting data. Each device is scheduled independently according to its policy, which
+// defaults to the interval and options passed here unless overridden with SetPolicy().
+//
+// If OnDemandTTL is set, devices are not collected periodically: WaitLatest() triggers a
+// collection of the devices whose data is older than OnDemandTTL instead.
+//
+// Returns once ctx is done and the sinks have delivered the readings left in their queues.
 func (s *Recurring) Start(
   ctx context.Context,
   interval time.Duration,
@@ -160,23 +395,99 @@ func (s *Recurring) Start(
     Int("MaxRetries", opts.MaxRetries).
     Dur("TimeoutPerAttemptSec", opts.TimeoutPerAttempt).
     Dur("IdleTimeoutSec", s.IdleTimeout).
+    Dur("OnDemandTTLSec", s.OnDemandTTL).
     Msg("Starting recurring collector")
 
+  start := time.Now()
+
+  for _, dev := range s.devices {
+    policy, ok := s.policies[dev]
+
+    if !ok {
+      policy = Policy{Interval: interval, CollectionOptions: opts}
+    } else {
+      log.Info().
+        Stringer("Device", dev).
+        Dur("Interval", policy.Interval).
+        Int("MaxRetries", policy.MaxRetries).
+        Dur("TimeoutPerAttemptSec", policy.TimeoutPerAttempt).
+        Bool("Align", policy.Align).
+        Stringer("Schedule", policy.Schedule).
+        Msg("Using custom collection policy for device")
+    }
+
+    state := &deviceState{
+      policy: policy,
+      interval: policy.Interval,
+    }
+
+    if policy.Adaptive.enabled() {
+      state.interval = policy.Adaptive.clamp(policy.Interval)
+    }
+
+    if policy.Cadence.enabled() {
+      state.cadence = NewCadence(policy.Cadence)
+    }
+
+    state.nextDue = state.nextDueAfter(start)
+    s.states[dev] = state
+    s.initStatus(dev, state)
+
+    breakerStateGauge.WithLabelValues(dev.Name()).Set(float64(BreakerClosed))
+    burstActiveGauge.WithLabelValues(dev.Name()).Set(0)
+    intervalGauge.WithLabelValues(dev.Name()).Set(state.interval.Seconds())
+  }
+
+  var sinks sync.WaitGroup
+
+  for _, q := range s.sinks {
+    sinks.Add(1)
+
+    go func(q *sinkQueue) {
+      defer sinks.Done()
+      q.run(ctx)
+    }(q)
+  }
+
+  if s.OnDemandTTL > 0 {
+    s.serveOnDemand(ctx)
+  } else {
+    s.runSchedule(ctx)
+  }
+
+  s.shutdown()
+  sinks.Wait()
+}
+
+// Collect devices as they fall due.
+func (s *Recurring) runSchedule(ctx context.Context) {
+  var enabledGen uint64
+
   for {
+    enabledGen = s.applyEnabled(enabledGen)
+
+    // wake up when the next device falls due, or when a burst expires.
+    next := s.applyBursts(time.Now())
+
+    for _, state := range s.states {
+      if next.IsZero() || state.nextDue.Before(next) {
+        next = state.nextDue
+      }
+    }
+
     select {
     case <-ctx.Done():
-      s.shutdown()
       return
-    case <-time.After(interval):
+    case <-s.changed:
+      continue
+    case <-time.After(time.Until(next)):
     }
 
-    wokeUp := false
+    var wakeUp *collectionRequest
 
     // check if no data has been read for too long and suspend if so.
     if suspend, elapsed := s.shouldSuspend(); suspend {
-      if !s.suspended.CompareAndSwap(false, true) {
-        panic("s.shouldSuspended() == true but we're alreadys suspended!?")
-      }
+      s.setSuspended(true)
 
       log.Warn().
         Dur("IdleTimeoutSec", s.IdleTimeout).
@@ -190,68 +501,264 @@ func (s *Recurring) Start(
       // wait until resumed
       select {
       case <-ctx.Done():
-        s.shutdown()
         return
-      case sig := <-s.signal:
-        if sig != signalWakeUp {
-          panic("unexpected signal")
-        }
+      case wakeUp = <-s.requests:
+        s.setSuspended(false)
 
-        if !s.suspended.CompareAndSwap(true, false) {
-          panic("collector woke up from sleep but was not suspended!?")
+        log.Trace().Msg("Collector woke up from sleep - starting immediate collection")
+      case <-s.changed:
+        s.setSuspended(false)
+
+        // WaitLatest() might have sent a request before the collector resumed, and nobody else
+        // would read it: serve it now. No request can be sent once resumed.
+        select {
+        case wakeUp = <-s.requests:
+          enabledGen = s.applyEnabled(enabledGen)
+
+          log.Trace().Msg("Collector woke up from sleep - starting immediate collection")
+        default:
+          log.Trace().Msg("Collector woke up from sleep to apply changes to bursts or enabled devices")
+          continue
         }
+      }
+    }
 
-        wokeUp = true
+    wokeUp := wakeUp != nil
 
-        log.Trace().Msg("Collector woke up from sleep - starting immediate collection")
+    // pick every device due now (or soon enough to be worth batching). after waking up,
+    // everything is stale: collect all devices.
+    now := time.Now()
+
+    batch := s.selectBatch(now, func(dev device.Device, state *deviceState) bool {
+      return wokeUp || !state.nextDue.After(now.Add(s.BatchWindow))
+    })
+
+    if len(batch) > 0 || wokeUp {
+      if !wokeUp {
+        log.Trace().
+          Array("Devices", utils.ToZeroLogArray(batch)).
+          Msg("Recurring collector tick: collecting...")
       }
-    } else {
-      log.Trace().Dur("Interval", interval).Msg("Recurring collector tick: collecting...")
-    }
 
-    collectionResult, err := CollectReadingsWithOptions(s.ble, ctx, s.devices, opts)
+      // intervals are measured from the end of the collection, including for devices collected
+      // early as part of a batch.
+      finished := s.collect(ctx, batch)
 
-    if collectionResult != nil {
-      update := make(map[device.Device]device.Reading)
+      for _, dev := range batch {
+        state := s.states[dev]
 
-      for dev, res := range collectionResult {
-        if res.Error != nil {
-          log.Warn().
-            Stringer("Device", dev).
-            Err(res.Error).
-            Msg("Collection failed for device")
-        } else {
-          log.Debug().
-            Stringer("Device", dev).
-            Stringer("Reading", res.Reading).
-            Msg("Successfully collected data from device")
+        if state.breaker.state == BreakerOpen {
+          continue
+        }
 
-          update[dev] = res.Reading
+        from := finished
+
+        if state.policy.Align {
+          // aligned devices collected early are not collected again at the boundary they were
+          // due at.
+          from = latest(finished, state.nextDue)
         }
+
+        state.nextDue = state.nextDueAfter(from)
       }
+    }
+
+    if wokeUp {
+      s.completeRequest(wakeUp)
+    }
+  }
+}
+
+// Collect stale devices when requested by WaitLatest().
+func (s *Recurring) serveOnDemand(ctx context.Context) {
+  var enabledGen uint64
+
+  for {
+    var req *collectionRequest
+
+    select {
+    case <-ctx.Done():
+      return
+    case <-s.changed:
+      enabledGen = s.applyEnabled(enabledGen)
+      continue
+    case req = <-s.requests:
+    }
+
+    collectionCtx, cancel := context.WithCancel(ctx)
+
+    if deadline, ok := req.ctx.Deadline(); ok {
+      // bounded by the deadline of the request which triggered the collection, but not by its
+      // cancellation: other requests might be waiting for the same collection.
+      collectionCtx, cancel = context.WithDeadline(ctx, deadline)
+    }
+
+    now := time.Now()
+    samples := s.peek()
+
+    batch := s.selectBatch(now, func(dev device.Device, _ *deviceState) bool {
+      sample, ok := samples[dev]
+      return !ok || now.Sub(sample.Time) >= s.OnDemandTTL
+    })
 
-      if len(update) < len(s.devices) {
+    log.Trace().
+      Array("Devices", utils.ToZeroLogArray(batch)).
+      Msg("On-demand collection requested: collecting stale devices...")
+
+    if len(batch) > 0 {
+      s.collect(collectionCtx, batch)
+    }
+
+    cancel()
+    s.completeRequest(req)
+  }
+}
+
+// Drop connections if devices got disabled since the given generation of SetEnabled(). Returns
+// the current generation.
+func (s *Recurring) applyEnabled(gen uint64) uint64 {
+  s.mu.Lock()
+  current, restricted := s.enabledGen, s.enabled != nil
+  s.mu.Unlock()
+
+  if current != gen && restricted {
+    log.Info().Msg("Collection restricted to a subset of devices, dropping connections")
+    s.ble.DisconnectAll()
+  }
+
+  return current
+}
+
+// Pick the devices for which pick() returns true, skipping disabled devices and those with an
+// open circuit breaker.
+func (s *Recurring) selectBatch(
+  now time.Time,
+  pick func(device.Device, *deviceState) bool,
+) (batch []device.Device) {
+  for _, dev := range s.devices {
+    state := s.states[dev]
+
+    if !pick(dev, state) {
+      continue
+    }
+
+    if !s.isEnabled(dev) {
+      state.nextDue = state.nextDueAfter(now)
+      continue
+    }
+
+    if state.burst == nil && state.policy.Schedule.suspended(now) {
+      log.Trace().Stringer("Device", dev).Msg("Collection suspended by schedule")
+      state.nextDue = state.nextDueAfter(now)
+      continue
+    }
+
+    if !state.breaker.allow(now) {
+      state.nextDue = state.breaker.openUntil
+      continue
+    }
+
+    batch = append(batch, dev)
+  }
+
+  return batch
+}
+
+func latest(a, b time.Time) time.Time {
+  if a.After(b) {
+    return a
+  }
+
+  return b
+}
+
+// Collect the specified devices, store their readings and update their circuit breakers and
+// adaptive intervals. Returns when the collection finished.
+func (s *Recurring) collect(ctx context.Context, batch []device.Device) (finished time.Time) {
+  collectionResult, err := CollectReadingsPerDevice(s.ble, ctx, batch, func(dev device.Device) CollectionOptions {
+    state := s.states[dev]
+    opts := state.policy.CollectionOptions
+    opts.Cadence = state.cadence
+
+    if state.breaker.state == BreakerHalfOpen {
+      // a single attempt is enough to probe a device which has been failing.
+      opts.MaxRetries = 0
+    }
+
+    return opts
+  })
+
+  if collectionResult != nil {
+    update := make(map[device.Device]device.Reading)
+    previous, now := s.peek(), time.Now()
+
+    for dev, res := range collectionResult {
+      if res.Error != nil {
         log.Warn().
-          Err(err).
-          Msg("Collection failed for one or more devices!")
+          Stringer("Device", dev).
+          Err(res.Error).
+          Msg("Collection failed for device")
+      } else {
+        log.Debug().
+          Stringer("Device", dev).
+          Stringer("Reading", res.Reading).
+          Msg("Successfully collected data from device")
+
+        update[dev] = res.Reading
+        s.states[dev].adapt(dev, previous[dev], res.Reading, now)
       }
+    }
 
-      if len(update) > 0 {
-        // do not call s.update() with empty data. we can keep returning stale data as needed.
-        // as long as it has the correct timestamp, Prometheus should not report it as new.
-        s.Update(update)
-      }
-    } else {
-      log.Error().
+    if len(update) < len(batch) {
+      log.Warn().
         Err(err).
-        Msg("Collection failed with undefined collection results - this should never happen!")
+        Msg("Collection failed for one or more devices!")
     }
 
-    if wokeUp {
-      select {
-      case s.signal <- signalCollectionFinished:
-      default:
+    if len(update) > 0 {
+      // do not call s.update() with empty data. we can keep returning stale data as needed.
+      // as long as it has the correct timestamp, Prometheus should not report it as new.
+      s.Update(update)
+    }
+  } else {
+    log.Error().
+      Err(err).
+      Msg("Collection failed with undefined collection results - this should never happen!")
+  }
+
+  finished = time.Now()
+
+  for _, dev := range batch {
+    res, ok := collectionResult[dev]
+    state := s.states[dev]
+    b := &state.breaker
+
+    if prev := b.record(s.Breaker, ok && res.Error == nil, finished); prev != b.state {
+      log.Warn().
+        Stringer("Device", dev).
+        Stringer("PreviousState", prev).
+        Stringer("State", b.state).
+        Int("ConsecutiveFailures", b.failures).
+        Dur("CooldownSec", b.cooldown).
+        Msg("Circuit breaker of device changed state")
+
+      breakerStateGauge.WithLabelValues(dev.Name()).Set(float64(b.state))
+    }
+
+    if b.state == BreakerOpen {
+      state.nextDue = b.openUntil
+    }
+
+    devErr := res.Error
+
+    if !ok {
+      if devErr = err; devErr == nil {
+        devErr = errNoResult
       }
     }
+
+    s.recordStatus(dev, devErr, state, finished)
   }
+
+  return finished
 }
@@ -0,0 +1,333 @@
+package collector
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+  "time"
+)
+
+const minutesPerDay = 24 * 60
+
+// ScheduleWindow is a time-of-day range, optionally restricted to some days of the week, during
+// which a device is collected with a different interval or not collected at all.
+type ScheduleWindow struct {
+  // Bitmask of the weekdays (1 << time.Sunday, ...) on which the window starts. Zero means every
+  // day.
+  Days uint8
+  // Minutes since midnight, local time. Windows ending before they start span midnight.
+  Start, End int
+  // Zero suspends collection for the duration of the window.
+  Interval time.Duration
+}
+
+// Schedule is a list of windows, evaluated in order: the first one matching a given time wins.
+// Outside of all windows, the interval of the policy applies.
+type Schedule []ScheduleWindow
+
+var weekdays = map[string]time.Weekday{
+  "sun": time.Sunday,
+  "mon": time.Monday,
+  "tue": time.Tuesday,
+  "wed": time.Wednesday,
+  "thu": time.Thursday,
+  "fri": time.Friday,
+  "sat": time.Saturday,
+}
+
+// ParseSchedule parses a list of windows separated by semicolons, each in the form
+// "[DAYS] HH:MM-HH:MM INTERVAL|off", where DAYS is a comma-separated list of weekdays or ranges
+// of weekdays. For example: "22:00-06:30 off; sat,sun 08:00-20:00 15m; mon-fri 09:00-18:00 1m".
+func ParseSchedule(v string) (Schedule, error) {
+  var schedule Schedule
+
+  for _, rule := range strings.Split(v, ";") {
+    if strings.TrimSpace(rule) == "" {
+      continue
+    }
+
+    window, err := parseScheduleWindow(rule)
+
+    if err != nil {
+      return nil, fmt.Errorf("invalid schedule window %q: %w", strings.TrimSpace(rule), err)
+    }
+
+    schedule = append(schedule, window)
+  }
+
+  return schedule, nil
+}
+
+func parseScheduleWindow(rule string) (w ScheduleWindow, err error) {
+  fields := strings.Fields(rule)
+
+  switch len(fields) {
+  case 2:
+  case 3:
+    if w.Days, err = parseWeekdays(fields[0]); err != nil {
+      return w, err
+    }
+
+    fields = fields[1:]
+  default:
+    return w, fmt.Errorf("expected '[DAYS] HH:MM-HH:MM INTERVAL|off'")
+  }
+
+  from, to, ok := strings.Cut(fields[0], "-")
+
+  if !ok {
+    return w, fmt.Errorf("expected a time range in the form HH:MM-HH:MM, got %q", fields[0])
+  }
+
+  if w.Start, err = parseTimeOfDay(from); err != nil {
+    return w, err
+  }
+
+  if w.End, err = parseTimeOfDay(to); err != nil {
+    return w, err
+  }
+
+  if w.Start == w.End || w.Start == minutesPerDay {
+    return w, fmt.Errorf("empty time range %q", fields[0])
+  }
+
+  if fields[1] != "off" {
+    if w.Interval, err = time.ParseDuration(fields[1]); err != nil {
+      return w, err
+    }
+
+    if w.Interval <= 0 {
+      return w, fmt.Errorf("interval must be positive, got %v", w.Interval)
+    }
+  }
+
+  return w, nil
+}
+
+func parseWeekdays(v string) (mask uint8, err error) {
+  for _, item := range strings.Split(v, ",") {
+    from, to, isRange := strings.Cut(item, "-")
+
+    first, ok := weekdays[strings.ToLower(from)]
+
+    if !ok {
+      return 0, fmt.Errorf("unknown weekday %q", from)
+    }
+
+    last := first
+
+    if isRange {
+      if last, ok = weekdays[strings.ToLower(to)]; !ok {
+        return 0, fmt.Errorf("unknown weekday %q", to)
+      }
+    }
+
+    // ranges wrap around the end of the week, e.g. "fri-mon".
+    for day := first; ; day = (day + 1) % 7 {
+      mask |= 1 << day
+
+      if day == last {
+        break
+      }
+    }
+  }
+
+  return mask, nil
+}
+
+func parseTimeOfDay(v string) (int, error) {
+  hours, minutes, ok := strings.Cut(v, ":")
+
+  if !ok {
+    return 0, fmt.Errorf("expected a time in the form HH:MM, got %q", v)
+  }
+
+  h, err := strconv.Atoi(hours)
+
+  if err != nil || h < 0 || h > 24 {
+    return 0, fmt.Errorf("invalid hour in %q", v)
+  }
+
+  m, err := strconv.Atoi(minutes)
+
+  if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+    return 0, fmt.Errorf("invalid minutes in %q", v)
+  }
+
+  return h * 60 + m, nil
+}
+
+func (s Schedule) String() string {
+  rules := make([]string, len(s))
+
+  for i, w := range s {
+    rules[i] = w.String()
+  }
+
+  return strings.Join(rules, "; ")
+}
+
+func (w ScheduleWindow) String() string {
+  var b strings.Builder
+
+  if w.Days != 0 {
+    var days []string
+
+    for day := time.Sunday; day <= time.Saturday; day += 1 {
+      if w.Days & (1 << day) != 0 {
+        days = append(days, strings.ToLower(day.String()[:3]))
+      }
+    }
+
+    b.WriteString(strings.Join(days, ","))
+    b.WriteByte(' ')
+  }
+
+  fmt.Fprintf(&b, "%02d:%02d-%02d:%02d ", w.Start / 60, w.Start % 60, w.End / 60, w.End % 60)
+
+  if w.Interval == 0 {
+    b.WriteString("off")
+  } else {
+    b.WriteString(w.Interval.String())
+  }
+
+  return b.String()
+}
+
+func (w ScheduleWindow) startsOn(day time.Weekday) bool {
+  return w.Days == 0 || w.Days & (1 << day) != 0
+}
+
+// Local midnight of the day of t, offset by the given number of days.
+func midnight(t time.Time, days int) time.Time {
+  y, m, d := t.Date()
+  return time.Date(y, m, d + days, 0, 0, 0, 0, t.Location())
+}
+
+func atMinute(day time.Time, minutes int) time.Time {
+  return time.Date(day.Year(), day.Month(), day.Day(), 0, minutes, 0, 0, day.Location())
+}
+
+// The window active at t, if any.
+func (s Schedule) at(t time.Time) *ScheduleWindow {
+  for i := range s {
+    w := &s[i]
+
+    // a window started either today or, if it spans midnight, yesterday.
+    for _, days := range []int{0, -1} {
+      day := midnight(t, days)
+
+      if !w.startsOn(day.Weekday()) {
+        continue
+      }
+
+      start, end := atMinute(day, w.Start), atMinute(day, w.End)
+
+      if w.End <= w.Start {
+        end = atMinute(midnight(day, 1), w.End)
+      }
+
+      if !t.Before(start) && t.Before(end) {
+        return w
+      }
+    }
+  }
+
+  return nil
+}
+
+// The first time after t at which a window starts or ends, or the zero time if the schedule is
+// empty.
+func (s Schedule) nextTransition(t time.Time) (next time.Time) {
+  for _, w := range s {
+    for days := -1; days <= 7; days += 1 {
+      day := midnight(t, days)
+
+      if !w.startsOn(day.Weekday()) {
+        continue
+      }
+
+      start, end := atMinute(day, w.Start), atMinute(day, w.End)
+
+      if w.End <= w.Start {
+        end = atMinute(midnight(day, 1), w.End)
+      }
+
+      for _, b := range []time.Time{start, end} {
+        if b.After(t) && (next.IsZero() || b.Before(next)) {
+          next = b
+        }
+      }
+    }
+  }
+
+  return next
+}
+
+// Whether collection is suspended by the schedule at t.
+func (s Schedule) suspended(t time.Time) bool {
+  w := s.at(t)
+  return w != nil && w.Interval == 0
+}
+
+// The first boundary of interval after t, counting from local midnight on the wall clock: the
+// boundaries stay at the same times of day across daylight saving time changes.
+func alignedAfter(t time.Time, interval time.Duration) time.Time {
+  y, m, d := t.Date()
+  elapsed := time.Duration(t.Hour()) * time.Hour + time.Duration(t.Minute()) * time.Minute +
+    time.Duration(t.Second()) * time.Second + time.Duration(t.Nanosecond())
+  next := time.Date(y, m, d, 0, 0, 0, int((elapsed / interval + 1) * interval), t.Location())
+
+  // times of day repeat when clocks go back, and might resolve to their first occurrence.
+  for !next.After(t) {
+    next = next.Add(interval)
+  }
+
+  return next
+}
+
+// The time of the next collection of a device last collected at t, according to its interval,
+// alignment and schedule. Collections also happen whenever a schedule window starts or ends,
+// unless collection is suspended at that time.
+func (p Policy) nextDue(t time.Time) time.Time {
+  // bounded, in case the schedule suspends collection at all times.
+  for i, boundary := 0, false; i <= 2 * 8 * len(p.Schedule); i += 1 {
+    w := p.Schedule.at(t)
+
+    if w != nil && w.Interval == 0 {
+      t, boundary = p.Schedule.nextTransition(t), true
+      continue
+    }
+
+    if boundary {
+      return t
+    }
+
+    interval := p.Interval
+
+    if w != nil {
+      interval = w.Interval
+    }
+
+    next := t.Add(interval)
+
+    if p.Align {
+      next = alignedAfter(t, interval)
+    }
+
+    if transition := p.Schedule.nextTransition(t); !transition.IsZero() && !transition.After(next) {
+      t, boundary = transition, true
+      continue
+    }
+
+    return next
+  }
+
+  return t
+}
+
+// *flag.Value
+func (s *Schedule) Set(v string) (err error) {
+  *s, err = ParseSchedule(v)
+  return err
+}
@@ -0,0 +1,129 @@
+package collector
+
+import (
+  "testing"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/internal/testutil"
+)
+
+func TestParseSchedule(t *testing.T) {
+  schedule, err := ParseSchedule("22:00-06:30 off; fri-mon 08:00-20:00 15m;")
+
+  if err != nil {
+    t.Fatalf("ParseSchedule() got error: %v", err)
+  }
+
+  if got, want := schedule.String(), "22:00-06:30 off; sun,mon,fri,sat 08:00-20:00 15m0s"; got != want {
+    t.Fatalf("ParseSchedule() = %q, wanted %q", got, want)
+  }
+
+  for _, invalid := range []string{
+    "22:00 off",
+    "22:00-22:00 off",
+    "25:00-06:00 off",
+    "someday 08:00-20:00 1m",
+    "08:00-20:00 -1m",
+  } {
+    if _, err := ParseSchedule(invalid); err == nil {
+      t.Errorf("ParseSchedule(%q) succeeded, wanted an error", invalid)
+    }
+  }
+}
+
+func TestPolicyNextDue(t *testing.T) {
+  schedule, _ := ParseSchedule("22:00-06:30 off; sat,sun 08:00-20:00 15m")
+
+  policy := Policy{
+    Interval: 5 * time.Minute,
+    Align: true,
+    Schedule: schedule,
+  }
+
+  // 2024-01-05 is a Friday.
+  at := func(day, hour, min, sec int) time.Time {
+    return time.Date(2024, 1, day, hour, min, sec, 0, time.Local)
+  }
+
+  for _, tc := range []struct {
+    name string
+    from, want time.Time
+  }{
+    {"aligned", at(5, 10, 2, 13), at(5, 10, 5, 0)},
+    {"aligned on a boundary", at(5, 10, 5, 0), at(5, 10, 10, 0)},
+    {"quiet hours", at(5, 21, 58, 0), at(6, 6, 30, 0)},
+    {"during quiet hours", at(6, 1, 0, 0), at(6, 6, 30, 0)},
+    {"window start", at(6, 7, 58, 0), at(6, 8, 0, 0)},
+    {"window interval", at(6, 8, 0, 0), at(6, 8, 15, 0)},
+    {"window end", at(6, 19, 50, 0), at(6, 20, 0, 0)},
+  } {
+    if got := policy.nextDue(tc.from); !got.Equal(tc.want) {
+      t.Errorf("%s: nextDue(%v) = %v, wanted %v", tc.name, tc.from, got, tc.want)
+    }
+  }
+
+  policy.Align = false
+
+  if got, want := policy.nextDue(at(5, 10, 2, 13)), at(5, 10, 7, 13); !got.Equal(want) {
+    t.Errorf("unaligned: nextDue() = %v, wanted %v", got, want)
+  }
+}
+
+func TestAlignedAfterDST(t *testing.T) {
+  loc, err := time.LoadLocation("Europe/Rome")
+
+  if err != nil {
+    t.Skip(err)
+  }
+
+  // clocks go forward on 2024-03-31 at 02:00, and back on 2024-10-27 at 03:00.
+  for _, tc := range []struct {
+    name string
+    from, want time.Time
+    interval time.Duration
+  }{
+    {"forward", time.Date(2024, 3, 31, 1, 0, 0, 0, loc), time.Date(2024, 3, 31, 6, 0, 0, 0, loc), 6 * time.Hour},
+    {"back", time.Date(2024, 10, 27, 4, 0, 0, 0, loc), time.Date(2024, 10, 27, 6, 0, 0, 0, loc), 6 * time.Hour},
+    {"repeated hour", time.Date(2024, 10, 27, 1, 30, 0, 0, loc).Add(2 * time.Hour), time.Date(2024, 10, 27, 1, 30, 0, 0, loc).Add(2 * time.Hour + 15 * time.Minute), 15 * time.Minute},
+  } {
+    if got := alignedAfter(tc.from, tc.interval); !got.Equal(tc.want) {
+      t.Errorf("%s: alignedAfter(%v, %v) = %v, wanted %v", tc.name, tc.from, tc.interval, got, tc.want)
+    }
+  }
+}
+
+
+func TestScheduledInterval(t *testing.T) {
+  schedule, _ := ParseSchedule("22:00-06:30 off; sat,sun 08:00-20:00 15m")
+  dev := testutil.FakeDevice("fridge")
+  s := NewRecurring(nil, []device.Device{dev})
+  st := deviceStatus{interval: 5 * time.Minute, schedule: schedule}
+
+  // 2024-01-05 is a Friday.
+  at := func(day, hour int) time.Time {
+    return time.Date(2024, 1, day, hour, 0, 0, 0, time.Local)
+  }
+
+  for _, tc := range []struct {
+    name string
+    at time.Time
+    interval time.Duration
+    suspended bool
+  }{
+    {"policy", at(5, 10), 5 * time.Minute, false},
+    {"quiet hours", at(5, 23), 0, true},
+    {"window", at(6, 10), 15 * time.Minute, false},
+  } {
+    if interval, suspended := s.intervalLocked(dev, st, tc.at); interval != tc.interval || suspended != tc.suspended {
+      t.Errorf("%s: intervalLocked() = %v, %v, wanted %v, %v", tc.name, interval, suspended, tc.interval, tc.suspended)
+    }
+  }
+
+  // bursts override quiet hours.
+  s.bursts[dev] = Burst{Interval: 10 * time.Second, Until: at(5, 23)}
+
+  if interval, suspended := s.intervalLocked(dev, st, at(5, 22)); interval != 10 * time.Second || suspended {
+    t.Errorf("burst: intervalLocked() = %v, %v, wanted 10s, false", interval, suspended)
+  }
+}
@@ -0,0 +1,298 @@
+package collector
+
+import (
+  "context"
+  "fmt"
+  "io"
+  "slices"
+  "sync"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  DefaultSinkQueueSize = 1000
+
+  // How long sinks get to deliver the readings still queued on shutdown.
+  sinkShutdownTimeout = 10 * time.Second
+
+  SinkFieldName = "name"
+  SinkFieldQueueSize = "queue-size"
+  SinkFieldDropPolicy = "drop-policy"
+)
+
+// SinkReading is a successful reading of a device, along with the time it was collected at.
+type SinkReading struct {
+  Device device.Device
+  // Free-form labels of the device, from its spec. May be empty.
+  Labels map[string]string
+  model.Sample
+}
+
+// Sink receives every successful reading collected by Recurring, e.g. to push it to a message
+// broker or to a time series database.
+//
+// Readings are queued separately for each sink, so that a slow sink doesn't hold up collections
+// or other sinks. Sinks which also implement io.Closer are closed once the collector stops.
+type Sink interface {
+  // Deliver a batch of readings, in the order they were collected. Called from a single
+  // goroutine. Failed batches are not retried: sinks needing durability must buffer on their own.
+  Deliver(ctx context.Context, readings []SinkReading) error
+}
+
+// DropPolicy decides which readings are dropped when the queue of a sink is full.
+type DropPolicy string
+
+const (
+  // Drop the oldest queued reading to make room for the new one.
+  DropOldest DropPolicy = "drop-oldest"
+  // Drop the new reading, keeping the queue as is.
+  DropNewest DropPolicy = "drop-newest"
+)
+
+var allDropPolicies = []DropPolicy{DropOldest, DropNewest}
+
+// *flag.Value
+func (p *DropPolicy) String() string {
+  return string(*p)
+}
+
+func (p *DropPolicy) Set(v string) error {
+  policy := DropPolicy(v)
+
+  if !slices.Contains(allDropPolicies, policy) {
+    return fmt.Errorf("unknown drop policy %v (must be one of %v)", policy, allDropPolicies)
+  }
+
+  *p = policy
+  return nil
+}
+
+// SinkSchema lists the spec parameters shared by every sink type. queue-size and drop-policy
+// default to the global flags.
+var SinkSchema = device.Schema{
+  {
+    Name: SinkFieldName,
+    Type: device.ParamTypeString,
+    Description: "Name of this sink in logs and metrics. Defaults to the sink type, followed by a number if repeated",
+  },
+  {
+    Name: SinkFieldQueueSize,
+    Type: device.ParamTypeInt,
+    Description: "Max number of readings waiting to be delivered to this sink. Overrides -sink-queue-size",
+  },
+  {
+    Name: SinkFieldDropPolicy,
+    Type: device.ParamTypeString,
+    AllowedValues: []string{string(DropOldest), string(DropNewest)},
+    Description: "Which readings are dropped when the queue of this sink is full. Overrides -sink-drop-policy",
+  },
+}
+
+// SinkOptions controls how readings are queued for a sink.
+type SinkOptions struct {
+  QueueSize int
+  DropPolicy DropPolicy
+}
+
+// WithOverrides returns a copy of the options with the parameters explicitly set in params (parsed
+// using SinkSchema) applied.
+func (o SinkOptions) WithOverrides(params device.Params) (SinkOptions, error) {
+  if params.IsSet(SinkFieldQueueSize) {
+    o.QueueSize = params.Int(SinkFieldQueueSize)
+  }
+
+  if params.IsSet(SinkFieldDropPolicy) {
+    if err := o.DropPolicy.Set(params.String(SinkFieldDropPolicy)); err != nil {
+      return o, fmt.Errorf("%w: %s: %w", device.ErrInvalidSpec, SinkFieldDropPolicy, err)
+    }
+  }
+
+  if o.QueueSize <= 0 {
+    return o, fmt.Errorf("%w: %s must be positive, got %v", device.ErrInvalidSpec, SinkFieldQueueSize, o.QueueSize)
+  }
+
+  return o, nil
+}
+
+// Bounded queue of readings waiting to be delivered to a sink.
+type sinkQueue struct {
+  name string
+  sink Sink
+  opts SinkOptions
+
+  mu sync.Mutex
+  queue []SinkReading
+  // wakes up run() when readings are queued.
+  ready chan struct{}
+}
+
+func newSinkQueue(name string, sink Sink, opts SinkOptions) *sinkQueue {
+  sinkQueueLengthGauge.WithLabelValues(name).Set(0)
+
+  for _, outcome := range []string{"delivered", "failed", "dropped"} {
+    sinkReadingsCounter.WithLabelValues(name, outcome)
+  }
+
+  return &sinkQueue{
+    name: name,
+    sink: sink,
+    opts: opts,
+    ready: make(chan struct{}, 1),
+  }
+}
+
+// Queue readings for delivery, applying the drop policy if the queue is full. Never blocks.
+func (q *sinkQueue) push(readings []SinkReading) {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+
+  dropped := 0
+
+  for _, r := range readings {
+    if len(q.queue) >= q.opts.QueueSize {
+      dropped += 1
+
+      if q.opts.DropPolicy == DropNewest {
+        continue
+      }
+
+      q.queue = q.queue[1:]
+    }
+
+    q.queue = append(q.queue, r)
+  }
+
+  if dropped > 0 {
+    log.Warn().
+      Str("Sink", q.name).
+      Int("Dropped", dropped).
+      Stringer("DropPolicy", &q.opts.DropPolicy).
+      Msg("Sink queue is full, dropping readings")
+
+    sinkReadingsCounter.WithLabelValues(q.name, "dropped").Add(float64(dropped))
+  }
+
+  sinkQueueLengthGauge.WithLabelValues(q.name).Set(float64(len(q.queue)))
+
+  select {
+  case q.ready <- struct{}{}:
+  default:
+  }
+}
+
+// Take every queued reading.
+func (q *sinkQueue) take() []SinkReading {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+
+  batch := q.queue
+  q.queue = nil
+
+  sinkQueueLengthGauge.WithLabelValues(q.name).Set(0)
+
+  return batch
+}
+
+// Deliver queued readings until ctx is done, then deliver the remaining ones and close the sink
+// if needed.
+func (q *sinkQueue) run(ctx context.Context) {
+  defer func() {
+    if closer, ok := q.sink.(io.Closer); ok {
+      if err := closer.Close(); err != nil {
+        log.Warn().Err(err).Str("Sink", q.name).Msg("Failed to close sink")
+      }
+    }
+  }()
+
+  for {
+    select {
+    case <-ctx.Done():
+      // ctx is done already: the last delivery gets its own deadline.
+      shutdownCtx, cancel := context.WithTimeout(context.Background(), sinkShutdownTimeout)
+      defer cancel()
+
+      q.deliver(shutdownCtx, q.take())
+      return
+    case <-q.ready:
+    }
+
+    q.deliver(ctx, q.take())
+  }
+}
+
+func (q *sinkQueue) deliver(ctx context.Context, batch []SinkReading) {
+  if len(batch) == 0 {
+    return
+  }
+
+  start := time.Now()
+  err := q.sink.Deliver(ctx, batch)
+
+  sinkDeliveryDurationHistogram.WithLabelValues(q.name).Observe(time.Since(start).Seconds())
+
+  if err != nil {
+    log.Warn().
+      Err(err).
+      Str("Sink", q.name).
+      Int("Readings", len(batch)).
+      Msg("Failed to deliver readings to sink")
+
+    sinkReadingsCounter.WithLabelValues(q.name, "failed").Add(float64(len(batch)))
+    return
+  }
+
+  log.Trace().
+    Str("Sink", q.name).
+    Int("Readings", len(batch)).
+    Msg("Delivered readings to sink")
+
+  sinkReadingsCounter.WithLabelValues(q.name, "delivered").Add(float64(len(batch)))
+}
+
+// AddSink delivers every successful reading to sink, including those passed to Update() before
+// Start(). Must be called before Start().
+func (s *Recurring) AddSink(name string, sink Sink, opts SinkOptions) {
+  if s.started {
+    panic("attempted to call collector.Recurring.AddSink() after Start()")
+  }
+
+  s.sinks = append(s.sinks, newSinkQueue(name, sink, opts))
+}
+
+// Attach free-form labels to the readings of a device delivered to sinks. Must be called before
+// Start().
+func (s *Recurring) SetLabels(dev device.Device, labels map[string]string) {
+  if s.started {
+    panic("attempted to call collector.Recurring.SetLabels() after Start()")
+  }
+
+  s.labels[dev] = labels
+}
+
+// Queue new readings, collected at t, for every sink.
+func (s *Recurring) publish(r map[device.Device]device.Reading, t time.Time) {
+  if len(s.sinks) == 0 {
+    return
+  }
+
+  readings := make([]SinkReading, 0, len(r))
+
+  // keep the order of the devices stable.
+  for _, dev := range s.devices {
+    if reading, ok := r[dev]; ok {
+      readings = append(readings, SinkReading{
+        Device: dev,
+        Labels: s.labels[dev],
+        Sample: model.Sample{Reading: reading, Time: t},
+      })
+    }
+  }
+
+  for _, q := range s.sinks {
+    q.push(readings)
+  }
+}
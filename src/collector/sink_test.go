@@ -0,0 +1,91 @@
+package collector
+
+import (
+  "context"
+  "testing"
+  "time"
+)
+
+type chanSink chan []SinkReading
+
+func (s chanSink) Deliver(ctx context.Context, readings []SinkReading) error {
+  s <- readings
+  return nil
+}
+
+func readingsAt(secs ...int64) (out []SinkReading) {
+  for _, sec := range secs {
+    r := SinkReading{}
+    r.Time = time.Unix(sec, 0)
+    out = append(out, r)
+  }
+
+  return out
+}
+
+func times(readings []SinkReading) (out []int64) {
+  for _, r := range readings {
+    out = append(out, r.Time.Unix())
+  }
+
+  return out
+}
+
+func TestSinkQueueDropPolicy(t *testing.T) {
+  for _, tc := range []struct {
+    policy DropPolicy
+    want []int64
+  }{
+    {DropOldest, []int64{2, 3}},
+    {DropNewest, []int64{1, 2}},
+  } {
+    q := newSinkQueue("test-" + string(tc.policy), nil, SinkOptions{QueueSize: 2, DropPolicy: tc.policy})
+    q.push(readingsAt(1, 2))
+    q.push(readingsAt(3))
+
+    if got := times(q.take()); len(got) != 2 || got[0] != tc.want[0] || got[1] != tc.want[1] {
+      t.Errorf("%v: got %v, wanted %v", tc.policy, got, tc.want)
+    }
+  }
+}
+
+func TestSinkQueueDelivery(t *testing.T) {
+  sink := make(chanSink, 1)
+  q := newSinkQueue("test", sink, SinkOptions{QueueSize: 10, DropPolicy: DropOldest})
+
+  ctx, cancel := context.WithCancel(context.Background())
+  defer cancel()
+
+  go q.run(ctx)
+
+  q.push(readingsAt(1, 2))
+
+  select {
+  case got := <-sink:
+    if ts := times(got); len(ts) != 2 || ts[0] != 1 || ts[1] != 2 {
+      t.Errorf("got %v, wanted [1 2]", ts)
+    }
+  case <-time.After(time.Second):
+    t.Fatal("timed out waiting for delivery")
+  }
+}
+
+func TestSinkQueueDrainsOnShutdown(t *testing.T) {
+  sink := make(chanSink, 1)
+  q := newSinkQueue("test-shutdown", sink, SinkOptions{QueueSize: 10, DropPolicy: DropOldest})
+
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+
+  q.push(readingsAt(1))
+  q.run(ctx)
+
+  select {
+  case got := <-sink:
+    if ts := times(got); len(ts) != 1 || ts[0] != 1 {
+      t.Errorf("got %v, wanted [1]", ts)
+    }
+  default:
+    t.Fatal("queued readings were not delivered on shutdown")
+  }
+}
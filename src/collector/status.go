@@ -0,0 +1,151 @@
+package collector
+
+import (
+  "errors"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+)
+
+var errNoResult = errors.New("no result for device")
+
+// Readings of a device grow stale once older than this many collection intervals of the device.
+const staleIntervals = 3
+
+// DeviceStatus is the health of a device, as of its last collection by Recurring.
+type DeviceStatus struct {
+  Device device.Device
+  Labels map[string]string
+  // Whether the device is allowed to be collected, see SetEnabled().
+  Enabled bool
+  // Zero until the device is collected by Start(): readings passed to Update() beforehand don't
+  // count.
+  LastAttempt time.Time
+  // Error of the last collection, nil if it succeeded.
+  LastError error
+  ConsecutiveFailures int
+  Breaker BreakerState
+  // Interval the device is currently collected at: that of its policy, adapted to its readings,
+  // or overridden by its schedule or a burst. Zero until Start() is called.
+  Interval time.Duration
+  // Whether the schedule of the device currently suspends its collection.
+  Suspended bool
+  // Age after which the readings of the device are stale: a few times its interval, or
+  // Recurring.StaleAfter if longer. Readings don't grow stale while the device is Suspended.
+  StaleAfter time.Duration
+}
+
+// The outcome of the last collection of a device, and how it is scheduled. protected by mu.
+type deviceStatus struct {
+  lastAttempt time.Time
+  lastError error
+  failures int
+  breaker BreakerState
+  // adapted interval and schedule of the device, as of its last collection.
+  interval time.Duration
+  schedule Schedule
+}
+
+// Record how the device is scheduled, before its first collection.
+func (s *Recurring) initStatus(dev device.Device, state *deviceState) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  s.status[dev] = deviceStatus{interval: state.interval, schedule: state.policy.Schedule}
+}
+
+// Record the outcome of the collection of a device, finished at t.
+func (s *Recurring) recordStatus(dev device.Device, err error, state *deviceState, t time.Time) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  s.status[dev] = deviceStatus{
+    lastAttempt: t,
+    lastError: err,
+    failures: state.breaker.failures,
+    breaker: state.breaker.state,
+    interval: state.interval,
+    schedule: state.policy.Schedule,
+  }
+}
+
+// The interval the device is collected at, at t, and whether its schedule suspends collection.
+// Must be called with mu held.
+func (s *Recurring) intervalLocked(dev device.Device, st deviceStatus, t time.Time) (time.Duration, bool) {
+  if burst, ok := s.bursts[dev]; ok && burst.activeAt(t) {
+    return burst.Interval, false
+  }
+
+  if w := st.schedule.at(t); w != nil {
+    return w.Interval, w.Interval == 0
+  }
+
+  return st.interval, false
+}
+
+// Devices returns the status of every device, in the order they were passed to NewRecurring().
+func (s *Recurring) Devices() []DeviceStatus {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  out := make([]DeviceStatus, 0, len(s.devices))
+  now := time.Now()
+
+  for _, dev := range s.devices {
+    st := s.status[dev]
+    interval, suspended := s.intervalLocked(dev, st, now)
+    staleAfter := staleIntervals * interval
+
+    if staleAfter < s.StaleAfter {
+      staleAfter = s.StaleAfter
+    }
+
+    out = append(out, DeviceStatus{
+      Device: dev,
+      Labels: s.labels[dev],
+      Enabled: s.enabledLocked(dev),
+      LastAttempt: st.lastAttempt,
+      LastError: st.lastError,
+      ConsecutiveFailures: st.failures,
+      Breaker: st.breaker,
+      Interval: interval,
+      Suspended: suspended,
+      StaleAfter: staleAfter,
+    })
+  }
+
+  return out
+}
+
+// Snapshot returns the latest collected samples, like Latest(), without waking up the collector
+// nor counting as a read: health checks must not keep the collector awake.
+func (s *Recurring) Snapshot() map[device.Device]model.Sample {
+  return s.peek()
+}
+
+// Idle returns whether readings are expected to grow old, since nobody asked for them: the
+// collector is suspended for inactivity, or only collects on demand.
+func (s *Recurring) Idle() bool {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  return s.suspended || s.OnDemandTTL > 0
+}
+
+// Status returns the status of the device with the given name.
+func (s *Recurring) Status(name string) (DeviceStatus, error) {
+  dev, err := s.Device(name)
+
+  if err != nil {
+    return DeviceStatus{}, err
+  }
+
+  for _, st := range s.Devices() {
+    if st.Device == dev {
+      return st, nil
+    }
+  }
+
+  panic("unreachable")
+}
@@ -4,45 +4,252 @@ import (
   "flag"
   "fmt"
   "os"
+  "slices"
+  "sort"
   "time"
 
+  "github.com/robertof/go-inkbird-exporter/alert"
+  "github.com/robertof/go-inkbird-exporter/api"
   "github.com/robertof/go-inkbird-exporter/ble"
   "github.com/robertof/go-inkbird-exporter/collector"
   "github.com/robertof/go-inkbird-exporter/device"
   "github.com/robertof/go-inkbird-exporter/device/inkbird"
+  "github.com/robertof/go-inkbird-exporter/ha"
+  "github.com/robertof/go-inkbird-exporter/history"
+  "github.com/robertof/go-inkbird-exporter/metrics"
+  "github.com/robertof/go-inkbird-exporter/sink"
+  "github.com/robertof/go-inkbird-exporter/sink/file"
+  "github.com/robertof/go-inkbird-exporter/sink/influxdb"
+  "github.com/robertof/go-inkbird-exporter/sink/mqtt"
+  "github.com/robertof/go-inkbird-exporter/sink/otlp"
+  "github.com/robertof/go-inkbird-exporter/sink/remotewrite"
+  "github.com/robertof/go-inkbird-exporter/sink/textfile"
+  "github.com/robertof/go-inkbird-exporter/sink/webhook"
+  "golang.org/x/exp/maps"
 )
 
 type config struct {
   Debug, Trace bool
   BindAddress string
+  StaleAfter time.Duration
+  ReadyMinFreshRatio float64
   EnableMetamonitoring bool
   DiscoverDevices bool
+  PrintDeviceDocs bool
   BluetoothDeviceId int
   BluetoothConnParams ble.ConnParams
   PersistConnections bool
+  RadioArbitration ble.ArbitrationMode
+  MaxParallelConnections int
   MaxRetries int
   InitialCollectionTimeout, CollectionTimeout time.Duration
   CollectionInterval, CollectionIdleTimeout time.Duration
-  Backoff time.Duration
+  AlignCollections bool
+  Schedule collector.Schedule
+  Adaptive collector.AdaptiveOptions
+  Cadence collector.CadenceOptions
+  OnDemandTTL time.Duration
+  ScrapeTimeoutOffset time.Duration
+  Backoff, MaxBackoff time.Duration
+  BackoffJitter float64
+  Breaker collector.BreakerOptions
+  HALockFile, HAPeer string
+  HA ha.Options
+  HAFollowerMode ha.FollowerMode
   Devices []device.Device
+  // Collection policy overrides from the device specs, validated against collector.PolicySchema.
+  PolicyOverrides map[device.Device]device.Params
+  // Free-form labels from the device specs.
+  DeviceLabels map[device.Device]map[string]string
+  SinkOptions collector.SinkOptions
+  Sinks []configuredSink
+  Once bool
+  Push metrics.PushOptions
+  HistoryDB string
+  HistoryOptions history.Options
+  // Opened from HistoryDB by openHistory(), if set. Also one of Sinks from then on.
+  History *history.Store
+  // Fed with readings for /api/v1/stream, unless the HTTP server is disabled. Also one of Sinks.
+  Stream *api.Stream
+  AlertRules []alert.Rule
+  AlertWebhooks []*alert.Webhook
+  // Evaluates AlertRules, if any. Also one of Sinks.
+  Alerts *alert.Engine
+}
+
+// Names of the sinks feeding the history, the stream and the alerts.
+const (
+  historySinkName = "history"
+  streamSinkName = "stream"
+  alertsSinkName = "alerts"
+)
+
+type configuredSink struct {
+  Name string
+  collector.Sink
+  // Queue overrides from the sink spec, validated against collector.SinkSchema.
+  Overrides device.Params
 }
 
 type boundDeviceList struct {
   device.Factory
   name string
   list *[]device.Device
+  policyOverrides map[device.Device]device.Params
+  labels map[device.Device]map[string]string
 }
 
 var deviceFactories = map[string]device.Factory {
   "inkbird": &inkbird.Factory{},
 }
 
+type boundSinkList struct {
+  sink.Factory
+  kind string
+  list *[]configuredSink
+}
+
+var sinkFactories = map[string]sink.Factory {
+  "file": &file.Factory{},
+  "influxdb": &influxdb.Factory{},
+  "mqtt": &mqtt.Factory{},
+  "otlp": &otlp.Factory{},
+  "remote-write": &remotewrite.Factory{},
+  "textfile": &textfile.Factory{},
+  "webhook": &webhook.Factory{},
+}
+
+func (l *boundSinkList) String() string {
+  return ""
+}
+
+func (l *boundSinkList) Set(v string) error {
+  ss, err := device.ParseDeviceSpec(v)
+  if err != nil {
+    return err
+  }
+
+  overrides, err := collector.SinkSchema.Parse(ss.Extract(collector.SinkSchema))
+  if err != nil {
+    return err
+  }
+
+  s, err := l.FromSpec(ss)
+  if err != nil {
+    return fmt.Errorf("failed to create sink: %w", err)
+  }
+
+  name := overrides.String(collector.SinkFieldName)
+
+  if name == "" {
+    name = l.kind
+
+    for n := 2; sinkNameTaken(*l.list, name); n += 1 {
+      name = fmt.Sprintf("%s-%d", l.kind, n)
+    }
+  } else if sinkNameTaken(*l.list, name) {
+    return fmt.Errorf("%w: sink name %q used more than once", device.ErrInvalidSpec, name)
+  }
+
+  *l.list = append(*l.list, configuredSink{Name: name, Sink: s, Overrides: overrides})
+
+  return nil
+}
+
+func sinkNameTaken(sinks []configuredSink, name string) bool {
+  for _, s := range sinks {
+    if s.Name == name {
+      return true
+    }
+  }
+
+  return false
+}
+
+// Alert rules in the form of `key=value,key=value`, parsed like device specs.
+type alertRuleList []alert.Rule
+
+func (l *alertRuleList) String() string {
+  return ""
+}
+
+func (l *alertRuleList) Set(v string) error {
+  spec, err := device.ParseDeviceSpec(v)
+  if err != nil {
+    return err
+  }
+
+  r, err := alert.ParseRule(spec)
+  if err != nil {
+    return err
+  }
+
+  *l = append(*l, r)
+
+  return nil
+}
+
+type alertWebhookList []*alert.Webhook
+
+func (l *alertWebhookList) String() string {
+  return ""
+}
+
+func (l *alertWebhookList) Set(v string) error {
+  spec, err := device.ParseDeviceSpec(v)
+  if err != nil {
+    return err
+  }
+
+  w, err := alert.NewWebhookFromSpec(spec)
+  if err != nil {
+    return err
+  }
+
+  *l = append(*l, w)
+
+  return nil
+}
+
+// Labels in the form of `key=value,key=value`, parsed like device specs.
+type labelsFlag map[string]string
+
+func (l labelsFlag) String() string {
+  return device.DeviceSpec(l).String()
+}
+
+func (l labelsFlag) Set(v string) error {
+  ls, err := device.ParseDeviceSpec(v)
+  if err != nil {
+    return err
+  }
+
+  for k, v := range ls {
+    l[k] = v
+  }
+
+  return nil
+}
+
 func (d *boundDeviceList) String() string {
   return ""
 }
 
 func (d *boundDeviceList) Set(v string) error {
-  ds := device.NewDeviceSpec(v)
+  ds, err := device.ParseDeviceSpec(v)
+  if err != nil {
+    return err
+  }
+
+  policy, err := collector.PolicySchema.Parse(ds.Extract(collector.PolicySchema))
+  if err != nil {
+    return err
+  }
+
+  labels, err := ds.ExtractLabels()
+  if err != nil {
+    return err
+  }
 
   device, err := d.FromSpec(ds)
   if err != nil {
@@ -50,6 +257,8 @@ func (d *boundDeviceList) Set(v string) error {
   }
 
   *d.list = append(*d.list, device)
+  d.policyOverrides[device] = policy
+  d.labels[device] = labels
 
   return nil
 }
@@ -57,25 +266,116 @@ func (d *boundDeviceList) Set(v string) error {
 func ParseArgs() config {
   var cfg config
 
+  cfg.PolicyOverrides = make(map[device.Device]device.Params)
+  cfg.DeviceLabels = make(map[device.Device]map[string]string)
   cfg.BluetoothConnParams = ble.ConnParamsDefault
+  cfg.RadioArbitration = ble.ArbitrationParallel
+  cfg.HAFollowerMode = ha.FollowerPassive
+  cfg.SinkOptions.DropPolicy = collector.DropOldest
+  cfg.Push.Grouping = make(map[string]string)
 
-  flag.StringVar(&cfg.BindAddress,"bind", "localhost:9102", "Where the exporter will bind to")
+  flag.StringVar(&cfg.BindAddress,"bind", "localhost:9102",
+    "Where the exporter will bind to. Empty to disable the HTTP server, e.g. when only using sinks")
+  flag.DurationVar(&cfg.StaleAfter, "stale-after", 0,
+    "Min age after which readings are considered stale, on the dashboard and by /readyz. Readings are stale once older than 3 collection intervals of their device, or than this if longer")
+  flag.DurationVar(&cfg.StaleAfter, "dashboard-stale-after", 0, "Deprecated: use -stale-after")
+  flag.Float64Var(&cfg.ReadyMinFreshRatio, "ready-min-fresh", 0.5,
+    "Min share (0 to 1) of the devices with a fresh reading for /readyz to succeed")
   flag.IntVar(&cfg.BluetoothDeviceId, "bluetooth-device", 0, "Bluetooth (HCI) device ID")
   flag.Var(&cfg.BluetoothConnParams, "bluetooth-connection-params", "Bluetooth connection parameters (one of 'default' or 'power-saving')")
+  flag.Var(&cfg.RadioArbitration, "radio-arbitration",
+    "How scans and connection attempts share the radio (one of 'parallel', 'interleaved' or 'sequential')")
+  flag.IntVar(&cfg.MaxParallelConnections, "max-parallel-connections", 0,
+    "Max number of parallel connection attempts, others are queued (0 for no limit)")
   flag.BoolVar(&cfg.PersistConnections, "persist-connections", true, "Persist Bluetooth connections between collections")
   flag.BoolVar(&cfg.DiscoverDevices, "discover", false, "Discover available BLE devices and quit")
+  flag.BoolVar(&cfg.PrintDeviceDocs, "device-docs", false,
+    "Print a Markdown reference of the parameters supported by each device type and quit")
   flag.BoolVar(&cfg.EnableMetamonitoring, "metamonitoring", true, "Enable metamonitoring metrics")
   flag.IntVar(&cfg.MaxRetries, "max-retries", collector.DefaultMaxRetries, "Max number of retries")
   flag.DurationVar(&cfg.InitialCollectionTimeout, "initial-timeout", 3 * time.Second,
     "Timeout for the collection done on start (per retry attempt)")
   flag.DurationVar(&cfg.CollectionTimeout, "timeout", collector.DefaultTimeoutPerAttempt,
     "Timeout for the periodic collections (per retry attempt)")
+  flag.Float64Var(&cfg.Cadence.Percentile, "timeout-percentile", 0,
+    "If set, learn the scan timeout of each device from this percentile (0 to 1, e.g. 0.95) of " +
+    "its recent advertising delays instead of using -timeout")
+  flag.DurationVar(&cfg.Cadence.MinTimeout, "min-timeout", collector.DefaultMinScanTimeout,
+    "Lower bound for learned scan timeouts")
+  flag.DurationVar(&cfg.Cadence.MaxTimeout, "max-timeout", collector.DefaultMaxScanTimeout,
+    "Upper bound for learned scan timeouts")
   flag.DurationVar(&cfg.CollectionInterval, "interval", 300 * time.Second,
     "How frequently data collection happens")
+  flag.BoolVar(&cfg.AlignCollections, "align", false,
+    "Align collections to wall-clock boundaries of the interval (e.g. every 5 minutes on the minute)")
+  flag.Var(&cfg.Schedule, "schedule",
+    "Schedule windows with a different interval, or 'off' to suspend collection, " +
+    "e.g. '22:00-06:00 off; sat,sun 08:00-20:00 15m'")
+  flag.Float64Var(&cfg.Adaptive.Threshold, "change-threshold", 0,
+    "Change per minute of the readings (Celsius or humidity percentage points) above which the " +
+    "interval is halved, down to -min-interval. Slower changes grow it up to -max-interval (0 to disable)")
+  flag.DurationVar(&cfg.Adaptive.MinInterval, "min-interval", time.Minute,
+    "Lower bound for the adaptive interval")
+  flag.DurationVar(&cfg.Adaptive.MaxInterval, "max-interval", 30 * time.Minute,
+    "Upper bound for the adaptive interval")
   flag.DurationVar(&cfg.CollectionIdleTimeout, "idle-timeout", -1,
     "Timeout after which the collector is shut down if no data is read. Defaults to 3 * CollectionInterval")
+  flag.DurationVar(&cfg.OnDemandTTL, "on-demand-ttl", 0,
+    "If set, collect on scrape instead of periodically, whenever data is older than this")
+  flag.DurationVar(&cfg.ScrapeTimeoutOffset, "scrape-timeout-offset", 500 * time.Millisecond,
+    "Margin subtracted from the Prometheus scrape timeout before serving stale data")
   flag.DurationVar(&cfg.Backoff, "backoff", collector.DefaultBackoffFactor,
     "Exponential backoff factor for retries")
+  flag.DurationVar(&cfg.MaxBackoff, "max-backoff", collector.DefaultMaxBackoff,
+    "Upper bound for the backoff between retries (0 for unbounded)")
+  flag.Float64Var(&cfg.BackoffJitter, "backoff-jitter", collector.DefaultBackoffJitter,
+    "Randomized fraction (0 to 1) of each backoff between retries")
+  flag.IntVar(&cfg.Breaker.Threshold, "breaker-threshold", collector.DefaultBreakerThreshold,
+    "Consecutive failed collections after which a device is only probed once per cooldown (0 to disable)")
+  flag.DurationVar(&cfg.Breaker.Cooldown, "breaker-cooldown", collector.DefaultBreakerCooldown,
+    "Initial cooldown between probes of a failing device, doubled after each failed probe")
+  flag.DurationVar(&cfg.Breaker.MaxCooldown, "breaker-max-cooldown", collector.DefaultBreakerMaxCooldown,
+    "Upper bound for the cooldown between probes of a failing device")
+  flag.StringVar(&cfg.HALockFile, "ha-lock-file", "",
+    "Enable high-availability mode, electing the leader through a lease in this file shared with the other instance")
+  flag.StringVar(&cfg.HAPeer, "ha-peer", "",
+    "Enable high-availability mode, electing the leader through heartbeats with the other instance at this URL " +
+    "(e.g. 'http://other-pi:9102')")
+  flag.StringVar(&cfg.HA.ID, "ha-id", "",
+    "Identifier of this instance in high-availability mode (defaults to the hostname)")
+  flag.DurationVar(&cfg.HA.HeartbeatInterval, "ha-heartbeat-interval", ha.DefaultHeartbeatInterval,
+    "How often the leader is checked on in high-availability mode")
+  flag.DurationVar(&cfg.HA.LeaseTimeout, "ha-lease-timeout", ha.DefaultLeaseTimeout,
+    "How long without heartbeats before the follower takes over in high-availability mode")
+  flag.Var(&cfg.HAFollowerMode, "ha-follower-mode",
+    "What the follower collects in high-availability mode (one of 'passive' for scanned devices only, or 'idle')")
+  flag.IntVar(&cfg.SinkOptions.QueueSize, "sink-queue-size", collector.DefaultSinkQueueSize,
+    "Max number of readings waiting to be delivered to each sink")
+  flag.Var(&cfg.SinkOptions.DropPolicy, "sink-drop-policy",
+    "Which readings are dropped when the queue of a sink is full (one of 'drop-oldest' or 'drop-newest')")
+  flag.BoolVar(&cfg.Once, "once", false,
+    "Collect every device once, push the readings to -pushgateway-url and to the sinks, then exit. " +
+    "The exit status is the number of devices which failed (up to 100), or 101 if pushing failed")
+  flag.StringVar(&cfg.Push.URL, "pushgateway-url", "",
+    "Prometheus Pushgateway to push the readings to with -once (e.g. 'http://pushgateway:9091')")
+  flag.StringVar(&cfg.Push.Job, "pushgateway-job", "inkbird-exporter", "Job of the metrics pushed to the Pushgateway")
+  flag.Var(labelsFlag(cfg.Push.Grouping), "pushgateway-grouping",
+    "Grouping key of the metrics pushed to the Pushgateway, besides the job, in the form of " +
+    "`key=value,key=value` (defaults to instance=<hostname>)")
+  flag.DurationVar(&cfg.Push.Timeout, "pushgateway-timeout", metrics.DefaultPushTimeout,
+    "Timeout of pushes to the Pushgateway")
+  flag.StringVar(&cfg.HistoryDB, "history-db", "",
+    "Record every reading in this SQLite database, queryable through the API (e.g. '/var/lib/inkbird/history.db')")
+  flag.DurationVar(&cfg.HistoryOptions.Retention, "history-retention", history.DefaultRetention,
+    "How long every reading is kept in the history, before being downsampled to hourly min/max/avg")
+  flag.DurationVar(&cfg.HistoryOptions.DownsampledRetention, "history-downsampled-retention", 0,
+    "How long the hourly min/max/avg are kept in the history (0 to keep them forever)")
+  flag.Var((*alertRuleList)(&cfg.AlertRules), "alert-rule",
+    "Alert rule in the form of `key=value,key=value`, evaluated against every reading and notified " +
+    "to -alert-webhook. Can be repeated. Labels can be attached with 'label.<name>=<value>'.\n" + alert.RuleSchema.Help())
+  flag.Var((*alertWebhookList)(&cfg.AlertWebhooks), "alert-webhook",
+    "Send alerts firing and resolving to a webhook, configured with a spec in the form of " +
+    "`key=value,key=value`. Can be repeated. Headers can be added with 'header.<name>=<value>'.\n" + alert.WebhookSchema.Help())
   flag.BoolVar(&cfg.Debug, "debug", false, "Enable debug logs")
   flag.BoolVar(&cfg.Trace, "trace", false, "Enable trace logs")
 
@@ -84,15 +384,28 @@ func ParseArgs() config {
       name:    deviceName,
       Factory: deviceFactory,
       list:    &cfg.Devices,
+      policyOverrides: cfg.PolicyOverrides,
+      labels: cfg.DeviceLabels,
     }
 
-    help := "Device spec for this device in the form of `key=value,key=value`."
+    help := "Device spec for this device in the form of `key=value,key=value`. Values can be " +
+      "quoted and special characters escaped with a backslash. Free-form labels, used by sinks, can " +
+      "be attached with 'label.<name>=<value>'.\n" + deviceSchema(deviceFactory).Help()
 
-    if docs, ok := deviceFactory.(device.FactoryDocs); ok {
-      help += "\n" + docs.Help()
+    flag.Var(&boundList, deviceName, help)
+  }
+
+  for sinkKind, sinkFactory := range sinkFactories {
+    boundList := boundSinkList{
+      Factory: sinkFactory,
+      kind: sinkKind,
+      list: &cfg.Sinks,
     }
 
-    flag.Var(&boundList, deviceName, help)
+    help := "Deliver every reading to a sink of this type, configured with a spec in the form of " +
+      "`key=value,key=value`. Can be repeated.\n" + sinkSchema(sinkFactory).Help()
+
+    flag.Var(&boundList, "sink-" + sinkKind, help)
   }
 
   flag.Parse()
@@ -101,11 +414,188 @@ func ParseArgs() config {
     cfg.CollectionIdleTimeout = cfg.CollectionInterval * 3
   }
 
+  if cfg.PrintDeviceDocs {
+    printDeviceDocs()
+    os.Exit(0)
+  }
+
   if !cfg.DiscoverDevices && len(cfg.Devices) == 0 {
     fmt.Fprintln(os.Stderr, "Error: at least one device is required!")
     flag.Usage()
     os.Exit(1)
   }
 
+  if cfg.HA.ID == "" {
+    cfg.HA.ID = ha.DefaultID()
+  }
+
+  if cfg.HALockFile != "" && cfg.HAPeer != "" {
+    fmt.Fprintln(os.Stderr, "Error: -ha-lock-file and -ha-peer are mutually exclusive")
+    os.Exit(1)
+  }
+
+  if cfg.ReadyMinFreshRatio < 0 || cfg.ReadyMinFreshRatio > 1 {
+    fmt.Fprintln(os.Stderr, "Error: -ready-min-fresh must be between 0 and 1")
+    os.Exit(1)
+  }
+
+  if cfg.HAPeer != "" && cfg.BindAddress == "" {
+    fmt.Fprintln(os.Stderr, "Error: -ha-peer requires the HTTP server, see -bind")
+    os.Exit(1)
+  }
+
+  if cfg.HA.HeartbeatInterval <= 0 || cfg.HA.LeaseTimeout <= cfg.HA.HeartbeatInterval {
+    fmt.Fprintln(os.Stderr, "Error: -ha-lease-timeout must be longer than -ha-heartbeat-interval")
+    os.Exit(1)
+  }
+
+  if cfg.Push.URL != "" && !cfg.Once {
+    fmt.Fprintln(os.Stderr, "Error: -pushgateway-url requires -once")
+    os.Exit(1)
+  }
+
+  if cfg.Once && (cfg.HALockFile != "" || cfg.HAPeer != "") {
+    fmt.Fprintln(os.Stderr, "Error: -once can't be used in high-availability mode")
+    os.Exit(1)
+  }
+
+  if host, err := os.Hostname(); err == nil && len(cfg.Push.Grouping) == 0 {
+    cfg.Push.Grouping["instance"] = host
+  }
+
+  if cfg.Breaker.Threshold > 0 && cfg.Breaker.Cooldown <= 0 {
+    fmt.Fprintln(os.Stderr, "Error: -breaker-cooldown must be positive when the breaker is enabled")
+    os.Exit(1)
+  }
+
+  if cfg.Breaker.Threshold > 0 && cfg.Breaker.MaxCooldown < cfg.Breaker.Cooldown {
+    fmt.Fprintln(os.Stderr, "Error: -breaker-max-cooldown must not be shorter than -breaker-cooldown")
+    os.Exit(1)
+  }
+
+  if cfg.HistoryDB != "" {
+    if cfg.HistoryOptions.Retention <= 0 {
+      fmt.Fprintln(os.Stderr, "Error: -history-retention must be positive")
+      os.Exit(1)
+    }
+
+    if sinkNameTaken(cfg.Sinks, historySinkName) {
+      fmt.Fprintf(os.Stderr, "Error: sink name %q is reserved for -history-db\n", historySinkName)
+      os.Exit(1)
+    }
+  }
+
+  if cfg.BindAddress != "" && !cfg.Once {
+    if sinkNameTaken(cfg.Sinks, streamSinkName) {
+      fmt.Fprintf(os.Stderr, "Error: sink name %q is reserved for %vstream\n", streamSinkName, api.Prefix)
+      os.Exit(1)
+    }
+
+    overrides, _ := collector.SinkSchema.Parse(device.DeviceSpec{})
+    cfg.Stream = api.NewStream()
+    cfg.Sinks = append(cfg.Sinks, configuredSink{Name: streamSinkName, Sink: cfg.Stream, Overrides: overrides})
+  }
+
+  if len(cfg.AlertWebhooks) > 0 && len(cfg.AlertRules) == 0 {
+    fmt.Fprintln(os.Stderr, "Error: -alert-webhook requires at least one -alert-rule")
+    os.Exit(1)
+  }
+
+  if len(cfg.AlertRules) > 0 {
+    if cfg.Once {
+      fmt.Fprintln(os.Stderr, "Error: -alert-rule can't be used with -once")
+      os.Exit(1)
+    }
+
+    for _, r := range cfg.AlertRules {
+      if !slices.ContainsFunc(cfg.Devices, func(dev device.Device) bool { return r.Device == "" || dev.Name() == r.Device }) {
+        fmt.Fprintf(os.Stderr, "Error: alert rule %q refers to unknown device %q\n", r.Name, r.Device)
+        os.Exit(1)
+      }
+    }
+
+    if sinkNameTaken(cfg.Sinks, alertsSinkName) {
+      fmt.Fprintf(os.Stderr, "Error: sink name %q is reserved for -alert-rule\n", alertsSinkName)
+      os.Exit(1)
+    }
+
+    cfg.Alerts = alert.NewEngine(cfg.AlertRules, cfg.Devices)
+
+    for i, w := range cfg.AlertWebhooks {
+      name := "webhook"
+
+      if i > 0 {
+        name = fmt.Sprintf("webhook-%d", i + 1)
+      }
+
+      cfg.Alerts.AddNotifier(name, w)
+    }
+
+    overrides, _ := collector.SinkSchema.Parse(device.DeviceSpec{})
+    cfg.Sinks = append(cfg.Sinks, configuredSink{Name: alertsSinkName, Sink: cfg.Alerts, Overrides: overrides})
+  }
+
+  for _, s := range cfg.Sinks {
+    if _, err := cfg.SinkOptions.WithOverrides(s.Overrides); err != nil {
+      fmt.Fprintf(os.Stderr, "Error: invalid queue options for sink %q: %v\n", s.Name, err)
+      os.Exit(1)
+    }
+  }
+
+  for _, dev := range cfg.Devices {
+    if _, err := cfg.DevicePolicy(dev, cfg.CollectionTimeout); err != nil {
+      fmt.Fprintf(os.Stderr, "Error: invalid collection policy for %v: %v\n", dev, err)
+      os.Exit(1)
+    }
+  }
+
   return cfg
 }
+
+// The collection policy of a device: the global flags, with the timeout passed explicitly, and
+// the overrides from the device spec applied on top.
+func (cfg config) DevicePolicy(dev device.Device, timeout time.Duration) (collector.Policy, error) {
+  return collector.Policy{
+    Interval: cfg.CollectionInterval,
+    Align: cfg.AlignCollections,
+    Schedule: cfg.Schedule,
+    Adaptive: cfg.Adaptive,
+    Cadence: cfg.Cadence,
+    CollectionOptions: collector.CollectionOptions{
+      TimeoutPerAttempt: timeout,
+      MaxRetries: cfg.MaxRetries,
+      BackoffFactor: cfg.Backoff,
+      MaxBackoff: cfg.MaxBackoff,
+      BackoffJitter: cfg.BackoffJitter,
+    },
+  }.WithOverrides(cfg.PolicyOverrides[dev])
+}
+
+// Every parameter accepted by the device specs of a factory.
+func deviceSchema(f device.Factory) device.Schema {
+  return append(append(device.Schema{}, f.Schema()...), collector.PolicySchema...)
+}
+
+// Every parameter accepted by the specs of a sink factory.
+func sinkSchema(f sink.Factory) device.Schema {
+  return append(append(device.Schema{}, f.Schema()...), collector.SinkSchema...)
+}
+
+func printDeviceDocs() {
+  names := maps.Keys(deviceFactories)
+  sort.Strings(names)
+
+  for _, name := range names {
+    fmt.Printf("### `-%s`\n\n%s\n", name, deviceSchema(deviceFactories[name]).Markdown())
+  }
+
+  names = maps.Keys(sinkFactories)
+  sort.Strings(names)
+
+  for _, name := range names {
+    fmt.Printf("### `-sink-%s`\n\n%s\n", name, sinkSchema(sinkFactories[name]).Markdown())
+  }
+
+  fmt.Printf("### `-alert-rule`\n\n%s\n", alert.RuleSchema.Markdown())
+  fmt.Printf("### `-alert-webhook`\n\n%s\n", alert.WebhookSchema.Markdown())
+}
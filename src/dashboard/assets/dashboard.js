@@ -0,0 +1,308 @@
+"use strict";
+
+// Relative to the page, so that the dashboard also works behind a reverse proxy under a subpath.
+const API = "api/v1/";
+// Span of the sparklines.
+const SPARKLINE_WINDOW_MS = 6 * 60 * 60 * 1000;
+const HEALTH_REFRESH_MS = 30 * 1000;
+const AGE_REFRESH_MS = 10 * 1000;
+const PROBE_COLORS = ["var(--line)", "#e0823d", "#9b59b6", "#2eaa9b"];
+
+const container = document.getElementById("devices");
+
+// State of every device, by name, in configuration order.
+const devices = new Map();
+
+async function getJSON(path) {
+  const resp = await fetch(API + path);
+
+  if (!resp.ok) {
+    throw new Error(`${path}: ${resp.status}`);
+  }
+
+  return resp.json();
+}
+
+async function refreshDevices() {
+  const { devices: list } = await getJSON("devices");
+
+  for (const d of list) {
+    let state = devices.get(d.name);
+
+    if (!state) {
+      state = { reading: null, history: [] };
+      devices.set(d.name, state);
+      loadDevice(d.name, state);
+    }
+
+    state.info = d;
+  }
+
+  render();
+}
+
+// The initial reading and history of a device. The history is only available with -history-db:
+// otherwise, sparklines start empty and fill up as readings are streamed.
+async function loadDevice(name, state) {
+  const path = "devices/" + encodeURIComponent(name);
+
+  try {
+    const { reading } = await getJSON(path + "/reading");
+
+    if (reading && !state.reading) {
+      addReading(state, reading);
+    }
+  } catch (e) {
+    console.warn(e);
+  }
+
+  try {
+    const { series } = await getJSON(path + "/history?from=" + (SPARKLINE_WINDOW_MS / 1000) + "s");
+    const byProbe = [];
+
+    for (const s of series) {
+      if (s.metric === "temperature") {
+        byProbe[s.probe] = s.points.map((p) => ({ time: Date.parse(p.time), value: p.value }));
+      }
+    }
+
+    // merge with the readings streamed in the meantime.
+    const probes = Math.max(byProbe.length, state.history.length);
+
+    state.history = Array.from({ length: probes }, (_, probe) => {
+      const points = byProbe[probe] || [];
+      const last = points.length > 0 ? points[points.length - 1].time : -Infinity;
+
+      return points.concat((state.history[probe] || []).filter((p) => p.time > last));
+    });
+  } catch (e) {
+    // history disabled.
+  }
+
+  render();
+}
+
+function addReading(state, reading) {
+  const time = Date.parse(reading.time);
+
+  if (state.reading && Date.parse(state.reading.time) >= time) {
+    return;
+  }
+
+  state.reading = reading;
+
+  reading.temperatures.forEach((value, probe) => {
+    const points = state.history[probe] || (state.history[probe] = []);
+    points.push({ time, value });
+
+    while (points[0].time < time - SPARKLINE_WINDOW_MS) {
+      points.shift();
+    }
+  });
+}
+
+function subscribe() {
+  const banner = document.getElementById("connection");
+  const events = new EventSource(API + "stream");
+
+  events.onopen = () => {
+    banner.hidden = true;
+  };
+
+  events.onerror = () => {
+    banner.hidden = false;
+  };
+
+  events.onmessage = (e) => {
+    const reading = JSON.parse(e.data);
+    const state = devices.get(reading.device);
+
+    if (state) {
+      addReading(state, reading);
+      render();
+    }
+  };
+}
+
+// The status shown for a device: disabled, failing, stale, unknown (never read) or ok.
+function status(state) {
+  const health = state.info.health;
+
+  if (health.status === "disabled") {
+    return { cls: "unknown", text: "other replica" };
+  }
+
+  if (health.status === "failing") {
+    return { cls: "failing", text: "failing" };
+  }
+
+  if (!state.reading) {
+    return { cls: "unknown", text: "no data" };
+  }
+
+  // unset while the schedule of the device suspends its collection.
+  const staleAfter = health.stale_after_seconds;
+
+  if (staleAfter !== undefined && Date.now() - Date.parse(state.reading.time) > staleAfter * 1000) {
+    return { cls: "stale", text: "stale" };
+  }
+
+  return { cls: "ok", text: "ok" };
+}
+
+function formatAge(time) {
+  const secs = Math.max(0, Math.round((Date.now() - Date.parse(time)) / 1000));
+
+  if (secs < 60) {
+    return secs + "s ago";
+  } else if (secs < 3600) {
+    return Math.floor(secs / 60) + " min ago";
+  } else if (secs < 86400) {
+    return Math.floor(secs / 3600) + " h ago";
+  }
+
+  return Math.floor(secs / 86400) + " d ago";
+}
+
+function probeLabel(reading, probe) {
+  if (reading.temperatures.length === 1) {
+    return reading.probe_type === "external" ? "Probe" : "Temperature";
+  }
+
+  return "Probe " + (probe + 1);
+}
+
+function el(tag, cls, text) {
+  const e = document.createElement(tag);
+
+  if (cls) {
+    e.className = cls;
+  }
+
+  if (text !== undefined) {
+    e.textContent = text;
+  }
+
+  return e;
+}
+
+function sparkline(history) {
+  const ns = "http://www.w3.org/2000/svg";
+  const since = Date.now() - SPARKLINE_WINDOW_MS;
+  const series = history.map((points) => (points || []).filter((p) => p.time >= since));
+  const values = series.flat().map((p) => p.value);
+
+  if (values.length < 2) {
+    return null;
+  }
+
+  let lo = Math.min(...values), hi = Math.max(...values);
+
+  if (hi - lo < 1) {
+    // avoid magnifying noise on flat lines.
+    lo -= 0.5;
+    hi += 0.5;
+  }
+
+  const svg = document.createElementNS(ns, "svg");
+  svg.setAttribute("class", "sparkline");
+  svg.setAttribute("viewBox", "0 0 100 30");
+  svg.setAttribute("preserveAspectRatio", "none");
+
+  series.forEach((points, probe) => {
+    if (points.length < 2) {
+      return;
+    }
+
+    const line = document.createElementNS(ns, "polyline");
+    line.setAttribute("stroke", PROBE_COLORS[probe % PROBE_COLORS.length]);
+    line.setAttribute("points", points.map((p) =>
+      `${((p.time - since) / SPARKLINE_WINDOW_MS * 100).toFixed(2)},${(29 - (p.value - lo) / (hi - lo) * 28).toFixed(2)}`).join(" "));
+    svg.appendChild(line);
+  });
+
+  const title = document.createElementNS(ns, "title");
+  title.textContent = `Last ${SPARKLINE_WINDOW_MS / 3600000} hours: ${lo.toFixed(1)} to ${hi.toFixed(1)} °C`;
+  svg.appendChild(title);
+
+  return svg;
+}
+
+function renderDevice(name, state) {
+  const st = status(state);
+  const card = el("section", "device " + st.cls);
+  const title = el("h2", null, name);
+  const reading = state.reading;
+
+  title.appendChild(el("span", "badge", st.text));
+  card.appendChild(title);
+
+  if (reading) {
+    const temps = el("div", "temperatures");
+
+    reading.temperatures.forEach((t, probe) => {
+      const temp = el("div", "temperature");
+      temp.appendChild(el("span", "label", probeLabel(reading, probe)));
+      temp.appendChild(el("span", "value", t.toFixed(1) + " °C"));
+      temps.appendChild(temp);
+    });
+
+    card.appendChild(temps);
+
+    const details = el("div", "details");
+
+    if (reading.humidity !== undefined) {
+      details.appendChild(el("span", null, "Humidity " + reading.humidity.toFixed(0) + "%"));
+    }
+
+    if (reading.battery !== undefined) {
+      details.appendChild(el("span", null, "Battery " + reading.battery + "%"));
+    }
+
+    const seen = el("span", null, "Seen " + formatAge(reading.time));
+    seen.title = new Date(reading.time).toLocaleString();
+    details.appendChild(seen);
+    card.appendChild(details);
+
+    const spark = sparkline(state.history);
+
+    if (spark) {
+      card.appendChild(spark);
+    }
+  }
+
+  const health = state.info.health;
+
+  if (health.status === "failing" && health.last_error) {
+    card.appendChild(el("div", "error",
+      `Last ${health.consecutive_failures} collection(s) failed: ${health.last_error}`));
+  }
+
+  return card;
+}
+
+function render() {
+  const cards = [];
+
+  for (const [name, state] of devices) {
+    if (state.info) {
+      cards.push(renderDevice(name, state));
+    }
+  }
+
+  if (cards.length === 0) {
+    cards.push(el("p", "empty", "No devices configured."));
+  }
+
+  container.replaceChildren(...cards);
+}
+
+refreshDevices()
+  .catch((e) => {
+    container.replaceChildren(el("p", "empty", "Failed to load devices: " + e.message));
+  })
+  .finally(() => {
+    subscribe();
+    setInterval(() => refreshDevices().catch(console.warn), HEALTH_REFRESH_MS);
+    setInterval(render, AGE_REFRESH_MS);
+  });
@@ -0,0 +1,42 @@
+// Package dashboard serves a web page showing the latest readings of every device, for those who'd
+// rather not open Grafana. The page is built on top of the API under api.Prefix, and its assets
+// are compiled into the binary.
+package dashboard
+
+import (
+  "embed"
+  "io/fs"
+  "net/http"
+
+  "github.com/rs/zerolog/log"
+)
+
+//go:embed assets
+var assets embed.FS
+
+// Handler serves the dashboard, to be mounted on "/". Devices are flagged as stale according to
+// their health, see api.DeviceHealth.
+func Handler() http.Handler {
+  index, _ := assets.ReadFile("assets/index.html")
+  sub, _ := fs.Sub(assets, "assets")
+  files := http.FileServer(http.FS(sub))
+
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Path != "/" {
+      files.ServeHTTP(w, r)
+      return
+    }
+
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+      w.Header().Set("Allow", "GET, HEAD")
+      http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+      return
+    }
+
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+    if _, err := w.Write(index); err != nil {
+      log.Debug().Err(err).Msg("dashboard: failed to write page")
+    }
+  })
+}
@@ -0,0 +1,30 @@
+package dashboard
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+func TestHandler(t *testing.T) {
+  h := Handler()
+
+  for _, tc := range []struct {
+    path string
+    code int
+    contains string
+  }{
+    {"/", http.StatusOK, `<script src="dashboard.js">`},
+    {"/dashboard.js", http.StatusOK, "EventSource"},
+    {"/dashboard.css", http.StatusOK, ".sparkline"},
+    {"/metrics.html", http.StatusNotFound, ""},
+  } {
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+    if rec.Code != tc.code || !strings.Contains(rec.Body.String(), tc.contains) {
+      t.Errorf("%v: got %d %q, wanted %d containing %q", tc.path, rec.Code, rec.Body.String(), tc.code, tc.contains)
+    }
+  }
+}
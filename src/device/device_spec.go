@@ -1,31 +1,73 @@
 package device
 
 import (
+  "errors"
+  "fmt"
+  "sort"
   "strings"
-
-  "github.com/rs/zerolog/log"
+  "unicode"
 )
 
+// DeviceSpec holds the raw `key=value` parameters describing a device. Use a Schema to turn it
+// into typed Params.
 type DeviceSpec map[string]string
 
 const (
   DeviceSpecFieldName = "name"
   DeviceSpecFieldAddress = "addr"
+  // Prefix of the parameters holding free-form labels of a device.
+  DeviceSpecLabelPrefix = "label."
 )
 
-func NewDeviceSpec(s string) DeviceSpec {
+var ErrInvalidSpec = errors.New("invalid spec")
+
+// ParseDeviceSpec parses a spec in the form of `key=value,key=value`.
+//
+// Values can be wrapped in single or double quotes to preserve commas and surrounding whitespace,
+// and any character can be escaped with a backslash (e.g. `name=living\, room`). Unquoted values
+// are trimmed. Empty entries are skipped, while entries without a `=` and repeated keys are
+// rejected.
+func ParseDeviceSpec(s string) (DeviceSpec, error) {
   spec := DeviceSpec{}
-  entries := strings.Split(s, ",")
+  p := specParser{input: []rune(s)}
 
-  for _, entry := range entries {
-    parts := strings.SplitN(entry, "=", 2)
+  for {
+    p.skipSpaces()
+
+    if p.eof() {
+      return spec, nil
+    }
 
-    if len(parts) != 2 {
-      log.Warn().Str("Entry", entry).Msg("Skipping invalid device spec entry")
+    if p.peek() == ',' {
+      p.pos += 1
       continue
     }
 
-    spec[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+    key, err := p.key()
+    if err != nil {
+      return nil, err
+    }
+
+    value, err := p.value()
+    if err != nil {
+      return nil, fmt.Errorf("%w: parameter %q: %v", ErrInvalidSpec, key, err)
+    }
+
+    if _, ok := spec[key]; ok {
+      return nil, fmt.Errorf("%w: parameter %q specified more than once", ErrInvalidSpec, key)
+    }
+
+    spec[key] = value
+  }
+}
+
+// MustParseDeviceSpec is like ParseDeviceSpec but panics on invalid specs. Meant for specs
+// embedded in code, such as tests.
+func MustParseDeviceSpec(s string) DeviceSpec {
+  spec, err := ParseDeviceSpec(s)
+
+  if err != nil {
+    panic(err)
   }
 
   return spec
@@ -38,3 +80,205 @@ func (ds DeviceSpec) Name() string {
 func (ds DeviceSpec) Addr() string {
   return ds[DeviceSpecFieldAddress]
 }
+
+// Extract removes the parameters described by the schema from the spec and returns them as a
+// separate spec. Useful to validate parameters shared by all device types separately.
+func (ds DeviceSpec) Extract(s Schema) DeviceSpec {
+  extracted := DeviceSpec{}
+
+  for _, p := range s {
+    if v, ok := ds[p.Name]; ok {
+      extracted[p.Name] = v
+      delete(ds, p.Name)
+    }
+  }
+
+  return extracted
+}
+
+// ExtractLabels removes the free-form labels (e.g. `label.room=kitchen`) from the spec and returns
+// them by name, without the prefix. Label names must be valid Prometheus label names.
+func (ds DeviceSpec) ExtractLabels() (map[string]string, error) {
+  labels := ds.ExtractPrefixed(DeviceSpecLabelPrefix)
+
+  for name := range labels {
+    if !isLabelName(name) {
+      return nil, fmt.Errorf("%w: invalid label name %q (must match [a-zA-Z_][a-zA-Z0-9_]*)", ErrInvalidSpec, name)
+    }
+  }
+
+  return labels, nil
+}
+
+// ExtractPrefixed removes the parameters starting with prefix from the spec and returns them by
+// name, without the prefix.
+func (ds DeviceSpec) ExtractPrefixed(prefix string) map[string]string {
+  extracted := make(map[string]string)
+
+  for k, v := range ds {
+    if name, ok := strings.CutPrefix(k, prefix); ok {
+      extracted[name] = v
+      delete(ds, k)
+    }
+  }
+
+  return extracted
+}
+
+func isLabelName(name string) bool {
+  for i, r := range name {
+    if !(r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9')) {
+      return false
+    }
+  }
+
+  return name != ""
+}
+
+// String returns the spec in a form accepted by ParseDeviceSpec, with keys sorted.
+func (ds DeviceSpec) String() string {
+  keys := make([]string, 0, len(ds))
+
+  for k := range ds {
+    keys = append(keys, k)
+  }
+
+  sort.Strings(keys)
+
+  entries := make([]string, len(keys))
+
+  for i, k := range keys {
+    entries[i] = k + "=" + quoteSpecValue(ds[k])
+  }
+
+  return strings.Join(entries, ",")
+}
+
+func quoteSpecValue(v string) string {
+  if v != strings.TrimSpace(v) || strings.ContainsAny(v, `,="'\`) {
+    return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+  }
+
+  return v
+}
+
+type specParser struct {
+  input []rune
+  pos int
+}
+
+func (p *specParser) eof() bool {
+  return p.pos >= len(p.input)
+}
+
+func (p *specParser) peek() rune {
+  return p.input[p.pos]
+}
+
+func (p *specParser) skipSpaces() {
+  for !p.eof() && unicode.IsSpace(p.peek()) {
+    p.pos += 1
+  }
+}
+
+func (p *specParser) key() (string, error) {
+  start := p.pos
+
+  for !p.eof() && p.peek() != '=' && p.peek() != ',' {
+    p.pos += 1
+  }
+
+  key := strings.TrimSpace(string(p.input[start:p.pos]))
+
+  if p.eof() || p.peek() != '=' {
+    return "", fmt.Errorf("%w: entry %q is not in the form of key=value", ErrInvalidSpec, key)
+  }
+
+  if key == "" {
+    return "", fmt.Errorf("%w: empty parameter name at offset %d", ErrInvalidSpec, start)
+  }
+
+  for _, r := range key {
+    if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r != '.' {
+      return "", fmt.Errorf("%w: invalid character %q in parameter name %q", ErrInvalidSpec, r, key)
+    }
+  }
+
+  p.pos += 1 // skip '='
+
+  return key, nil
+}
+
+func (p *specParser) value() (string, error) {
+  p.skipSpaces()
+
+  var b strings.Builder
+
+  if !p.eof() && (p.peek() == '"' || p.peek() == '\'') {
+    quote := p.peek()
+    p.pos += 1
+
+    for {
+      if p.eof() {
+        return "", fmt.Errorf("unterminated %c quote", quote)
+      }
+
+      r := p.peek()
+      p.pos += 1
+
+      if r == quote {
+        break
+      }
+
+      if r == '\\' {
+        if p.eof() {
+          return "", errors.New("dangling escape character")
+        }
+
+        r = p.peek()
+        p.pos += 1
+      }
+
+      b.WriteRune(r)
+    }
+
+    p.skipSpaces()
+
+    if !p.eof() && p.peek() != ',' {
+      return "", fmt.Errorf("unexpected %q after quoted value", p.peek())
+    }
+
+    return b.String(), nil
+  }
+
+  // unquoted: read until the next unescaped comma, trimming trailing (unescaped) whitespace.
+  keep := 0
+
+  for !p.eof() && p.peek() != ',' {
+    r := p.peek()
+    p.pos += 1
+
+    if r == '\\' {
+      if p.eof() {
+        return "", errors.New("dangling escape character")
+      }
+
+      b.WriteRune(p.peek())
+      p.pos += 1
+      keep = b.Len()
+      continue
+    }
+
+    if r == '"' || r == '\'' {
+      return "", fmt.Errorf("unexpected %c quote inside unquoted value", r)
+    }
+
+    b.WriteRune(r)
+
+    if !unicode.IsSpace(r) {
+      keep = b.Len()
+    }
+  }
+
+  return b.String()[:keep], nil
+}
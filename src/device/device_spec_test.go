@@ -0,0 +1,134 @@
+package device_test
+
+import (
+  "errors"
+  "reflect"
+  "testing"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/device"
+)
+
+func TestParseDeviceSpec(t *testing.T) {
+  tests := []struct {
+    in string
+    want device.DeviceSpec
+  }{
+    {"addr=aa:bb:cc:dd:ee:ff, name=foo", device.DeviceSpec{"addr": "aa:bb:cc:dd:ee:ff", "name": "foo"}},
+    {" name = living room ,", device.DeviceSpec{"name": "living room"}},
+    {`name="a, b = c"`, device.DeviceSpec{"name": "a, b = c"}},
+    {`name='  padded '`, device.DeviceSpec{"name": "  padded "}},
+    {`name=a\,b\\c\ `, device.DeviceSpec{"name": `a,b\c `}},
+    {`name="say \"hi\""`, device.DeviceSpec{"name": `say "hi"`}},
+    {"name=", device.DeviceSpec{"name": ""}},
+    {"", device.DeviceSpec{}},
+  }
+
+  for _, tc := range tests {
+    got, err := device.ParseDeviceSpec(tc.in)
+
+    if err != nil {
+      t.Errorf("ParseDeviceSpec(%q) got error: %v", tc.in, err)
+      continue
+    }
+
+    if !reflect.DeepEqual(got, tc.want) {
+      t.Errorf("ParseDeviceSpec(%q): got %#v, wanted %#v", tc.in, got, tc.want)
+    }
+
+    roundTrip, err := device.ParseDeviceSpec(got.String())
+
+    if err != nil || !reflect.DeepEqual(roundTrip, got) {
+      t.Errorf("ParseDeviceSpec(%q.String()): got %#v (err %v), wanted %#v", tc.in, roundTrip, err, got)
+    }
+  }
+}
+
+func TestParseDeviceSpec_Invalid(t *testing.T) {
+  for _, in := range []string{
+    "addr",
+    "=foo",
+    "name=a,name=b",
+    `name="unterminated`,
+    `name="quoted" trailing`,
+    `name=foo\`,
+    "na me=foo",
+  } {
+    if _, err := device.ParseDeviceSpec(in); !errors.Is(err, device.ErrInvalidSpec) {
+      t.Errorf("ParseDeviceSpec(%q): got error %v, wanted ErrInvalidSpec", in, err)
+    }
+  }
+}
+
+func TestExtractLabels(t *testing.T) {
+  spec := device.MustParseDeviceSpec("addr=49:42:08:00:12:34, label.room=kitchen, label.floor=1")
+  labels, err := spec.ExtractLabels()
+
+  if err != nil || !reflect.DeepEqual(labels, map[string]string{"room": "kitchen", "floor": "1"}) {
+    t.Errorf("got labels %v (err %v)", labels, err)
+  }
+
+  if !reflect.DeepEqual(spec, device.DeviceSpec{"addr": "49:42:08:00:12:34"}) {
+    t.Errorf("labels left in the spec: %v", spec)
+  }
+
+  for _, in := range []string{"label.=x", "label.1st=x", "label.a-b=x", "label.a.b=x"} {
+    if _, err := device.MustParseDeviceSpec(in).ExtractLabels(); !errors.Is(err, device.ErrInvalidSpec) {
+      t.Errorf("%q: got error %v, wanted ErrInvalidSpec", in, err)
+    }
+  }
+}
+
+var testSchema = device.Schema{
+  {Name: "addr", Type: device.ParamTypeHardwareAddr, Required: true},
+  {Name: "connect", Type: device.ParamTypeBool, Default: "false"},
+  {Name: "timeout", Type: device.ParamTypeDuration},
+  {Name: "mode", Type: device.ParamTypeString, AllowedValues: []string{"fast", "slow"}, Default: "fast"},
+}
+
+func TestSchemaParse(t *testing.T) {
+  params, err := testSchema.Parse(device.MustParseDeviceSpec("addr=aa:bb:cc:dd:ee:ff,connect=1,timeout=3s"))
+
+  if err != nil {
+    t.Fatalf("Parse() got error: %v", err)
+  }
+
+  if !params.Bool("connect") || !params.IsSet("connect") {
+    t.Errorf("connect: got %v (set: %v), wanted true", params.Bool("connect"), params.IsSet("connect"))
+  }
+
+  if got := params.Duration("timeout"); got != 3 * time.Second {
+    t.Errorf("timeout: got %v, wanted 3s", got)
+  }
+
+  if got := params.String("mode"); got != "fast" || params.IsSet("mode") {
+    t.Errorf("mode: got %q (set: %v), wanted default \"fast\"", got, params.IsSet("mode"))
+  }
+}
+
+func TestSchemaParse_CanonicalValue(t *testing.T) {
+  params, err := testSchema.Parse(device.MustParseDeviceSpec("addr=aa:bb:cc:dd:ee:ff,mode=SLOW"))
+
+  if err != nil {
+    t.Fatalf("Parse() got error: %v", err)
+  }
+
+  if got := params.String("mode"); got != "slow" {
+    t.Errorf("mode: got %q, wanted \"slow\"", got)
+  }
+}
+
+func TestSchemaParse_Invalid(t *testing.T) {
+  for _, in := range []string{
+    "connect=true",
+    "addr=aa:bb:cc:dd:ee:ff,conect=true",
+    "addr=aa:bb:cc:dd:ee:ff,connect=maybe",
+    "addr=aa:bb:cc:dd:ee:ff,timeout=5",
+    "addr=aa:bb:cc:dd:ee:ff,mode=medium",
+    "addr=not-a-mac",
+  } {
+    if _, err := testSchema.Parse(device.MustParseDeviceSpec(in)); !errors.Is(err, device.ErrInvalidSpec) {
+      t.Errorf("Parse(%q): got error %v, wanted ErrInvalidSpec", in, err)
+    }
+  }
+}
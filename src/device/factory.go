@@ -1,9 +1,7 @@
 package device
 
 type Factory interface {
+  // Schema describes the parameters accepted by FromSpec.
+  Schema() Schema
   FromSpec(spec DeviceSpec) (Device, error)
 }
-
-type FactoryDocs interface {
-  Help() string
-}
@@ -1,35 +1,59 @@
 package inkbird
 
 import (
-  "fmt"
-  "net"
   "strings"
 
   "github.com/robertof/go-inkbird-exporter/device"
   "github.com/rs/zerolog/log"
 )
 
+const specFieldConnect = "connect"
+
+var schema = device.Schema{
+  {
+    Name: device.DeviceSpecFieldAddress,
+    Type: device.ParamTypeHardwareAddr,
+    Required: true,
+    Description: "MAC address of this Inkbird device",
+  },
+  {
+    Name: device.DeviceSpecFieldName,
+    Type: device.ParamTypeString,
+    Description: "Name of this Inkbird device. Defaults to 'inkbird-<addr>'",
+  },
+  {
+    Name: specFieldConnect,
+    Type: device.ParamTypeBool,
+    Default: "false",
+    Description: "Connect to the device instead of scanning. Disables battery measurements. " +
+      "Increases reliability when battery is low.",
+  },
+}
+
 type Factory struct{}
 
-func (f *Factory) FromSpec(spec device.DeviceSpec) (device.Device, error) {
-  d := Device{}
+func (f *Factory) Schema() device.Schema {
+  return schema
+}
 
-  addr := spec.Addr()
+func (f *Factory) FromSpec(spec device.DeviceSpec) (device.Device, error) {
+  params, err := f.Schema().Parse(spec)
 
-  if name := spec.Name(); name != "" {
-    d.name = name
-  } else {
-    d.name = "inkbird-" + strings.ToLower(strings.ReplaceAll(addr, ":", ""))
+  if err != nil {
+    return nil, err
   }
 
-  hwAddr, err := net.ParseMAC(addr)
-  if err != nil {
-    return nil, fmt.Errorf("invalid addr: %w", err)
+  d := Device{
+    addr: params.HardwareAddr(device.DeviceSpecFieldAddress),
   }
 
-  d.addr = hwAddr
+  if name := params.String(device.DeviceSpecFieldName); name != "" {
+    d.name = name
+  } else {
+    d.name = "inkbird-" + strings.ToLower(strings.ReplaceAll(d.addr.String(), ":", ""))
+  }
 
-  if connect := spec["connect"]; connect == "yes" || connect == "true" {
+  if params.Bool(specFieldConnect) {
     log.Debug().Stringer("Device", &d).Msg("inkbird: using active backend (reading w/connection)")
     d.backend = &backendActive{}
   } else {
@@ -39,10 +63,3 @@ func (f *Factory) FromSpec(spec device.DeviceSpec) (device.Device, error) {
 
   return &d, nil
 }
-
-func (f *Factory) Help() string {
-  return `Supported parameters:
-addr (string, required): MAC address of this Inkbird device
-name (string, required): Name of this Inkbird device
-connect (bool): Connect to the device instead of scanning. Disables battery measurements. Increases reliability when battery is low.`
-}
@@ -20,7 +20,7 @@ func TestTHAdvertisement_WithHumidity(t *testing.T) {
   }
 
   factory := inkbird.Factory{}
-  dev, _ := factory.FromSpec(device.NewDeviceSpec("addr=aa:bb:cc:dd:ee:ff,name=foo"))
+  dev, _ := factory.FromSpec(device.MustParseDeviceSpec("addr=aa:bb:cc:dd:ee:ff,name=foo"))
   got, err := dev.Backend().(device.PassiveBackend).ParseAdvertisement(advertisement)
 
   if err != nil {
@@ -53,7 +53,7 @@ func TestTHAdvertisement_WithoutHumidity(t *testing.T) {
   }
 
   factory := inkbird.Factory{}
-  dev, _ := factory.FromSpec(device.NewDeviceSpec("addr=aa:bb:cc:dd:ee:ff,name=foo"))
+  dev, _ := factory.FromSpec(device.MustParseDeviceSpec("addr=aa:bb:cc:dd:ee:ff,name=foo"))
   got, err := dev.Backend().(device.PassiveBackend).ParseAdvertisement(advertisement)
 
   if err != nil {
@@ -87,7 +87,7 @@ func TestBBQAdvertisement_OneProbe(t *testing.T) {
   }
 
   factory := inkbird.Factory{}
-  dev, _ := factory.FromSpec(device.NewDeviceSpec("addr=aa:bb:cc:dd:ee:ff,name=foo"))
+  dev, _ := factory.FromSpec(device.MustParseDeviceSpec("addr=aa:bb:cc:dd:ee:ff,name=foo"))
   got, err := dev.Backend().(device.PassiveBackend).ParseAdvertisement(advertisement)
 
   if err != nil {
@@ -121,7 +121,7 @@ func TestBBQAdvertisement_TwoProbes(t *testing.T) {
   }
 
   factory := inkbird.Factory{}
-  dev, _ := factory.FromSpec(device.NewDeviceSpec("addr=aa:bb:cc:dd:ee:ff,name=foo"))
+  dev, _ := factory.FromSpec(device.MustParseDeviceSpec("addr=aa:bb:cc:dd:ee:ff,name=foo"))
   got, err := dev.Backend().(device.PassiveBackend).ParseAdvertisement(advertisement)
 
   if err != nil {
@@ -155,7 +155,7 @@ func TestBBQAdvertisement_FourProbes(t *testing.T) {
   }
 
   factory := inkbird.Factory{}
-  dev, _ := factory.FromSpec(device.NewDeviceSpec("addr=aa:bb:cc:dd:ee:ff,name=foo"))
+  dev, _ := factory.FromSpec(device.MustParseDeviceSpec("addr=aa:bb:cc:dd:ee:ff,name=foo"))
   got, err := dev.Backend().(device.PassiveBackend).ParseAdvertisement(advertisement)
 
   if err != nil {
@@ -189,7 +189,7 @@ func TestBBQAdvertisement_SixProbes(t *testing.T) {
   }
 
   factory := inkbird.Factory{}
-  dev, _ := factory.FromSpec(device.NewDeviceSpec("addr=aa:bb:cc:dd:ee:ff,name=foo"))
+  dev, _ := factory.FromSpec(device.MustParseDeviceSpec("addr=aa:bb:cc:dd:ee:ff,name=foo"))
   got, err := dev.Backend().(device.PassiveBackend).ParseAdvertisement(advertisement)
 
   if err != nil {
@@ -32,9 +32,12 @@ type Reading struct {
   Temperatures []float32
   BatteryLevel uint8
   ProbeType
+  // Signal strength of the device as seen by the adapter, in dBm. Filled in by the collector.
+  RSSI int
 
   HasBatteryLevel bool
   HasHumidity bool
+  HasRSSI bool
 }
 
 func (r Reading) String() string {
@@ -48,6 +51,10 @@ func (r Reading) String() string {
     fields = append(fields, fmt.Sprintf("Battery=%d%%", r.BatteryLevel))
   }
 
+  if r.HasRSSI {
+    fields = append(fields, fmt.Sprintf("RSSI=%ddBm", r.RSSI))
+  }
+
   return fmt.Sprintf("Reading[Temperatures=%v,ProbeType=%v,%v]",
     r.Temperatures, r.ProbeType, strings.Join(fields, ","))
 }
@@ -0,0 +1,319 @@
+package device
+
+import (
+  "errors"
+  "fmt"
+  "net"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// ParamType is the type of a spec parameter.
+type ParamType uint8
+
+const (
+  ParamTypeString ParamType = iota
+  ParamTypeBool
+  ParamTypeInt
+  ParamTypeFloat
+  ParamTypeDuration
+  ParamTypeHardwareAddr
+)
+
+func (t ParamType) String() string {
+  switch t {
+  case ParamTypeString:
+    return "string"
+  case ParamTypeBool:
+    return "bool"
+  case ParamTypeInt:
+    return "int"
+  case ParamTypeFloat:
+    return "float"
+  case ParamTypeDuration:
+    return "duration"
+  case ParamTypeHardwareAddr:
+    return "MAC address"
+  default:
+    panic("unknown param type: " + strconv.Itoa(int(t)))
+  }
+}
+
+func (t ParamType) parse(v string) (any, error) {
+  switch t {
+  case ParamTypeString:
+    return v, nil
+  case ParamTypeBool:
+    switch strings.ToLower(v) {
+    case "1", "t", "true", "y", "yes", "on":
+      return true, nil
+    case "0", "f", "false", "n", "no", "off":
+      return false, nil
+    }
+
+    return nil, fmt.Errorf("invalid bool %q (must be one of true, false, yes, no, on, off, 1, 0)", v)
+  case ParamTypeInt:
+    return strconv.Atoi(v)
+  case ParamTypeFloat:
+    return strconv.ParseFloat(v, 64)
+  case ParamTypeDuration:
+    return time.ParseDuration(v)
+  case ParamTypeHardwareAddr:
+    return net.ParseMAC(v)
+  default:
+    panic("unknown param type: " + strconv.Itoa(int(t)))
+  }
+}
+
+// Param describes a single parameter accepted in a spec.
+type Param struct {
+  Name string
+  Type ParamType
+  Required bool
+  // Default value in textual form, parsed like user-provided values. Empty means no default.
+  Default string
+  // If not empty, the value must be one of these.
+  AllowedValues []string
+  Description string
+}
+
+func (p Param) summary() string {
+  attrs := []string{p.Type.String()}
+
+  if p.Required {
+    attrs = append(attrs, "required")
+  }
+
+  if len(p.AllowedValues) > 0 {
+    attrs = append(attrs, "one of: " + strings.Join(p.AllowedValues, "|"))
+  }
+
+  if p.Default != "" {
+    attrs = append(attrs, "default: " + p.Default)
+  }
+
+  return strings.Join(attrs, ", ")
+}
+
+// Schema is the list of parameters accepted by a spec.
+type Schema []Param
+
+// Lookup returns the parameter with the given name, if any.
+func (s Schema) Lookup(name string) (Param, bool) {
+  for _, p := range s {
+    if p.Name == name {
+      return p, true
+    }
+  }
+
+  return Param{}, false
+}
+
+func (s Schema) names() []string {
+  names := make([]string, len(s))
+
+  for i, p := range s {
+    names[i] = p.Name
+  }
+
+  return names
+}
+
+// Parse validates the spec against the schema and returns the typed parameters. Unknown keys,
+// missing required parameters, values of the wrong type and values not in AllowedValues are all
+// errors.
+func (s Schema) Parse(spec DeviceSpec) (Params, error) {
+  var errs []error
+
+  params := Params{
+    schema: s,
+    values: make(map[string]any, len(s)),
+    set: make(map[string]bool, len(spec)),
+  }
+
+  keys := make([]string, 0, len(spec))
+
+  for k := range spec {
+    keys = append(keys, k)
+  }
+
+  sort.Strings(keys)
+
+  for _, k := range keys {
+    if _, ok := s.Lookup(k); !ok {
+      errs = append(errs, fmt.Errorf("%w: unknown parameter %q (supported: %s)",
+        ErrInvalidSpec, k, strings.Join(s.names(), ", ")))
+    }
+  }
+
+  for _, p := range s {
+    raw, ok := spec[p.Name]
+
+    if !ok {
+      if p.Required {
+        errs = append(errs, fmt.Errorf("%w: missing required parameter %q", ErrInvalidSpec, p.Name))
+        continue
+      }
+
+      raw = p.Default
+    }
+
+    if len(p.AllowedValues) > 0 && (ok || raw != "") {
+      // allowed values are case insensitive: store the canonical one.
+      canonical, found := lookupFold(p.AllowedValues, raw)
+
+      if !found {
+        errs = append(errs, fmt.Errorf("%w: parameter %q: invalid value %q (must be one of %s)",
+          ErrInvalidSpec, p.Name, raw, strings.Join(p.AllowedValues, ", ")))
+        continue
+      }
+
+      raw = canonical
+    }
+
+    if !ok && raw == "" {
+      continue
+    }
+
+    v, err := p.Type.parse(raw)
+
+    if err != nil {
+      errs = append(errs, fmt.Errorf("%w: parameter %q: %v", ErrInvalidSpec, p.Name, err))
+      continue
+    }
+
+    params.values[p.Name] = v
+    params.set[p.Name] = ok
+  }
+
+  if len(errs) > 0 {
+    return Params{}, errors.Join(errs...)
+  }
+
+  return params, nil
+}
+
+func lookupFold(values []string, v string) (string, bool) {
+  for _, candidate := range values {
+    if strings.EqualFold(candidate, v) {
+      return candidate, true
+    }
+  }
+
+  return "", false
+}
+
+// Help returns a plain text description of the schema, suitable for command line usage.
+func (s Schema) Help() string {
+  lines := []string{"Supported parameters:"}
+
+  for _, p := range s {
+    lines = append(lines, fmt.Sprintf("%s (%s): %s", p.Name, p.summary(), p.Description))
+  }
+
+  return strings.Join(lines, "\n")
+}
+
+// Markdown returns a reference table of the schema in Markdown.
+func (s Schema) Markdown() string {
+  var b strings.Builder
+
+  b.WriteString("| Parameter | Type | Required | Default | Description |\n")
+  b.WriteString("|---|---|---|---|---|\n")
+
+  for _, p := range s {
+    typ := p.Type.String()
+
+    if len(p.AllowedValues) > 0 {
+      typ += " (`" + strings.Join(p.AllowedValues, "`, `") + "`)"
+    }
+
+    required := "no"
+    if p.Required {
+      required = "yes"
+    }
+
+    def := ""
+    if p.Default != "" {
+      def = "`" + p.Default + "`"
+    }
+
+    fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s |\n",
+      p.Name, typ, required, def, strings.ReplaceAll(p.Description, "|", `\|`))
+  }
+
+  return b.String()
+}
+
+// Params holds the typed parameters of a spec validated by a Schema. Getters panic when asked
+// for a parameter that is not part of the schema or has a different type, as that is always a
+// programming error.
+type Params struct {
+  schema Schema
+  values map[string]any
+  set map[string]bool
+}
+
+// IsSet returns whether the parameter was explicitly provided in the spec.
+func (p Params) IsSet(name string) bool {
+  p.lookup(name, nil)
+
+  return p.set[name]
+}
+
+// Has returns whether the parameter has a value, either explicitly provided or from defaults.
+func (p Params) Has(name string) bool {
+  p.lookup(name, nil)
+
+  _, ok := p.values[name]
+
+  return ok
+}
+
+func (p Params) lookup(name string, want *ParamType) any {
+  param, ok := p.schema.Lookup(name)
+
+  if !ok {
+    panic(fmt.Sprintf("parameter %q is not part of the schema", name))
+  }
+
+  if want != nil && param.Type != *want {
+    panic(fmt.Sprintf("parameter %q has type %v, not %v", name, param.Type, *want))
+  }
+
+  return p.values[name]
+}
+
+func getParam[T any](p Params, name string, t ParamType) (ret T) {
+  if v := p.lookup(name, &t); v != nil {
+    ret = v.(T)
+  }
+
+  return ret
+}
+
+func (p Params) String(name string) string {
+  return getParam[string](p, name, ParamTypeString)
+}
+
+func (p Params) Bool(name string) bool {
+  return getParam[bool](p, name, ParamTypeBool)
+}
+
+func (p Params) Int(name string) int {
+  return getParam[int](p, name, ParamTypeInt)
+}
+
+func (p Params) Float(name string) float64 {
+  return getParam[float64](p, name, ParamTypeFloat)
+}
+
+func (p Params) Duration(name string) time.Duration {
+  return getParam[time.Duration](p, name, ParamTypeDuration)
+}
+
+func (p Params) HardwareAddr(name string) net.HardwareAddr {
+  return getParam[net.HardwareAddr](p, name, ParamTypeHardwareAddr)
+}
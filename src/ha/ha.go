@@ -0,0 +1,134 @@
+// Package ha elects a leader between two exporter instances sharing the same sensors, so that only
+// one of them holds connections to the devices.
+package ha
+
+import (
+  "context"
+  "fmt"
+  "os"
+  "slices"
+  "time"
+
+  "github.com/prometheus/client_golang/prometheus"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  DefaultHeartbeatInterval = 5 * time.Second
+  DefaultLeaseTimeout = 30 * time.Second
+)
+
+var roleGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+  Name: "inkbird_exporter_ha_role",
+  Help: "Current high-availability role of this instance (1 for the active role).",
+}, []string{"role"})
+
+func RegisterMetrics(reg prometheus.Registerer) {
+  reg.MustRegister(roleGauge)
+}
+
+// Role of an instance in a high-availability pair.
+type Role uint8
+
+const (
+  RoleFollower Role = iota
+  RoleLeader
+)
+
+func (r Role) String() string {
+  switch r {
+  case RoleFollower:
+    return "follower"
+  case RoleLeader:
+    return "leader"
+  default:
+    panic(fmt.Sprintf("unknown role: %d", r))
+  }
+}
+
+// FollowerMode decides what followers collect.
+type FollowerMode string
+
+const (
+  // Followers keep collecting devices read through scans, but don't connect to any device.
+  FollowerPassive FollowerMode = "passive"
+  // Followers don't collect anything.
+  FollowerIdle FollowerMode = "idle"
+)
+
+var allFollowerModes = []FollowerMode{FollowerPassive, FollowerIdle}
+
+// *flag.Value
+func (m *FollowerMode) String() string {
+  return string(*m)
+}
+
+func (m *FollowerMode) Set(v string) error {
+  mode := FollowerMode(v)
+
+  if !slices.Contains(allFollowerModes, mode) {
+    return fmt.Errorf("unknown follower mode %v (must be one of %v)", mode, allFollowerModes)
+  }
+
+  *m = mode
+  return nil
+}
+
+// Elector campaigns for leadership on behalf of this instance.
+type Elector interface {
+  // Run the election until ctx is done. Instances start as followers: onChange is called on every
+  // role change, from the goroutine calling Run.
+  Run(ctx context.Context, onChange func(Role))
+}
+
+// Options shared by every elector.
+type Options struct {
+  // Identifies this instance. Must differ between the instances of a pair.
+  ID string
+  // How often the leader proves it is alive, and followers check on it.
+  HeartbeatInterval time.Duration
+  // How long without heartbeats before a follower takes over.
+  LeaseTimeout time.Duration
+}
+
+// DefaultID identifies the instance by its hostname.
+func DefaultID() string {
+  host, err := os.Hostname()
+
+  if err != nil {
+    return fmt.Sprintf("pid-%d", os.Getpid())
+  }
+
+  return host
+}
+
+// Tracks the role of an instance, logging and exporting changes.
+type roleTracker struct {
+  role Role
+  onChange func(Role)
+}
+
+func newRoleTracker(onChange func(Role)) *roleTracker {
+  roleGauge.WithLabelValues(RoleLeader.String()).Set(0)
+  roleGauge.WithLabelValues(RoleFollower.String()).Set(1)
+
+  return &roleTracker{role: RoleFollower, onChange: onChange}
+}
+
+func (t *roleTracker) set(role Role, reason string) {
+  if role == t.role {
+    return
+  }
+
+  log.Warn().
+    Stringer("PreviousRole", t.role).
+    Stringer("Role", role).
+    Str("Reason", reason).
+    Msg("ha: role changed")
+
+  roleGauge.WithLabelValues(t.role.String()).Set(0)
+  roleGauge.WithLabelValues(role.String()).Set(1)
+
+  t.role = role
+  t.onChange(role)
+}
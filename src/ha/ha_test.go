@@ -0,0 +1,107 @@
+package ha_test
+
+import (
+  "context"
+  "net/http"
+  "net/http/httptest"
+  "path/filepath"
+  "testing"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/ha"
+)
+
+func options(id string) ha.Options {
+  return ha.Options{
+    ID: id,
+    HeartbeatInterval: 10 * time.Millisecond,
+    LeaseTimeout: 50 * time.Millisecond,
+  }
+}
+
+// Run the elector in the background until stopped, returning its role changes.
+func run(t *testing.T, e ha.Elector) (roles <-chan ha.Role, stop func()) {
+  ctx, cancel := context.WithCancel(context.Background())
+  ch := make(chan ha.Role, 16)
+  done := make(chan struct{})
+
+  go func() {
+    defer close(done)
+    e.Run(ctx, func(r ha.Role) { ch <- r })
+  }()
+
+  // wait for the elector to stop before removing its lock file.
+  t.Cleanup(func() {
+    cancel()
+    <-done
+  })
+
+  return ch, cancel
+}
+
+func expectRole(t *testing.T, name string, roles <-chan ha.Role, want ha.Role) {
+  t.Helper()
+
+  select {
+  case got := <-roles:
+    if got != want {
+      t.Fatalf("%s: got role %v, wanted %v", name, got, want)
+    }
+  case <-time.After(time.Second):
+    t.Fatalf("%s: timed out waiting for role %v", name, want)
+  }
+}
+
+func expectNoChange(t *testing.T, name string, roles <-chan ha.Role) {
+  t.Helper()
+
+  select {
+  case got := <-roles:
+    t.Fatalf("%s: unexpected change to role %v", name, got)
+  case <-time.After(150 * time.Millisecond):
+  }
+}
+
+func TestLockFileElector(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "leader.lock")
+
+  rolesA, stopA := run(t, ha.NewLockFileElector(path, options("a")))
+  expectRole(t, "a", rolesA, ha.RoleLeader)
+
+  rolesB, _ := run(t, ha.NewLockFileElector(path, options("b")))
+  expectNoChange(t, "b", rolesB)
+
+  // the leader releases the lease when stopping.
+  stopA()
+  expectRole(t, "b", rolesB, ha.RoleLeader)
+}
+
+func TestPeerElector(t *testing.T) {
+  var electorA, electorB *ha.PeerElector
+
+  serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    electorA.ServeHTTP(w, r)
+  }))
+  defer serverA.Close()
+
+  serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    electorB.ServeHTTP(w, r)
+  }))
+  defer serverB.Close()
+
+  electorA = ha.NewPeerElector(serverB.URL, options("a"))
+  electorB = ha.NewPeerElector(serverA.URL, options("b"))
+
+  rolesA, stopA := run(t, electorA)
+  rolesB, _ := run(t, electorB)
+
+  // the lowest ID leads while both are up.
+  expectRole(t, "a", rolesA, ha.RoleLeader)
+  expectNoChange(t, "b", rolesB)
+
+  // the follower takes over once the leader stops answering heartbeats.
+  stopA()
+  serverA.Close()
+
+  expectRole(t, "b", rolesB, ha.RoleLeader)
+}
@@ -0,0 +1,154 @@
+package ha
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "io/fs"
+  "os"
+  "path/filepath"
+  "time"
+
+  "github.com/rs/zerolog/log"
+)
+
+// The content of the lock file. The holder bumps Seq at every heartbeat: a lease is considered
+// expired once Seq stops changing for LeaseTimeout, as measured by the local clock of the reader,
+// so the clocks of the instances don't need to agree.
+type lease struct {
+  // Empty when the lease has been released.
+  Holder string `json:"holder"`
+  Seq uint64 `json:"seq"`
+}
+
+// LockFileElector elects the leader through a lease stored in a file shared by both instances,
+// e.g. on a network share.
+type LockFileElector struct {
+  path string
+  opts Options
+}
+
+func NewLockFileElector(path string, opts Options) *LockFileElector {
+  return &LockFileElector{path: path, opts: opts}
+}
+
+func (e *LockFileElector) Run(ctx context.Context, onChange func(Role)) {
+  tracker := newRoleTracker(onChange)
+
+  var (
+    seen lease
+    seenAt = time.Now()
+    // the lease has been claimed, pending confirmation at the next heartbeat.
+    claimed bool
+    renewedAt time.Time
+    // last sequence number written by this instance.
+    written uint64
+  )
+
+  ticker := time.NewTicker(e.opts.HeartbeatInterval)
+  defer ticker.Stop()
+
+  for {
+    now := time.Now()
+    cur, err := e.read()
+
+    switch {
+    case err != nil:
+      log.Error().Err(err).Str("Path", e.path).Msg("ha: failed to read lock file")
+    case cur.Holder == e.opts.ID:
+      if err := e.write(lease{Holder: e.opts.ID, Seq: cur.Seq + 1}); err != nil {
+        log.Error().Err(err).Str("Path", e.path).Msg("ha: failed to renew lease")
+        break
+      }
+
+      claimed, renewedAt, written = false, now, cur.Seq + 1
+      tracker.set(RoleLeader, "lease acquired")
+    case cur.Holder == "" || (cur == seen && now.Sub(seenAt) >= e.opts.LeaseTimeout):
+      if cur.Holder != "" {
+        log.Warn().Str("Holder", cur.Holder).Msg("ha: lease expired, taking over")
+      }
+
+      if err := e.write(lease{Holder: e.opts.ID, Seq: cur.Seq + 1}); err != nil {
+        log.Error().Err(err).Str("Path", e.path).Msg("ha: failed to claim lease")
+        break
+      }
+
+      claimed, written = true, cur.Seq + 1
+    default:
+      claimed = false
+      tracker.set(RoleFollower, "lease held by " + cur.Holder)
+    }
+
+    if err == nil && cur != seen {
+      seen, seenAt = cur, now
+    }
+
+    // the follower takes over once our heartbeats stop: don't keep leading without them.
+    if tracker.role == RoleLeader && now.Sub(renewedAt) >= e.opts.LeaseTimeout {
+      tracker.set(RoleFollower, "failed to renew lease")
+    }
+
+    log.Trace().
+      Str("Holder", cur.Holder).
+      Uint64("Seq", cur.Seq).
+      Bool("Claimed", claimed).
+      Stringer("Role", tracker.role).
+      Msg("ha: heartbeat")
+
+    select {
+    case <-ctx.Done():
+      if tracker.role == RoleLeader {
+        // let the follower take over right away.
+        if err := e.write(lease{Seq: written + 1}); err != nil {
+          log.Error().Err(err).Str("Path", e.path).Msg("ha: failed to release lease")
+        }
+      }
+
+      return
+    case <-ticker.C:
+    }
+  }
+}
+
+// A missing lock file is a released lease.
+func (e *LockFileElector) read() (l lease, err error) {
+  data, err := os.ReadFile(e.path)
+
+  if errors.Is(err, fs.ErrNotExist) {
+    return l, nil
+  } else if err != nil {
+    return l, err
+  }
+
+  err = json.Unmarshal(data, &l)
+
+  return l, err
+}
+
+// Replace the lock file atomically.
+func (e *LockFileElector) write(l lease) error {
+  data, err := json.Marshal(l)
+
+  if err != nil {
+    return err
+  }
+
+  tmp, err := os.CreateTemp(filepath.Dir(e.path), filepath.Base(e.path) + ".*.tmp")
+
+  if err != nil {
+    return err
+  }
+
+  defer os.Remove(tmp.Name())
+
+  if _, err := tmp.Write(data); err != nil {
+    tmp.Close()
+    return err
+  }
+
+  if err := tmp.Close(); err != nil {
+    return err
+  }
+
+  return os.Rename(tmp.Name(), e.path)
+}
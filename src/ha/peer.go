@@ -0,0 +1,127 @@
+package ha
+
+import (
+  "context"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "strings"
+  "sync/atomic"
+  "time"
+
+  "github.com/rs/zerolog/log"
+)
+
+// Where instances answer the heartbeats of their peer.
+const HeartbeatPath = "/ha/heartbeat"
+
+type heartbeat struct {
+  ID string `json:"id"`
+  Leader bool `json:"leader"`
+}
+
+// PeerElector elects the leader by polling the heartbeat endpoint of the other instance. The
+// instance with the lowest ID leads while both are up, and either takes over once the other has
+// not answered for LeaseTimeout.
+type PeerElector struct {
+  peerURL string
+  opts Options
+  client *http.Client
+  leader atomic.Bool
+}
+
+// NewPeerElector polls the instance at peerURL (e.g. "http://other-pi:9102"). The returned
+// elector must also be served on HeartbeatPath for the peer to poll.
+func NewPeerElector(peerURL string, opts Options) *PeerElector {
+  return &PeerElector{
+    peerURL: strings.TrimSuffix(peerURL, "/"),
+    opts: opts,
+    client: &http.Client{Timeout: opts.HeartbeatInterval},
+  }
+}
+
+// *http.Handler, answering the heartbeats of the peer.
+func (e *PeerElector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  json.NewEncoder(w).Encode(heartbeat{
+    ID: e.opts.ID,
+    Leader: e.leader.Load(),
+  })
+}
+
+func (e *PeerElector) Run(ctx context.Context, onChange func(Role)) {
+  tracker := newRoleTracker(func(role Role) {
+    e.leader.Store(role == RoleLeader)
+    onChange(role)
+  })
+
+  // give the peer a full lease to show up before taking over.
+  heardAt := time.Now()
+
+  ticker := time.NewTicker(e.opts.HeartbeatInterval)
+  defer ticker.Stop()
+
+  for {
+    now := time.Now()
+    peer, err := e.poll(ctx)
+
+    switch {
+    case err != nil:
+      log.Debug().Err(err).Msg("ha: peer did not answer heartbeat")
+
+      if now.Sub(heardAt) >= e.opts.LeaseTimeout {
+        tracker.set(RoleLeader, "peer unreachable")
+      }
+    case peer.ID == e.opts.ID:
+      heardAt = now
+      log.Error().Str("ID", peer.ID).Msg("ha: peer has the same ID as this instance, not electing")
+    case peer.Leader && tracker.role == RoleLeader:
+      heardAt = now
+
+      // both lead, e.g. after a network partition: the lowest ID wins.
+      if peer.ID < e.opts.ID {
+        tracker.set(RoleFollower, "peer is also leading and wins the tie-break")
+      }
+    case peer.Leader:
+      heardAt = now
+      tracker.set(RoleFollower, "peer is leading")
+    default:
+      heardAt = now
+
+      if tracker.role != RoleLeader && e.opts.ID < peer.ID {
+        tracker.set(RoleLeader, "no leader, this instance wins the tie-break")
+      }
+    }
+
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+    }
+  }
+}
+
+func (e *PeerElector) poll(ctx context.Context) (hb heartbeat, err error) {
+  req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.peerURL + HeartbeatPath, nil)
+
+  if err != nil {
+    return hb, err
+  }
+
+  resp, err := e.client.Do(req)
+
+  if err != nil {
+    return hb, err
+  }
+
+  defer resp.Body.Close()
+
+  if resp.StatusCode != http.StatusOK {
+    return hb, fmt.Errorf("unexpected status %v", resp.Status)
+  }
+
+  err = json.NewDecoder(resp.Body).Decode(&hb)
+
+  return hb, err
+}
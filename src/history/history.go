@@ -0,0 +1,327 @@
+// Package history keeps the readings in an embedded SQLite database, downsampling old readings to
+// hourly aggregates, so that past readings can be queried without a Prometheus server.
+package history
+
+import (
+  "context"
+  "database/sql"
+  "fmt"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/rs/zerolog/log"
+  _ "modernc.org/sqlite"
+)
+
+const (
+  DefaultRetention = 7 * 24 * time.Hour
+
+  MetricTemperature = "temperature"
+  MetricHumidity = "humidity"
+  MetricBattery = "battery"
+  MetricRSSI = "rssi"
+
+  // How often old readings are downsampled and expired.
+  compactInterval = time.Hour
+  hourMillis = int64(time.Hour / time.Millisecond)
+)
+
+// Resolution of the points returned by queries.
+type Resolution string
+
+const (
+  // Every reading, as long as it's within the retention.
+  ResolutionRaw Resolution = "raw"
+  // Hourly min/max/avg over the whole history.
+  ResolutionHour Resolution = "hour"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS samples (
+  device TEXT NOT NULL,
+  metric TEXT NOT NULL,
+  probe INTEGER NOT NULL,
+  time INTEGER NOT NULL, -- milliseconds since the epoch
+  value REAL NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS samples_device_time ON samples (device, time);
+CREATE INDEX IF NOT EXISTS samples_time ON samples (time);
+
+CREATE TABLE IF NOT EXISTS hourly (
+  device TEXT NOT NULL,
+  metric TEXT NOT NULL,
+  probe INTEGER NOT NULL,
+  hour INTEGER NOT NULL, -- start of the hour, in milliseconds since the epoch
+  min REAL NOT NULL,
+  max REAL NOT NULL,
+  sum REAL NOT NULL,
+  count INTEGER NOT NULL,
+  PRIMARY KEY (device, metric, probe, hour)
+);
+`
+
+// Options of the history store.
+type Options struct {
+  // Readings older than this are downsampled to hourly aggregates.
+  Retention time.Duration
+  // Hourly aggregates older than this are deleted, if positive.
+  DownsampledRetention time.Duration
+}
+
+// Store is a collector.Sink recording every reading, one sample per value: temperatures (one per
+// probe), humidity and battery in percent, RSSI in dBm.
+type Store struct {
+  db *sql.DB
+  opts Options
+}
+
+// Open the database at path, creating it if needed.
+func Open(path string, opts Options) (*Store, error) {
+  db, err := sql.Open("sqlite", "file:" + path + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+
+  if err != nil {
+    return nil, err
+  }
+
+  // SQLite only allows a single writer: serialize everything rather than retrying on SQLITE_BUSY.
+  db.SetMaxOpenConns(1)
+
+  if _, err := db.Exec(schema); err != nil {
+    db.Close()
+    return nil, fmt.Errorf("failed to initialize %v: %w", path, err)
+  }
+
+  return &Store{db: db, opts: opts}, nil
+}
+
+func (s *Store) Close() error {
+  return s.db.Close()
+}
+
+// Record readings. Old readings are compacted separately, see Run().
+func (s *Store) Deliver(ctx context.Context, readings []collector.SinkReading) error {
+  tx, err := s.db.BeginTx(ctx, nil)
+
+  if err != nil {
+    return err
+  }
+
+  defer tx.Rollback()
+
+  stmt, err := tx.PrepareContext(ctx, "INSERT INTO samples (device, metric, probe, time, value) VALUES (?, ?, ?, ?, ?)")
+
+  if err != nil {
+    return err
+  }
+
+  defer stmt.Close()
+
+  for _, r := range readings {
+    name, t := r.Device.Name(), r.Time.UnixMilli()
+
+    insert := func(metric string, probe int, value float64) error {
+      _, err := stmt.ExecContext(ctx, name, metric, probe, t, value)
+      return err
+    }
+
+    for probe, temp := range r.Temperatures {
+      if err := insert(MetricTemperature, probe, float64(temp)); err != nil {
+        return err
+      }
+    }
+
+    if r.HasHumidity {
+      if err := insert(MetricHumidity, 0, float64(r.RelativeHumidity)); err != nil {
+        return err
+      }
+    }
+
+    if r.HasBatteryLevel {
+      if err := insert(MetricBattery, 0, float64(r.BatteryLevel)); err != nil {
+        return err
+      }
+    }
+
+    if r.HasRSSI {
+      if err := insert(MetricRSSI, 0, float64(r.RSSI)); err != nil {
+        return err
+      }
+    }
+  }
+
+  return tx.Commit()
+}
+
+// Run compacts the store right away, then every compactInterval, until ctx is done.
+func (s *Store) Run(ctx context.Context) {
+  ticker := time.NewTicker(compactInterval)
+  defer ticker.Stop()
+
+  for {
+    if err := s.Compact(ctx, time.Now()); err != nil && ctx.Err() == nil {
+      log.Warn().Err(err).Msg("history: failed to compact the store")
+    }
+
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+    }
+  }
+}
+
+// Compact downsamples the readings of the hours entirely older than the retention, and deletes
+// the aggregates older than the downsampled retention.
+func (s *Store) Compact(ctx context.Context, now time.Time) error {
+  cutoff := now.Add(-s.opts.Retention).Truncate(time.Hour).UnixMilli()
+
+  tx, err := s.db.BeginTx(ctx, nil)
+
+  if err != nil {
+    return err
+  }
+
+  defer tx.Rollback()
+
+  // hours are aligned on the cutoff, but aggregates may still be merged with previous ones if the
+  // retention was shortened.
+  _, err = tx.ExecContext(ctx, `
+    INSERT INTO hourly (device, metric, probe, hour, min, max, sum, count)
+    SELECT device, metric, probe, time - time % ?, MIN(value), MAX(value), SUM(value), COUNT(*)
+    FROM samples WHERE time < ? GROUP BY 1, 2, 3, 4
+    ON CONFLICT (device, metric, probe, hour) DO UPDATE SET
+      min = MIN(min, excluded.min),
+      max = MAX(max, excluded.max),
+      sum = sum + excluded.sum,
+      count = count + excluded.count`,
+    hourMillis, cutoff,
+  )
+
+  if err != nil {
+    return fmt.Errorf("failed to downsample: %w", err)
+  }
+
+  res, err := tx.ExecContext(ctx, "DELETE FROM samples WHERE time < ?", cutoff)
+
+  if err != nil {
+    return err
+  }
+
+  downsampled, _ := res.RowsAffected()
+  var expired int64
+
+  if s.opts.DownsampledRetention > 0 {
+    res, err := tx.ExecContext(ctx, "DELETE FROM hourly WHERE hour < ?", now.Add(-s.opts.DownsampledRetention).UnixMilli())
+
+    if err != nil {
+      return err
+    }
+
+    expired, _ = res.RowsAffected()
+  }
+
+  if err := tx.Commit(); err != nil {
+    return err
+  }
+
+  log.Debug().
+    Int64("Downsampled", downsampled).
+    Int64("ExpiredAggregates", expired).
+    Msg("history: compacted the store")
+
+  return nil
+}
+
+// Point is a value of a series. Hourly points also carry the min, max and count of the hour, and
+// their value is the average.
+type Point struct {
+  Time time.Time `json:"time"`
+  Value float64 `json:"value"`
+  Min *float64 `json:"min,omitempty"`
+  Max *float64 `json:"max,omitempty"`
+  Count int `json:"count,omitempty"`
+}
+
+// Series of a metric of a device. Probe is only set for temperatures.
+type Series struct {
+  Metric string `json:"metric"`
+  Probe *int `json:"probe,omitempty"`
+  Points []Point `json:"points"`
+}
+
+// Query the points of every metric of a device between from (inclusive) and to (exclusive), in
+// chronological order. Hourly points include the readings not downsampled yet.
+func (s *Store) Query(ctx context.Context, device string, from, to time.Time, res Resolution) ([]Series, error) {
+  var rows *sql.Rows
+  var err error
+
+  switch res {
+  case ResolutionRaw:
+    rows, err = s.db.QueryContext(ctx, `
+      SELECT metric, probe, time, value, value, value, 1 FROM samples
+      WHERE device = ? AND time >= ? AND time < ?
+      ORDER BY metric, probe, time`,
+      device, from.UnixMilli(), to.UnixMilli(),
+    )
+  case ResolutionHour:
+    // hours partially in range are included entirely.
+    fromHour := from.UnixMilli() - from.UnixMilli() % hourMillis
+
+    rows, err = s.db.QueryContext(ctx, `
+      SELECT metric, probe, hour, min, max, sum / count, count FROM hourly
+      WHERE device = ? AND hour >= ? AND hour < ?
+      UNION ALL
+      SELECT metric, probe, time - time % ?, MIN(value), MAX(value), AVG(value), COUNT(*) FROM samples
+      WHERE device = ? AND time >= ? AND time < ?
+      GROUP BY 1, 2, 3
+      ORDER BY 1, 2, 3`,
+      device, fromHour, to.UnixMilli(),
+      hourMillis, device, fromHour, to.UnixMilli(),
+    )
+  default:
+    return nil, fmt.Errorf("unknown resolution %q", res)
+  }
+
+  if err != nil {
+    return nil, err
+  }
+
+  defer rows.Close()
+
+  out := []Series{}
+
+  for rows.Next() {
+    var metric string
+    var probe, t int64
+    var p Point
+    var min, max float64
+
+    if err := rows.Scan(&metric, &probe, &t, &min, &max, &p.Value, &p.Count); err != nil {
+      return nil, err
+    }
+
+    p.Time = time.UnixMilli(t).UTC()
+
+    if res == ResolutionHour {
+      p.Min, p.Max = &min, &max
+    } else {
+      p.Count = 0
+    }
+
+    if n := len(out); n == 0 || out[n - 1].Metric != metric || (out[n - 1].Probe != nil && *out[n - 1].Probe != int(probe)) {
+      series := Series{Metric: metric}
+
+      if metric == MetricTemperature {
+        probe := int(probe)
+        series.Probe = &probe
+      }
+
+      out = append(out, series)
+    }
+
+    out[len(out) - 1].Points = append(out[len(out) - 1].Points, p)
+  }
+
+  return out, rows.Err()
+}
@@ -0,0 +1,94 @@
+package history
+
+import (
+  "context"
+  "path/filepath"
+  "testing"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/internal/testutil"
+)
+
+func reading(name string, at time.Time, temp float32, humidity float32) collector.SinkReading {
+  return collector.SinkReading{
+    Device: testutil.FakeDevice(name),
+    Sample: model.Sample{
+      Reading: device.Reading{Temperatures: []float32{temp}, RelativeHumidity: humidity, HasHumidity: true},
+      Time: at,
+    },
+  }
+}
+
+func TestStore(t *testing.T) {
+  ctx := context.Background()
+  store, err := Open(filepath.Join(t.TempDir(), "history.db"), Options{Retention: 24 * time.Hour, DownsampledRetention: 72 * time.Hour})
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  defer store.Close()
+
+  now := time.Date(2024, 3, 10, 12, 30, 0, 0, time.UTC)
+  old := now.Add(-48 * time.Hour).Truncate(time.Hour)
+
+  err = store.Deliver(ctx, []collector.SinkReading{
+    reading("freezer", old.Add(10 * time.Minute), -20, 50),
+    reading("freezer", old.Add(40 * time.Minute), -16, 60),
+    reading("freezer", now.Add(-100 * time.Hour), -30, 50),
+    reading("freezer", now.Add(-time.Hour), -18, 55),
+    reading("fridge", now.Add(-time.Hour), 4, 40),
+  })
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if err := store.Compact(ctx, now); err != nil {
+    t.Fatal(err)
+  }
+
+  // the old readings are only available as aggregates.
+  raw, err := store.Query(ctx, "freezer", now.Add(-200 * time.Hour), now, ResolutionRaw)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if len(raw) != 2 || raw[0].Metric != MetricHumidity || raw[1].Metric != MetricTemperature || *raw[1].Probe != 0 {
+    t.Fatalf("unexpected raw series %+v", raw)
+  }
+
+  if points := raw[1].Points; len(points) != 1 || points[0].Value != -18 || points[0].Min != nil {
+    t.Fatalf("unexpected raw temperatures %+v", points)
+  }
+
+  hourly, err := store.Query(ctx, "freezer", now.Add(-200 * time.Hour), now, ResolutionHour)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  // the reading older than the downsampled retention is gone, the recent one is aggregated on
+  // the fly.
+  temps := hourly[1].Points
+
+  if len(temps) != 2 {
+    t.Fatalf("unexpected hourly temperatures %+v", temps)
+  }
+
+  if p := temps[0]; !p.Time.Equal(old) || p.Value != -18 || *p.Min != -20 || *p.Max != -16 || p.Count != 2 {
+    t.Errorf("unexpected downsampled hour %+v", p)
+  }
+
+  if p := temps[1]; !p.Time.Equal(now.Add(-time.Hour).Truncate(time.Hour)) || p.Value != -18 || p.Count != 1 {
+    t.Errorf("unexpected recent hour %+v", p)
+  }
+
+  if other, _ := store.Query(ctx, "fridge", now.Add(-2 * time.Hour), now, ResolutionRaw); len(other) != 2 || other[1].Points[0].Value != 4 {
+    t.Errorf("unexpected series of another device %+v", other)
+  }
+}
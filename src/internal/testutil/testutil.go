@@ -0,0 +1,47 @@
+// Package testutil provides fixtures for the tests of other packages.
+package testutil
+
+import (
+  "net"
+  "testing"
+
+  "github.com/robertof/go-inkbird-exporter/device"
+  "google.golang.org/protobuf/encoding/protowire"
+)
+
+// FakeDevice is a device without a backend, named after its value. Every fake device has the
+// same address.
+type FakeDevice string
+
+func (d FakeDevice) Name() string { return string(d) }
+func (d FakeDevice) Addr() net.HardwareAddr { return net.HardwareAddr{0x49, 0x42, 0x08, 0x00, 0x12, 0x34} }
+func (d FakeDevice) Backend() device.Backend { return nil }
+func (d FakeDevice) String() string { return "fake[" + string(d) + "]" }
+
+// DecodeFields decodes the fields of a protobuf message, calling f with the raw value of each:
+// v for length-delimited fields, n for the others.
+func DecodeFields(t testing.TB, b []byte, f func(num protowire.Number, v []byte, n uint64)) {
+  t.Helper()
+
+  for len(b) > 0 {
+    num, typ, n := protowire.ConsumeTag(b)
+    b = b[n:]
+
+    switch typ {
+    case protowire.BytesType:
+      v, n := protowire.ConsumeBytes(b)
+      f(num, v, 0)
+      b = b[n:]
+    case protowire.Fixed64Type:
+      v, n := protowire.ConsumeFixed64(b)
+      f(num, nil, v)
+      b = b[n:]
+    case protowire.VarintType:
+      v, n := protowire.ConsumeVarint(b)
+      f(num, nil, v)
+      b = b[n:]
+    default:
+      t.Fatalf("unexpected wire type %v", typ)
+    }
+  }
+}
@@ -2,25 +2,35 @@ package main
 
 import (
   "context"
+  "errors"
   "fmt"
   "net"
   "net/http"
   "os"
   "os/signal"
+  "sync/atomic"
   "syscall"
   "time"
 
   "github.com/prometheus/client_golang/prometheus"
-  "github.com/prometheus/client_golang/prometheus/promhttp"
+  "github.com/robertof/go-inkbird-exporter/alert"
+  "github.com/robertof/go-inkbird-exporter/api"
   "github.com/robertof/go-inkbird-exporter/ble"
   "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/dashboard"
   "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/ha"
+  "github.com/robertof/go-inkbird-exporter/history"
   "github.com/robertof/go-inkbird-exporter/metrics"
+  "github.com/robertof/go-inkbird-exporter/sink"
   "github.com/robertof/go-inkbird-exporter/utils"
   "github.com/rs/zerolog"
   "github.com/rs/zerolog/log"
 )
 
+// How long in-flight HTTP requests get to finish on shutdown.
+const httpShutdownTimeout = 5 * time.Second
+
 func main() {
   zerolog.DurationFieldUnit = time.Second
   zerolog.TimeFieldFormat = time.RFC3339Nano
@@ -30,6 +40,10 @@ func main() {
     TimeFormat: "15:04:05.000",
   })
 
+  if len(os.Args) > 1 && os.Args[1] == "burst" {
+    os.Exit(runBurstCommand(os.Args[2:]))
+  }
+
   cfg := ParseArgs()
 
   if cfg.Trace || os.Getenv("TRACE") != "" {
@@ -45,6 +59,12 @@ func main() {
     return
   }
 
+  openHistory(&cfg)
+
+  if cfg.Once {
+    os.Exit(runOnce(cfg))
+  }
+
   log.Info().
     Str("BindAddr", cfg.BindAddress).
     Array("Devices", utils.ToZeroLogArray(cfg.Devices)).
@@ -54,45 +74,158 @@ func main() {
   observeSignals()
 
   bleHandle := initBle(cfg)
-  initialReadings := collectInitialReadings(cfg, bleHandle)
+  elector := newElector(cfg)
+
+  initialDevices := cfg.Devices
+
+  if elector != nil {
+    // the other instance might be leading: don't connect to devices until elected.
+    initialDevices = utils.Filter(cfg.Devices, isPassive)
+  }
+
+  initialReadings := collectInitialReadings(cfg, bleHandle, initialDevices)
 
   coll := collector.NewRecurring(bleHandle, cfg.Devices)
   coll.IdleTimeout = cfg.CollectionIdleTimeout
+  coll.Breaker = cfg.Breaker
+  coll.OnDemandTTL = cfg.OnDemandTTL
+  coll.StaleAfter = cfg.StaleAfter
+
+  for _, dev := range cfg.Devices {
+    policy, _ := cfg.DevicePolicy(dev, cfg.CollectionTimeout) // validated in ParseArgs()
+    coll.SetPolicy(dev, policy)
+    coll.SetLabels(dev, cfg.DeviceLabels[dev])
+  }
+
+  for _, s := range cfg.Sinks {
+    opts, _ := cfg.SinkOptions.WithOverrides(s.Overrides) // validated in ParseArgs()
+    coll.AddSink(s.Name, s.Sink, opts)
+  }
+
   coll.Update(initialReadings)
 
   registry := prometheus.NewRegistry()
 
-  metrics.RegisterCollector(
-    func() (map[device.Device]device.Reading, time.Time) {
-      // no way to get the HTTP request context from the collector unfortunately :(
-      return coll.WaitLatest(context.Background())
-    },
-    registry,
-  )
-
   if cfg.EnableMetamonitoring {
     ble.RegisterMetrics(registry)
+    collector.RegisterMetrics(registry)
+    alert.RegisterMetrics(registry)
+
+    for _, s := range cfg.Sinks {
+      if ms, ok := s.Sink.(sink.MetricsSink); ok {
+        ms.SetGatherer(registry)
+      }
+    }
   }
 
-  go coll.Start(
-    ble.WrapContextWithSigHandler(context.WithCancel(context.Background())),
-    cfg.CollectionInterval,
-    collector.CollectionOptions{
-      TimeoutPerAttempt: cfg.CollectionTimeout,
-      MaxRetries: cfg.MaxRetries,
-      BackoffFactor: cfg.Backoff,
-    },
-  )
+  // nil outside of high-availability mode.
+  var leading func() bool
+
+  if elector != nil {
+    var leader atomic.Bool
+    startElection(cfg, elector, coll, registry, &leader)
+    leading = leader.Load
+  }
+
+  // alerts are evaluated until the collector stopped, so that the last readings delivered by the
+  // sinks are evaluated too.
+  alertsCtx, stopAlerts := context.WithCancel(context.Background())
+  alertsStopped := make(chan struct{})
+
+  if cfg.Alerts != nil {
+    go func() {
+      defer close(alertsStopped)
+
+      // in standby, it's up to the leader to notify alerts.
+      cfg.Alerts.Run(alertsCtx, leading)
+    }()
+  } else {
+    close(alertsStopped)
+  }
+
+  ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+  defer stop()
+
+  if cfg.History != nil {
+    go cfg.History.Run(ctx)
+  }
+
+  // closed once the collector stopped, the sinks delivered their queued readings and the alerts
+  // sent their queued notifications.
+  stopped := make(chan struct{})
+
+  go func() {
+    defer close(stopped)
+    defer func() {
+      stopAlerts()
+      <-alertsStopped
+    }()
+
+    coll.Start(
+      ble.WrapContextWithSigHandler(ctx, stop),
+      cfg.CollectionInterval,
+      collector.CollectionOptions{
+        TimeoutPerAttempt: cfg.CollectionTimeout,
+        MaxRetries: cfg.MaxRetries,
+        BackoffFactor: cfg.Backoff,
+        MaxBackoff: cfg.MaxBackoff,
+        BackoffJitter: cfg.BackoffJitter,
+      },
+    )
+  }()
+
+  if cfg.BindAddress == "" {
+    log.Info().Msg("HTTP server disabled, collecting for sinks only")
+
+    <-stopped
+    return
+  }
 
   log.Info().
       Str("ListenAddress", cfg.BindAddress).
       Msg("Starting Prometheus server")
 
-  http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+  http.Handle("/metrics", metrics.Handler(registry, coll.WaitLatest, cfg.ScrapeTimeoutOffset))
+  http.Handle(api.Prefix, api.Handler(coll, cfg.History, cfg.Stream))
+  http.Handle("/healthz", api.Healthz(coll, bleHandle))
+  http.Handle("/readyz", api.Readyz(coll, api.ReadyOptions{MinFreshRatio: cfg.ReadyMinFreshRatio}))
+  http.Handle("/", dashboard.Handler())
+
+  server := &http.Server{Addr: cfg.BindAddress}
+
+  go func() {
+    <-ctx.Done()
+
+    // streams don't end on their own: don't wait for them too long.
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+    defer cancel()
 
-  if err := http.ListenAndServe(cfg.BindAddress, nil); err != nil {
+    server.Shutdown(shutdownCtx)
+  }()
+
+  if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
       log.Fatal().Err(err).Msg("Unable to bind on requested address")
   }
+
+  <-stopped
+}
+
+// Open the history database, if any, once the whole configuration is valid. It is fed like any
+// other sink, with the default queue options.
+func openHistory(cfg *config) {
+  if cfg.HistoryDB == "" {
+    return
+  }
+
+  store, err := history.Open(cfg.HistoryDB, cfg.HistoryOptions)
+
+  if err != nil {
+    log.Fatal().Err(err).Str("Path", cfg.HistoryDB).Msg("Failed to open the history database")
+  }
+
+  overrides, _ := collector.SinkSchema.Parse(device.DeviceSpec{})
+  cfg.History = store
+  cfg.Sinks = append(cfg.Sinks, configuredSink{Name: historySinkName, Sink: store, Overrides: overrides})
 }
 
 func initBle(cfg config) *ble.Handle {
@@ -117,6 +250,8 @@ func initBle(cfg config) *ble.Handle {
     log.Fatal().Err(err).Msg("Failed to initialize Bluetooth device")
   }
 
+  bleHandle.SetArbitration(cfg.RadioArbitration, cfg.MaxParallelConnections)
+
   err = bleHandle.SetAllowListedAddresses(deviceAddresses)
 
   if err != nil {
@@ -136,6 +271,8 @@ func initBle(cfg config) *ble.Handle {
     if err != nil {
       log.Fatal().Err(err).Msg("Failed to re-initialize Bluetooth device")
     }
+
+    bleHandle.SetArbitration(cfg.RadioArbitration, cfg.MaxParallelConnections)
   }
 
   return bleHandle
@@ -170,19 +307,76 @@ func observeSignals() {
   signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
 }
 
-func collectInitialReadings(cfg config, bleHandle *ble.Handle) (res map[device.Device]device.Reading) {
+func newElector(cfg config) ha.Elector {
+  switch {
+  case cfg.HALockFile != "":
+    return ha.NewLockFileElector(cfg.HALockFile, cfg.HA)
+  case cfg.HAPeer != "":
+    return ha.NewPeerElector(cfg.HAPeer, cfg.HA)
+  default:
+    return nil
+  }
+}
+
+// Only the leader collects every device: followers collect passive devices only, or nothing,
+// depending on the follower mode.
+func startElection(cfg config, elector ha.Elector, coll *collector.Recurring, registry *prometheus.Registry, leader *atomic.Bool) {
+  followerFilter := isPassive
+
+  if cfg.HAFollowerMode == ha.FollowerIdle {
+    followerFilter = func(device.Device) bool { return false }
+  }
+
+  log.Info().
+    Str("ID", cfg.HA.ID).
+    Stringer("FollowerMode", &cfg.HAFollowerMode).
+    Msg("Starting in high-availability mode as follower")
+
+  coll.SetEnabled(followerFilter)
+
+  if peer, ok := elector.(*ha.PeerElector); ok {
+    http.Handle(ha.HeartbeatPath, peer)
+  }
+
+  // the role is part of the state of the exporter, rather than metamonitoring.
+  ha.RegisterMetrics(registry)
+
+  go elector.Run(
+    ble.WrapContextWithSigHandler(context.WithCancel(context.Background())),
+    func(role ha.Role) {
+      leader.Store(role == ha.RoleLeader)
+
+      if role == ha.RoleLeader {
+        coll.SetEnabled(nil)
+      } else {
+        coll.SetEnabled(followerFilter)
+      }
+    },
+  )
+}
+
+func isPassive(dev device.Device) bool {
+  _, active := dev.Backend().(device.ActiveBackend)
+  return !active
+}
+
+func collectInitialReadings(
+  cfg config,
+  bleHandle *ble.Handle,
+  devices []device.Device,
+) (res map[device.Device]device.Reading) {
   log.Info().
     Dur("TimeoutSec", cfg.InitialCollectionTimeout).
     Msg("Running initial collection for the provided devices")
 
-  readings, err := collector.CollectReadingsWithOptions(
+  readings, err := collector.CollectReadingsPerDevice(
     bleHandle,
     ble.WrapContextWithSigHandler(context.WithCancel(context.Background())),
-    cfg.Devices,
-    collector.CollectionOptions{
-      TimeoutPerAttempt: cfg.InitialCollectionTimeout,
-      MaxRetries: cfg.MaxRetries,
-      BackoffFactor: cfg.Backoff,
+    devices,
+    func(dev device.Device) collector.CollectionOptions {
+      // devices with a custom timeout keep it, as they are likely slower than usual.
+      policy, _ := cfg.DevicePolicy(dev, cfg.InitialCollectionTimeout)
+      return policy.CollectionOptions
     },
   )
 
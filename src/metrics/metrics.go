@@ -1,11 +1,17 @@
 package metrics
 
 import (
+  "context"
+  "net/http"
   "strconv"
   "time"
 
   "github.com/prometheus/client_golang/prometheus"
+  "github.com/prometheus/client_golang/prometheus/promhttp"
+  dto "github.com/prometheus/client_model/go"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
   "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/rs/zerolog/log"
 )
 
 var (
@@ -36,12 +42,30 @@ var (
     []string{"name"},
     nil,
   )
+
+  descLastUpdate = prometheus.NewDesc(
+    "sensor_last_update_timestamp_seconds",
+    "Time of the last successful reading of the sensor, in seconds since the epoch.",
+    []string{"name"},
+    nil,
+  )
+
+  descScrapeStale = prometheus.NewDesc(
+    "inkbird_exporter_scrape_stale",
+    "Whether the scrape gave up waiting for a collection and served stale data (1) or not (0).",
+    nil,
+    nil,
+  )
 )
 
-type CollectFunc func() (map[device.Device]device.Reading, time.Time)
+// Retrieves the samples to export. The context is bound to the scrape: when it expires, the last
+// available samples should be returned along with the context error.
+type CollectFunc func(ctx context.Context) (map[device.Device]model.Sample, error)
 
+// The sensor metrics of a scrape, collected once before the registry is gathered.
 type collector struct {
-  CollectFunc
+  samples samplesCollector
+  stale bool
 }
 
 func (c *collector) Describe(ch chan<- *prometheus.Desc) {
@@ -49,60 +73,164 @@ func (c *collector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (c *collector) Collect(ch chan<- prometheus.Metric) {
-  out, ts := c.CollectFunc()
+  stale := 0.
 
-  if out == nil {
-    panic("collector got empty data!")
+  if c.stale {
+    stale = 1
   }
 
-  for device, reading := range out {
-    for probe, temp := range reading.Temperatures {
-      temperature := prometheus.MustNewConstMetric(
-        descTemperature,
-        prometheus.GaugeValue,
-        float64(temp),
-        device.Name(),
-        strconv.Itoa(probe),
-      )
-
-      ch <- prometheus.NewMetricWithTimestamp(ts, temperature)
-    }
+  ch <- prometheus.MustNewConstMetric(descScrapeStale, prometheus.GaugeValue, stale)
+  c.samples.Collect(ch)
+}
 
-    if reading.HasHumidity {
-      humidity := prometheus.MustNewConstMetric(
-        descHumidity,
-        prometheus.GaugeValue,
-        float64(reading.RelativeHumidity) / 100,
-        device.Name(),
-      )
+// SampleMetrics returns the sensor metrics of a sample, with the time it was collected at.
+func SampleMetrics(device device.Device, sample model.Sample) (out []prometheus.Metric) {
+  reading, ts := sample.Reading, sample.Time
 
-      ch <- prometheus.NewMetricWithTimestamp(ts, humidity)
-    }
+  for probe, temp := range reading.Temperatures {
+    temperature := prometheus.MustNewConstMetric(
+      descTemperature,
+      prometheus.GaugeValue,
+      float64(temp),
+      device.Name(),
+      strconv.Itoa(probe),
+    )
+
+    out = append(out, prometheus.NewMetricWithTimestamp(ts, temperature))
+  }
 
-    if reading.HasBatteryLevel {
-      battery := prometheus.MustNewConstMetric(
-        descBattery,
-        prometheus.GaugeValue,
-        float64(reading.BatteryLevel) / 100,
-        device.Name(),
-      )
+  if reading.HasHumidity {
+    humidity := prometheus.MustNewConstMetric(
+      descHumidity,
+      prometheus.GaugeValue,
+      float64(reading.RelativeHumidity) / 100,
+      device.Name(),
+    )
 
-      ch <- prometheus.NewMetricWithTimestamp(ts, battery)
-    }
+    out = append(out, prometheus.NewMetricWithTimestamp(ts, humidity))
+  }
 
-    probeType := prometheus.MustNewConstMetric(
-      descProbeType,
+  if reading.HasBatteryLevel {
+    battery := prometheus.MustNewConstMetric(
+      descBattery,
       prometheus.GaugeValue,
-      float64(reading.ProbeType),
+      float64(reading.BatteryLevel) / 100,
       device.Name(),
     )
 
-    ch <- prometheus.NewMetricWithTimestamp(ts, probeType)
+    out = append(out, prometheus.NewMetricWithTimestamp(ts, battery))
   }
+
+  probeType := prometheus.MustNewConstMetric(
+    descProbeType,
+    prometheus.GaugeValue,
+    float64(reading.ProbeType),
+    device.Name(),
+  )
+
+  out = append(out, prometheus.NewMetricWithTimestamp(ts, probeType))
+
+  out = append(out, prometheus.MustNewConstMetric(
+    descLastUpdate,
+    prometheus.GaugeValue,
+    float64(ts.UnixNano()) / 1e9,
+    device.Name(),
+  ))
+
+  return out
+}
+
+// Collects the sensor metrics of fixed samples.
+type samplesCollector map[device.Device]model.Sample
+
+func (c samplesCollector) Describe(ch chan<- *prometheus.Desc) {
+  prometheus.DescribeByCollect(c, ch)
 }
 
-func RegisterCollector(f CollectFunc, reg prometheus.Registerer) {
-  c := &collector{f}
+func (c samplesCollector) Collect(ch chan<- prometheus.Metric) {
+  for device, sample := range c {
+    for _, m := range SampleMetrics(device, sample) {
+      ch <- m
+    }
+  }
+}
+
+// SamplesGatherer gathers the sensor metrics of samples, along with the metrics from extra if not
+// nil, without timestamps: for consumers refusing them, such as the Pushgateway and the textfile
+// collector of node_exporter.
+func SamplesGatherer(samples map[device.Device]model.Sample, extra prometheus.Gatherer) prometheus.Gatherer {
+  sensors := prometheus.NewRegistry()
+  sensors.MustRegister(samplesCollector(samples))
+
+  gatherers := prometheus.Gatherers{sensors}
+
+  if extra != nil {
+    gatherers = append(gatherers, extra)
+  }
+
+  return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+    families, err := gatherers.Gather()
+
+    for _, family := range families {
+      for _, m := range family.GetMetric() {
+        m.TimestampMs = nil
+      }
+    }
+
+    return families, err
+  })
+}
+
+const scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+
+// Handler serves the metrics from the registry along with the sensor metrics retrieved through f.
+// The context passed to f is canceled when the scrape request is, or when the scrape timeout
+// advertised by Prometheus expires. timeoutOffset is subtracted from the advertised timeout to
+// leave enough time to answer before Prometheus gives up on the scrape.
+func Handler(reg prometheus.Gatherer, f CollectFunc, timeoutOffset time.Duration) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := scrapeContext(r, timeoutOffset)
+    defer cancel()
+
+    // registering calls Collect() to describe the metrics, so f is called beforehand: the scrape
+    // must only wait for a collection once.
+    out, err := f(ctx)
+
+    if out == nil {
+      panic("collector got empty data!")
+    }
+
+    if err != nil {
+      log.Warn().Err(err).Msg("metrics: scrape deadline hit while waiting for a collection, serving stale data")
+    }
+
+    // the sensor metrics are bound to the request, so they get their own registry.
+    sensors := prometheus.NewRegistry()
+    sensors.MustRegister(&collector{samples: out, stale: err != nil})
+
+    promhttp.HandlerFor(prometheus.Gatherers{reg, sensors}, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+  })
+}
+
+func scrapeContext(r *http.Request, offset time.Duration) (context.Context, context.CancelFunc) {
+  if v := r.Header.Get(scrapeTimeoutHeader); v != "" {
+    timeout, err := strconv.ParseFloat(v, 64)
+
+    if err == nil && timeout > 0 {
+      deadline := time.Duration(timeout * float64(time.Second))
+
+      // don't let the offset eat the whole scrape timeout.
+      if deadline - offset > deadline / 2 {
+        deadline -= offset
+      } else {
+        deadline /= 2
+      }
+
+      return context.WithTimeout(r.Context(), deadline)
+    }
+
+    log.Debug().Str("Header", v).Msg("metrics: ignoring invalid scrape timeout header")
+  }
 
-  reg.MustRegister(c)
+  return context.WithCancel(r.Context())
 }
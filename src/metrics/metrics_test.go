@@ -0,0 +1,32 @@
+package metrics
+
+import (
+  "context"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+
+  "github.com/prometheus/client_golang/prometheus"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/internal/testutil"
+)
+
+func TestHandlerCollectsOnce(t *testing.T) {
+  calls := 0
+  f := func(ctx context.Context) (map[device.Device]model.Sample, error) {
+    calls += 1
+
+    return map[device.Device]model.Sample{
+      testutil.FakeDevice("fridge"): {Reading: device.Reading{Temperatures: []float32{4}}, Time: time.Now()},
+    }, nil
+  }
+
+  rec := httptest.NewRecorder()
+  Handler(prometheus.NewRegistry(), f, 0).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+  if rec.Code != http.StatusOK || calls != 1 {
+    t.Errorf("got status %v after %d collections, wanted 200 after 1", rec.Code, calls)
+  }
+}
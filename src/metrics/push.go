@@ -0,0 +1,40 @@
+package metrics
+
+import (
+  "context"
+  "net/http"
+  "time"
+
+  "github.com/prometheus/client_golang/prometheus"
+  "github.com/prometheus/client_golang/prometheus/push"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+)
+
+const DefaultPushTimeout = 30 * time.Second
+
+// PushOptions configures pushes to a Prometheus Pushgateway.
+type PushOptions struct {
+  URL string
+  Job string
+  // Grouping key labels, besides the job, e.g. the instance.
+  Grouping map[string]string
+  Timeout time.Duration
+}
+
+// Push the sensor metrics of samples, along with the metrics from extra if not nil, replacing
+// every metric previously pushed with the same grouping key.
+//
+// The Pushgateway rejects metrics with timestamps: the time of each reading is still available
+// through sensor_last_update_timestamp_seconds.
+func Push(ctx context.Context, opts PushOptions, samples map[device.Device]model.Sample, extra prometheus.Gatherer) error {
+  pusher := push.New(opts.URL, opts.Job).
+    Client(&http.Client{Timeout: opts.Timeout}).
+    Gatherer(SamplesGatherer(samples, extra))
+
+  for name, value := range opts.Grouping {
+    pusher = pusher.Grouping(name, value)
+  }
+
+  return pusher.PushContext(ctx)
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+  "bytes"
+  "context"
+  "io"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+
+  dto "github.com/prometheus/client_model/go"
+  "github.com/prometheus/common/expfmt"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/internal/testutil"
+)
+
+func TestPushStripsTimestamps(t *testing.T) {
+  var path, method string
+  var body []byte
+
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    path, method = r.URL.Path, r.Method
+    body, _ = io.ReadAll(r.Body)
+  }))
+  defer server.Close()
+
+  samples := map[device.Device]model.Sample{
+    testutil.FakeDevice("fridge"): {Reading: device.Reading{Temperatures: []float32{4}}, Time: time.UnixMilli(1700000000000)},
+  }
+
+  opts := PushOptions{URL: server.URL, Job: "inkbird", Grouping: map[string]string{"instance": "pi"}, Timeout: time.Second}
+
+  if err := Push(context.Background(), opts, samples, nil); err != nil {
+    t.Fatal(err)
+  }
+
+  if method != http.MethodPut || path != "/metrics/job/inkbird/instance/pi" {
+    t.Errorf("got %v %v", method, path)
+  }
+
+  families := decodeDelimited(t, body)
+  temp, ok := families["sensor_temperature_celsius"]
+
+  if !ok || len(temp.GetMetric()) != 1 || temp.GetMetric()[0].GetGauge().GetValue() != 4 {
+    t.Fatalf("unexpected temperature %v", temp)
+  }
+
+  for name, family := range families {
+    for _, m := range family.GetMetric() {
+      if m.TimestampMs != nil {
+        t.Errorf("%v pushed with a timestamp", name)
+      }
+    }
+  }
+}
+
+// Decode a pushed body, in the delimited protobuf format, into its metric families by name.
+func decodeDelimited(t *testing.T, body []byte) map[string]*dto.MetricFamily {
+  out := make(map[string]*dto.MetricFamily)
+  decoder := expfmt.NewDecoder(bytes.NewReader(body), expfmt.FmtProtoDelim)
+
+  for {
+    family := &dto.MetricFamily{}
+
+    if err := decoder.Decode(family); err == io.EOF {
+      break
+    } else if err != nil {
+      t.Fatal(err)
+    }
+
+    out[family.GetName()] = family
+  }
+
+  return out
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+  "context"
+  "io"
+  "time"
+
+  "github.com/prometheus/client_golang/prometheus"
+  "github.com/robertof/go-inkbird-exporter/ble"
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/metrics"
+  "github.com/robertof/go-inkbird-exporter/sink"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  // Exit statuses of -once, besides the number of failed devices.
+  onceMaxFailedStatus = 100
+  oncePushFailedStatus = 101
+)
+
+// Collect every device a single time, push the readings to the Pushgateway and to the sinks, then
+// return the exit status: the number of devices which failed (up to onceMaxFailedStatus), or
+// oncePushFailedStatus if anything couldn't be pushed.
+func runOnce(cfg config) int {
+  bleHandle := initBle(cfg)
+  defer bleHandle.Stop()
+
+  ctx := ble.WrapContextWithSigHandler(context.WithCancel(context.Background()))
+
+  results, err := collector.CollectReadingsPerDevice(
+    bleHandle,
+    ctx,
+    cfg.Devices,
+    func(dev device.Device) collector.CollectionOptions {
+      policy, _ := cfg.DevicePolicy(dev, cfg.CollectionTimeout) // validated in ParseArgs()
+      return policy.CollectionOptions
+    },
+  )
+
+  if err != nil {
+    log.Error().Err(err).Msg("Collection failed")
+  }
+
+  now := time.Now()
+  samples := make(map[device.Device]model.Sample)
+  var readings []collector.SinkReading
+
+  for _, dev := range cfg.Devices {
+    result, ok := results[dev]
+
+    if !ok || result.Error != nil {
+      log.Error().Stringer("Device", dev).Err(result.Error).Msg("Failed to collect reading for device")
+      continue
+    }
+
+    log.Info().
+      Stringer("Device", dev).
+      Stringer("Reading", result.Reading).
+      Msg("Successfully collected reading for device")
+
+    sample := model.Sample{Reading: result.Reading, Time: now}
+    samples[dev] = sample
+    readings = append(readings, collector.SinkReading{Device: dev, Labels: cfg.DeviceLabels[dev], Sample: sample})
+  }
+
+  status := len(cfg.Devices) - len(samples)
+
+  if status > onceMaxFailedStatus {
+    status = onceMaxFailedStatus
+  }
+
+  // pushes aren't canceled by signals received after the collection.
+  pushCtx := context.Background()
+  var registry prometheus.Gatherer
+
+  if cfg.EnableMetamonitoring {
+    reg := prometheus.NewRegistry()
+    ble.RegisterMetrics(reg)
+    collector.RegisterMetrics(reg)
+    registry = reg
+  }
+
+  if cfg.History != nil {
+    // there is no time to compact it periodically.
+    if err := cfg.History.Compact(pushCtx, time.Now()); err != nil {
+      log.Warn().Err(err).Msg("Failed to compact the history")
+    }
+  }
+
+  for _, s := range cfg.Sinks {
+    if ms, ok := s.Sink.(sink.MetricsSink); ok && registry != nil {
+      ms.SetGatherer(registry)
+    }
+
+    if len(readings) > 0 {
+      if err := s.Deliver(pushCtx, readings); err != nil {
+        log.Error().Err(err).Str("Sink", s.Name).Msg("Failed to deliver readings to sink")
+        status = oncePushFailedStatus
+      }
+    }
+
+    if closer, ok := s.Sink.(io.Closer); ok {
+      if err := closer.Close(); err != nil {
+        log.Error().Err(err).Str("Sink", s.Name).Msg("Failed to close sink")
+        status = oncePushFailedStatus
+      }
+    }
+  }
+
+  if cfg.Push.URL != "" {
+    if err := metrics.Push(pushCtx, cfg.Push, samples, registry); err != nil {
+      log.Error().Err(err).Str("URL", cfg.Push.URL).Msg("Failed to push to the Pushgateway")
+      status = oncePushFailedStatus
+    } else {
+      log.Info().Str("URL", cfg.Push.URL).Int("Devices", len(samples)).Msg("Pushed readings to the Pushgateway")
+    }
+  }
+
+  return status
+}
@@ -0,0 +1,182 @@
+// Package diskqueue persists records waiting to be retried by sinks, so that they survive
+// restarts of the exporter.
+package diskqueue
+
+import (
+  "errors"
+  "fmt"
+  "io/fs"
+  "os"
+  "path/filepath"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+)
+
+const recordExt = ".rec"
+
+// Queue is a FIFO of records stored in a directory, one file per record. Records are expected to
+// be batches of data (e.g. a request body) rather than single values.
+type Queue struct {
+  dir string
+  // Upper bound for the total size of the records. The oldest records are dropped to stay below it.
+  maxBytes int64
+
+  mu sync.Mutex
+  // sequence numbers of the stored records, oldest first.
+  seqs []uint64
+  sizes map[uint64]int64
+  size int64
+}
+
+// Open the queue stored in dir, creating the directory if needed. Zero maxBytes means unbounded.
+func Open(dir string, maxBytes int64) (*Queue, error) {
+  if err := os.MkdirAll(dir, 0o700); err != nil {
+    return nil, err
+  }
+
+  entries, err := os.ReadDir(dir)
+
+  if err != nil {
+    return nil, err
+  }
+
+  q := &Queue{
+    dir: dir,
+    maxBytes: maxBytes,
+    sizes: make(map[uint64]int64),
+  }
+
+  for _, entry := range entries {
+    name := entry.Name()
+
+    if entry.IsDir() || !strings.HasSuffix(name, recordExt) {
+      continue
+    }
+
+    seq, err := strconv.ParseUint(strings.TrimSuffix(name, recordExt), 10, 64)
+
+    if err != nil {
+      continue
+    }
+
+    info, err := entry.Info()
+
+    if err != nil {
+      return nil, err
+    }
+
+    q.seqs = append(q.seqs, seq)
+    q.sizes[seq] = info.Size()
+    q.size += info.Size()
+  }
+
+  sort.Slice(q.seqs, func(i, j int) bool { return q.seqs[i] < q.seqs[j] })
+
+  return q, nil
+}
+
+func (q *Queue) path(seq uint64) string {
+  return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, recordExt))
+}
+
+// Push a record at the end of the queue. Returns the number of old records dropped to make room.
+func (q *Queue) Push(data []byte) (dropped int, err error) {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+
+  if q.maxBytes > 0 && int64(len(data)) > q.maxBytes {
+    return 0, fmt.Errorf("record of %d bytes exceeds the size of the queue", len(data))
+  }
+
+  var seq uint64
+
+  if len(q.seqs) > 0 {
+    seq = q.seqs[len(q.seqs) - 1] + 1
+  }
+
+  // write atomically, so that a crash never leaves a partial record behind.
+  tmp := q.path(seq) + ".tmp"
+
+  if err := os.WriteFile(tmp, data, 0o600); err != nil {
+    os.Remove(tmp)
+    return 0, err
+  }
+
+  if err := os.Rename(tmp, q.path(seq)); err != nil {
+    os.Remove(tmp)
+    return 0, err
+  }
+
+  q.seqs = append(q.seqs, seq)
+  q.sizes[seq] = int64(len(data))
+  q.size += int64(len(data))
+
+  for q.maxBytes > 0 && q.size > q.maxBytes {
+    if err := q.popLocked(); err != nil {
+      return dropped, err
+    }
+
+    dropped += 1
+  }
+
+  return dropped, nil
+}
+
+// Peek returns the oldest record, or false if the queue is empty.
+func (q *Queue) Peek() ([]byte, bool, error) {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+
+  if len(q.seqs) == 0 {
+    return nil, false, nil
+  }
+
+  data, err := os.ReadFile(q.path(q.seqs[0]))
+
+  return data, err == nil, err
+}
+
+// Pop removes the oldest record, if any.
+func (q *Queue) Pop() error {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+
+  if len(q.seqs) == 0 {
+    return nil
+  }
+
+  return q.popLocked()
+}
+
+// must be called with mu held.
+func (q *Queue) popLocked() error {
+  seq := q.seqs[0]
+
+  if err := os.Remove(q.path(seq)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+    return err
+  }
+
+  q.seqs = q.seqs[1:]
+  q.size -= q.sizes[seq]
+  delete(q.sizes, seq)
+
+  return nil
+}
+
+// Len returns the number of queued records.
+func (q *Queue) Len() int {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+
+  return len(q.seqs)
+}
+
+// Size returns the total size of the queued records, in bytes.
+func (q *Queue) Size() int64 {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+
+  return q.size
+}
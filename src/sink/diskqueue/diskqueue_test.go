@@ -0,0 +1,50 @@
+package diskqueue_test
+
+import (
+  "testing"
+
+  "github.com/robertof/go-inkbird-exporter/sink/diskqueue"
+)
+
+func TestQueue(t *testing.T) {
+  dir := t.TempDir()
+  q, err := diskqueue.Open(dir, 10)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  for _, record := range []string{"aaaa", "bbbb", "cccc"} {
+    if _, err := q.Push([]byte(record)); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  // the oldest record was dropped to stay within 10 bytes.
+  if q.Len() != 2 || q.Size() != 8 {
+    t.Fatalf("got %d records of %d bytes, wanted 2 of 8", q.Len(), q.Size())
+  }
+
+  // records survive reopening the queue.
+  q, err = diskqueue.Open(dir, 10)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  for _, want := range []string{"bbbb", "cccc"} {
+    got, ok, err := q.Peek()
+
+    if err != nil || !ok || string(got) != want {
+      t.Fatalf("got %q (%v, %v), wanted %q", got, ok, err, want)
+    }
+
+    if err := q.Pop(); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  if _, ok, _ := q.Peek(); ok {
+    t.Fatal("queue not empty after popping every record")
+  }
+}
@@ -0,0 +1,518 @@
+// Package file appends readings to a local log file, as CSV or JSON Lines, rotating it by size or
+// by day and optionally compressing and expiring rotated files.
+package file
+
+import (
+  "bufio"
+  "compress/gzip"
+  "context"
+  "encoding/csv"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "os"
+  "path/filepath"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  specFieldPath = "path"
+  specFieldFormat = "format"
+  specFieldMaxSize = "max-size"
+  specFieldDaily = "daily"
+  specFieldCompress = "compress"
+  specFieldMaxFiles = "max-files"
+  specFieldMaxAge = "max-age"
+
+  FormatCSV = "csv"
+  FormatJSONL = "jsonl"
+
+  // Layout of the time appended to the name of rotated files, sorting chronologically.
+  rotatedTimeLayout = "20060102-150405"
+  dayLayout = "2006-01-02"
+)
+
+var schema = device.Schema{
+  {
+    Name: specFieldPath,
+    Type: device.ParamTypeString,
+    Required: true,
+    Description: "File the readings are appended to, e.g. '/var/log/inkbird/readings.csv'. Rotated files are kept next to it",
+  },
+  {
+    Name: specFieldFormat,
+    Type: device.ParamTypeString,
+    Default: FormatCSV,
+    AllowedValues: []string{FormatCSV, FormatJSONL},
+    Description: "Format of the file: CSV with a header, or one JSON object per line",
+  },
+  {
+    Name: specFieldMaxSize,
+    Type: device.ParamTypeInt,
+    Default: "0",
+    Description: "Rotate the file once it grows beyond this size, in MiB (0 to disable)",
+  },
+  {
+    Name: specFieldDaily,
+    Type: device.ParamTypeBool,
+    Default: "false",
+    Description: "Rotate the file when the day (in local time) of the readings changes",
+  },
+  {
+    Name: specFieldCompress,
+    Type: device.ParamTypeBool,
+    Default: "false",
+    Description: "Compress rotated files with gzip",
+  },
+  {
+    Name: specFieldMaxFiles,
+    Type: device.ParamTypeInt,
+    Default: "0",
+    Description: "Max number of rotated files to keep, deleting the oldest (0 to keep all)",
+  },
+  {
+    Name: specFieldMaxAge,
+    Type: device.ParamTypeDuration,
+    Default: "0s",
+    Description: "Delete rotated files older than this (0 to keep all)",
+  },
+}
+
+type Factory struct{}
+
+func (f *Factory) Schema() device.Schema {
+  return schema
+}
+
+func (f *Factory) FromSpec(spec device.DeviceSpec) (collector.Sink, error) {
+  params, err := f.Schema().Parse(spec)
+
+  if err != nil {
+    return nil, err
+  }
+
+  opts := Options{
+    Path: params.String(specFieldPath),
+    Format: params.String(specFieldFormat),
+    MaxSize: int64(params.Int(specFieldMaxSize)) << 20,
+    Daily: params.Bool(specFieldDaily),
+    Compress: params.Bool(specFieldCompress),
+    MaxFiles: params.Int(specFieldMaxFiles),
+    MaxAge: params.Duration(specFieldMaxAge),
+  }
+
+  if opts.MaxSize < 0 || opts.MaxFiles < 0 || opts.MaxAge < 0 {
+    return nil, fmt.Errorf("%w: %s, %s and %s can't be negative", device.ErrInvalidSpec, specFieldMaxSize,
+      specFieldMaxFiles, specFieldMaxAge)
+  }
+
+  if err := os.MkdirAll(filepath.Dir(opts.Path), 0o755); err != nil {
+    return nil, fmt.Errorf("%w: %s: %w", device.ErrInvalidSpec, specFieldPath, err)
+  }
+
+  return New(opts), nil
+}
+
+// Options of the file sink.
+type Options struct {
+  Path string
+  // FormatCSV or FormatJSONL.
+  Format string
+  // Size in bytes beyond which the file is rotated, if positive.
+  MaxSize int64
+  // Rotate the file when the local day of the readings changes.
+  Daily bool
+  // Compress rotated files with gzip.
+  Compress bool
+  // Retention of rotated files, if positive.
+  MaxFiles int
+  MaxAge time.Duration
+}
+
+// Sink writes one row per probe of each reading, repeating the other values of the reading, so
+// that each row stands on its own in a spreadsheet.
+type Sink struct {
+  opts Options
+  file *os.File
+  size int64
+  // local day of the readings in the current file, empty if it holds none yet.
+  day string
+}
+
+var csvHeader = []string{"time", "device", "addr", "probe", "temperature", "humidity", "battery", "rssi"}
+
+// A row of the log, in JSON Lines form.
+type row struct {
+  Time time.Time `json:"time"`
+  Device string `json:"device"`
+  Addr string `json:"addr"`
+  Probe int `json:"probe"`
+  Temperature float32 `json:"temperature"`
+  Humidity *float32 `json:"humidity,omitempty"`
+  Battery *uint8 `json:"battery,omitempty"`
+  RSSI *int `json:"rssi,omitempty"`
+}
+
+func New(opts Options) *Sink {
+  return &Sink{opts: opts}
+}
+
+func (s *Sink) Deliver(ctx context.Context, readings []collector.SinkReading) error {
+  var buf strings.Builder
+
+  for _, r := range readings {
+    day := r.Time.Local().Format(dayLayout)
+
+    if s.file == nil {
+      if err := s.open(); err != nil {
+        return err
+      }
+    }
+
+    if s.day != "" && ((s.opts.Daily && day != s.day) || (s.opts.MaxSize > 0 && s.size >= s.opts.MaxSize)) {
+      if err := s.flush(&buf); err != nil {
+        return err
+      }
+
+      if err := s.rotate(); err != nil {
+        return fmt.Errorf("failed to rotate %v: %w", s.opts.Path, err)
+      }
+
+      if err := s.open(); err != nil {
+        return err
+      }
+    }
+
+    if s.day == "" {
+      s.day = day
+    }
+
+    n := buf.Len()
+
+    if err := s.format(&buf, r); err != nil {
+      return err
+    }
+
+    // approximate until flushed, so that a large batch still rotates on size.
+    s.size += int64(buf.Len() - n)
+  }
+
+  return s.flush(&buf)
+}
+
+func (s *Sink) format(w *strings.Builder, r collector.SinkReading) error {
+  for probe, temp := range r.Temperatures {
+    out := row{
+      Time: r.Time,
+      Device: r.Device.Name(),
+      Addr: r.Device.Addr().String(),
+      Probe: probe,
+      Temperature: temp,
+    }
+
+    if r.HasHumidity {
+      out.Humidity = &r.RelativeHumidity
+    }
+
+    if r.HasBatteryLevel {
+      out.Battery = &r.BatteryLevel
+    }
+
+    if r.HasRSSI {
+      out.RSSI = &r.RSSI
+    }
+
+    if s.opts.Format == FormatJSONL {
+      line, err := json.Marshal(out)
+
+      if err != nil {
+        return err
+      }
+
+      w.Write(append(line, '\n'))
+      continue
+    }
+
+    record := []string{
+      out.Time.Format(time.RFC3339),
+      out.Device,
+      out.Addr,
+      strconv.Itoa(out.Probe),
+      strconv.FormatFloat(float64(out.Temperature), 'f', -1, 32),
+      "", "", "",
+    }
+
+    if out.Humidity != nil {
+      record[5] = strconv.FormatFloat(float64(*out.Humidity), 'f', -1, 32)
+    }
+
+    if out.Battery != nil {
+      record[6] = strconv.Itoa(int(*out.Battery))
+    }
+
+    if out.RSSI != nil {
+      record[7] = strconv.Itoa(*out.RSSI)
+    }
+
+    if err := writeCSV(w, record); err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+func writeCSV(w io.Writer, record []string) error {
+  cw := csv.NewWriter(w)
+  cw.Write(record)
+  cw.Flush()
+
+  return cw.Error()
+}
+
+// Write out the buffered rows.
+func (s *Sink) flush(buf *strings.Builder) error {
+  if buf.Len() == 0 {
+    return nil
+  }
+
+  defer buf.Reset()
+
+  if _, err := io.WriteString(s.file, buf.String()); err != nil {
+    return fmt.Errorf("failed to write to %v: %w", s.opts.Path, err)
+  }
+
+  return nil
+}
+
+// Open the log for appending, writing the CSV header if it's empty.
+func (s *Sink) open() error {
+  f, err := os.OpenFile(s.opts.Path, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0o644)
+
+  if err != nil {
+    return err
+  }
+
+  info, err := f.Stat()
+
+  if err != nil {
+    f.Close()
+    return err
+  }
+
+  s.file, s.size, s.day = f, info.Size(), ""
+
+  if s.size > 0 {
+    // the file already holds readings, likely from before a restart.
+    s.day = info.ModTime().Format(dayLayout)
+  } else if s.opts.Format == FormatCSV {
+    var header strings.Builder
+
+    if err := writeCSV(&header, csvHeader); err != nil {
+      return err
+    }
+
+    if err := s.flush(&header); err != nil {
+      return err
+    }
+
+    s.size = int64(len(header.String()))
+  }
+
+  return nil
+}
+
+// Close the log and move it aside, named after the time of its last write, then compress it and
+// apply the retention limits.
+func (s *Sink) rotate() error {
+  info, err := s.file.Stat()
+
+  if err != nil {
+    return err
+  }
+
+  if err := s.file.Close(); err != nil {
+    return err
+  }
+
+  s.file = nil
+
+  ext := filepath.Ext(s.opts.Path)
+  base := strings.TrimSuffix(s.opts.Path, ext) + "-" + info.ModTime().Format(rotatedTimeLayout)
+  rotated := base + ext
+
+  for n := 1; exists(rotated) || exists(rotated + ".gz"); n += 1 {
+    rotated = fmt.Sprintf("%s.%d%s", base, n, ext)
+  }
+
+  if err := os.Rename(s.opts.Path, rotated); err != nil {
+    return err
+  }
+
+  log.Debug().Str("Path", rotated).Msg("file: rotated log")
+
+  if s.opts.Compress {
+    if err := compress(rotated); err != nil {
+      log.Warn().Err(err).Str("Path", rotated).Msg("file: failed to compress rotated log")
+    }
+  }
+
+  s.expire()
+
+  return nil
+}
+
+func exists(path string) bool {
+  _, err := os.Lstat(path)
+  return !errors.Is(err, os.ErrNotExist)
+}
+
+// Replace path with a gzip-compressed path.gz.
+func compress(path string) (err error) {
+  in, err := os.Open(path)
+
+  if err != nil {
+    return err
+  }
+
+  defer in.Close()
+
+  info, err := in.Stat()
+
+  if err != nil {
+    return err
+  }
+
+  out, err := os.OpenFile(path + ".gz", os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0o644)
+
+  if err != nil {
+    return err
+  }
+
+  defer func() {
+    if err != nil {
+      out.Close()
+      os.Remove(out.Name())
+    }
+  }()
+
+  bw := bufio.NewWriter(out)
+  zw := gzip.NewWriter(bw)
+
+  if _, err := io.Copy(zw, in); err != nil {
+    return err
+  }
+
+  if err := zw.Close(); err != nil {
+    return err
+  }
+
+  if err := bw.Flush(); err != nil {
+    return err
+  }
+
+  if err := out.Close(); err != nil {
+    return err
+  }
+
+  // keep the time of the last write, used for retention.
+  if err := os.Chtimes(out.Name(), info.ModTime(), info.ModTime()); err != nil {
+    return err
+  }
+
+  return os.Remove(path)
+}
+
+// The rotated logs, oldest first.
+func (s *Sink) rotatedFiles() ([]string, error) {
+  ext := filepath.Ext(s.opts.Path)
+  prefix := strings.TrimSuffix(s.opts.Path, ext) + "-"
+
+  matches, err := filepath.Glob(globEscape(prefix) + "*")
+
+  if err != nil {
+    return nil, err
+  }
+
+  var out []string
+
+  for _, m := range matches {
+    stamp := strings.TrimSuffix(strings.TrimSuffix(m, ".gz"), ext)[len(prefix):]
+
+    if len(stamp) >= len(rotatedTimeLayout) {
+      if _, err := time.Parse(rotatedTimeLayout, stamp[:len(rotatedTimeLayout)]); err == nil {
+        out = append(out, m)
+      }
+    }
+  }
+
+  sort.Strings(out)
+
+  return out, nil
+}
+
+func globEscape(s string) string {
+  var b strings.Builder
+
+  for _, r := range s {
+    if strings.ContainsRune(`*?[\`, r) {
+      b.WriteRune('\\')
+    }
+
+    b.WriteRune(r)
+  }
+
+  return b.String()
+}
+
+// Delete the rotated logs beyond the retention limits.
+func (s *Sink) expire() {
+  if s.opts.MaxFiles <= 0 && s.opts.MaxAge <= 0 {
+    return
+  }
+
+  files, err := s.rotatedFiles()
+
+  if err != nil {
+    log.Warn().Err(err).Msg("file: failed to list rotated logs")
+    return
+  }
+
+  for i, path := range files {
+    expired := s.opts.MaxFiles > 0 && len(files) - i > s.opts.MaxFiles
+
+    if !expired && s.opts.MaxAge > 0 {
+      info, err := os.Stat(path)
+      expired = err == nil && time.Since(info.ModTime()) > s.opts.MaxAge
+    }
+
+    if !expired {
+      continue
+    }
+
+    if err := os.Remove(path); err != nil {
+      log.Warn().Err(err).Str("Path", path).Msg("file: failed to delete expired log")
+    } else {
+      log.Debug().Str("Path", path).Msg("file: deleted expired log")
+    }
+  }
+}
+
+func (s *Sink) Close() error {
+  if s.file == nil {
+    return nil
+  }
+
+  err := s.file.Close()
+  s.file = nil
+
+  return err
+}
@@ -0,0 +1,148 @@
+package file
+
+import (
+  "compress/gzip"
+  "context"
+  "encoding/json"
+  "io"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/internal/testutil"
+)
+
+func reading(at time.Time, temps ...float32) collector.SinkReading {
+  return collector.SinkReading{
+    Device: testutil.FakeDevice("fridge"),
+    Sample: model.Sample{
+      Reading: device.Reading{
+        Temperatures: temps,
+        RelativeHumidity: 40.5,
+        HasHumidity: true,
+        RSSI: -70,
+        HasRSSI: true,
+      },
+      Time: at,
+    },
+  }
+}
+
+func TestSinkWritesCSV(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "readings.csv")
+  at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+  s := New(Options{Path: path, Format: FormatCSV})
+
+  if err := s.Deliver(context.Background(), []collector.SinkReading{reading(at, 4, 21.5)}); err != nil {
+    t.Fatal(err)
+  }
+
+  s.Close()
+
+  // reopening appends without repeating the header.
+  s = New(Options{Path: path, Format: FormatCSV})
+
+  if err := s.Deliver(context.Background(), []collector.SinkReading{reading(at.Add(time.Minute), 5)}); err != nil {
+    t.Fatal(err)
+  }
+
+  s.Close()
+
+  data, _ := os.ReadFile(path)
+  want := "time,device,addr,probe,temperature,humidity,battery,rssi\n" +
+    "2024-03-01T12:00:00Z,fridge,49:42:08:00:12:34,0,4,40.5,,-70\n" +
+    "2024-03-01T12:00:00Z,fridge,49:42:08:00:12:34,1,21.5,40.5,,-70\n" +
+    "2024-03-01T12:01:00Z,fridge,49:42:08:00:12:34,0,5,40.5,,-70\n"
+
+  if string(data) != want {
+    t.Errorf("got:\n%s\nwanted:\n%s", data, want)
+  }
+}
+
+func TestSinkRotatesBySize(t *testing.T) {
+  dir := t.TempDir()
+  path := filepath.Join(dir, "readings.jsonl")
+  at := time.Now()
+
+  // every reading fills the file: each delivery after the first rotates it.
+  s := New(Options{Path: path, Format: FormatJSONL, MaxSize: 1, Compress: true, MaxFiles: 2})
+  defer s.Close()
+
+  for i := 0; i < 4; i += 1 {
+    if err := s.Deliver(context.Background(), []collector.SinkReading{reading(at, float32(i))}); err != nil {
+      t.Fatal(err)
+    }
+
+    // rotated files are named after the second of their last write.
+    os.Chtimes(path, at.Add(time.Duration(i) * time.Second), at.Add(time.Duration(i) * time.Second))
+  }
+
+  rotated, err := s.rotatedFiles()
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if len(rotated) != 2 {
+    t.Fatalf("got rotated files %v, wanted the 2 latest", rotated)
+  }
+
+  // the oldest kept file holds the second reading.
+  for i, file := range rotated {
+    if !strings.HasSuffix(file, ".jsonl.gz") {
+      t.Fatalf("%v is not compressed", file)
+    }
+
+    f, _ := os.Open(file)
+    zr, err := gzip.NewReader(f)
+
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    var r row
+    data, _ := io.ReadAll(zr)
+    f.Close()
+
+    if err := json.Unmarshal(data, &r); err != nil || r.Temperature != float32(i + 1) || *r.RSSI != -70 {
+      t.Errorf("%v holds %s, wanted temperature %v", file, data, i + 1)
+    }
+  }
+}
+
+func TestSinkRotatesDaily(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "readings.csv")
+  day := time.Date(2024, 3, 1, 23, 59, 0, 0, time.Local)
+
+  s := New(Options{Path: path, Format: FormatCSV, Daily: true})
+  defer s.Close()
+
+  for _, at := range []time.Time{day, day.Add(30 * time.Second), day.Add(2 * time.Minute)} {
+    if err := s.Deliver(context.Background(), []collector.SinkReading{reading(at, 1)}); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  rotated, _ := s.rotatedFiles()
+
+  if len(rotated) != 1 {
+    t.Fatalf("got rotated files %v, wanted 1", rotated)
+  }
+
+  old, _ := os.ReadFile(rotated[0])
+  current, _ := os.ReadFile(path)
+
+  if n := strings.Count(string(old), "\n"); n != 3 {
+    t.Errorf("rotated file has %d lines, wanted the header and 2 readings", n)
+  }
+
+  if n := strings.Count(string(current), "\n"); n != 2 {
+    t.Errorf("current file has %d lines, wanted the header and 1 reading", n)
+  }
+}
@@ -0,0 +1,587 @@
+// Package influxdb writes readings to InfluxDB as line protocol, through the v1 or v2 HTTP API or
+// over UDP.
+package influxdb
+
+import (
+  "bytes"
+  "context"
+  "errors"
+  "fmt"
+  "io"
+  "net"
+  "net/http"
+  "net/url"
+  "os"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/sink/diskqueue"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  specFieldURL = "url"
+  specFieldAPI = "api"
+  specFieldDatabase = "database"
+  specFieldRetentionPolicy = "retention-policy"
+  specFieldUsername = "username"
+  specFieldPassword = "password"
+  specFieldOrg = "org"
+  specFieldBucket = "bucket"
+  specFieldToken = "token"
+  specFieldTokenFile = "token-file"
+  specFieldMeasurement = "measurement"
+  specFieldPrecision = "precision"
+  specFieldBatchSize = "batch-size"
+  specFieldFlushInterval = "flush-interval"
+  specFieldBufferDir = "buffer-dir"
+  specFieldBufferSize = "buffer-size"
+  specFieldTimeout = "timeout"
+
+  apiV1 = "v1"
+  apiV2 = "v2"
+
+  // Max size of UDP datagrams, to avoid fragmentation on most networks.
+  udpPayloadSize = 1400
+
+  // Timeout of the writes not triggered by a delivery: after FlushInterval, and on Close().
+  flushTimeout = 10 * time.Second
+)
+
+var schema = device.Schema{
+  {
+    Name: specFieldURL,
+    Type: device.ParamTypeString,
+    Required: true,
+    Description: "URL of the InfluxDB server, e.g. 'http://influx:8086', or 'udp://influx:8089' to write over UDP",
+  },
+  {
+    Name: specFieldAPI,
+    Type: device.ParamTypeString,
+    Default: apiV2,
+    AllowedValues: []string{apiV1, apiV2},
+    Description: "HTTP API to write with: v1 (/write) or v2 (/api/v2/write)",
+  },
+  {
+    Name: specFieldDatabase,
+    Type: device.ParamTypeString,
+    Description: "Database to write to. Required by the v1 API",
+  },
+  {
+    Name: specFieldRetentionPolicy,
+    Type: device.ParamTypeString,
+    Description: "Retention policy to write to with the v1 API. Defaults to the one of the database",
+  },
+  {
+    Name: specFieldUsername,
+    Type: device.ParamTypeString,
+    Description: "Username to authenticate with, for the v1 API",
+  },
+  {
+    Name: specFieldPassword,
+    Type: device.ParamTypeString,
+    Description: "Password to authenticate with, for the v1 API",
+  },
+  {
+    Name: specFieldOrg,
+    Type: device.ParamTypeString,
+    Description: "Organization to write to. Required by the v2 API",
+  },
+  {
+    Name: specFieldBucket,
+    Type: device.ParamTypeString,
+    Description: "Bucket to write to. Required by the v2 API",
+  },
+  {
+    Name: specFieldToken,
+    Type: device.ParamTypeString,
+    Description: "API token to authenticate with, for the v2 API",
+  },
+  {
+    Name: specFieldTokenFile,
+    Type: device.ParamTypeString,
+    Description: "File to read the API token from, instead of passing it on the command line",
+  },
+  {
+    Name: specFieldMeasurement,
+    Type: device.ParamTypeString,
+    Default: "inkbird",
+    Description: "Measurement of the written points",
+  },
+  {
+    Name: specFieldPrecision,
+    Type: device.ParamTypeString,
+    Default: string(PrecisionNanoseconds),
+    AllowedValues: []string{
+      string(PrecisionNanoseconds),
+      string(PrecisionMicroseconds),
+      string(PrecisionMilliseconds),
+      string(PrecisionSeconds),
+    },
+    Description: "Precision of the timestamps of the written points. Over UDP, must match the precision configured on the server",
+  },
+  {
+    Name: specFieldBatchSize,
+    Type: device.ParamTypeInt,
+    Default: "5000",
+    Description: "Max number of lines written per request",
+  },
+  {
+    Name: specFieldFlushInterval,
+    Type: device.ParamTypeDuration,
+    Default: "0s",
+    Description: "Hold readings for up to this long to write them in fewer requests, unless batch-size lines are pending",
+  },
+  {
+    Name: specFieldBufferDir,
+    Type: device.ParamTypeString,
+    Description: "Directory where failed writes are kept, to be retried in order at the next delivery. If not set, failed writes are dropped",
+  },
+  {
+    Name: specFieldBufferSize,
+    Type: device.ParamTypeInt,
+    Default: "64",
+    Description: "Max size of the buffer directory, in MiB. The oldest writes are dropped beyond it",
+  },
+  {
+    Name: specFieldTimeout,
+    Type: device.ParamTypeDuration,
+    Default: "10s",
+    Description: "Timeout of each request",
+  },
+}
+
+type Factory struct{}
+
+func (f *Factory) Schema() device.Schema {
+  return schema
+}
+
+func (f *Factory) FromSpec(spec device.DeviceSpec) (collector.Sink, error) {
+  params, err := f.Schema().Parse(spec)
+
+  if err != nil {
+    return nil, err
+  }
+
+  u, err := url.Parse(params.String(specFieldURL))
+
+  if err != nil || u.Host == "" {
+    return nil, fmt.Errorf("%w: %s must be an absolute URL", device.ErrInvalidSpec, specFieldURL)
+  }
+
+  opts := Options{
+    Measurement: params.String(specFieldMeasurement),
+    Precision: Precision(params.String(specFieldPrecision)),
+    BatchSize: params.Int(specFieldBatchSize),
+    FlushInterval: params.Duration(specFieldFlushInterval),
+  }
+
+  if opts.BatchSize <= 0 {
+    return nil, fmt.Errorf("%w: %s must be positive, got %v", device.ErrInvalidSpec, specFieldBatchSize, opts.BatchSize)
+  }
+
+  var w writer
+
+  switch {
+  case u.Scheme == "udp":
+    w = &udpWriter{addr: u.Host}
+  case u.Scheme != "http" && u.Scheme != "https":
+    return nil, fmt.Errorf("%w: %s must use one of http, https or udp, got %q", device.ErrInvalidSpec, specFieldURL, u.Scheme)
+  case params.String(specFieldAPI) == apiV1:
+    if w, err = newV1Writer(u, params, opts.Precision); err != nil {
+      return nil, err
+    }
+  default:
+    if w, err = newV2Writer(u, params, opts.Precision); err != nil {
+      return nil, err
+    }
+  }
+
+  if dir := params.String(specFieldBufferDir); dir != "" {
+    opts.Buffer, err = diskqueue.Open(dir, int64(params.Int(specFieldBufferSize)) << 20)
+
+    if err != nil {
+      return nil, fmt.Errorf("%w: %s: %w", device.ErrInvalidSpec, specFieldBufferDir, err)
+    }
+  }
+
+  return newSink(w, opts), nil
+}
+
+func newV1Writer(base *url.URL, params device.Params, precision Precision) (*httpWriter, error) {
+  if params.String(specFieldDatabase) == "" {
+    return nil, fmt.Errorf("%w: %s is required by the v1 API", device.ErrInvalidSpec, specFieldDatabase)
+  }
+
+  query := url.Values{
+    "db": {params.String(specFieldDatabase)},
+    "precision": {precision.v1()},
+  }
+
+  if rp := params.String(specFieldRetentionPolicy); rp != "" {
+    query.Set("rp", rp)
+  }
+
+  return &httpWriter{
+    url: base.JoinPath("write").String() + "?" + query.Encode(),
+    header: http.Header{},
+    username: params.String(specFieldUsername),
+    password: params.String(specFieldPassword),
+    client: &http.Client{Timeout: params.Duration(specFieldTimeout)},
+  }, nil
+}
+
+func newV2Writer(base *url.URL, params device.Params, precision Precision) (*httpWriter, error) {
+  if params.String(specFieldOrg) == "" || params.String(specFieldBucket) == "" {
+    return nil, fmt.Errorf("%w: %s and %s are required by the v2 API", device.ErrInvalidSpec, specFieldOrg, specFieldBucket)
+  }
+
+  query := url.Values{
+    "org": {params.String(specFieldOrg)},
+    "bucket": {params.String(specFieldBucket)},
+    "precision": {string(precision)},
+  }
+
+  w := &httpWriter{
+    url: base.JoinPath("api/v2/write").String() + "?" + query.Encode(),
+    header: http.Header{},
+    client: &http.Client{Timeout: params.Duration(specFieldTimeout)},
+  }
+
+  token := params.String(specFieldToken)
+
+  if file := params.String(specFieldTokenFile); file != "" {
+    data, err := os.ReadFile(file)
+
+    if err != nil {
+      return nil, fmt.Errorf("%w: %s: %w", device.ErrInvalidSpec, specFieldTokenFile, err)
+    }
+
+    token = strings.TrimSpace(string(data))
+  }
+
+  if token != "" {
+    w.header.Set("Authorization", "Token " + token)
+  }
+
+  return w, nil
+}
+
+// The server refused the data itself: writing it again would fail again.
+var errRejected = errors.New("write rejected")
+
+// Writes a batch of lines.
+type writer interface {
+  write(ctx context.Context, body []byte) error
+}
+
+type httpWriter struct {
+  url string
+  header http.Header
+  // basic authentication, for the v1 API.
+  username, password string
+  client *http.Client
+}
+
+func (w *httpWriter) write(ctx context.Context, body []byte) error {
+  req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+
+  if err != nil {
+    return err
+  }
+
+  for k, v := range w.header {
+    req.Header[k] = v
+  }
+
+  req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+  if w.username != "" {
+    req.SetBasicAuth(w.username, w.password)
+  }
+
+  resp, err := w.client.Do(req)
+
+  if err != nil {
+    return err
+  }
+
+  defer resp.Body.Close()
+
+  if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+    return nil
+  }
+
+  msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+  err = fmt.Errorf("unexpected status %v: %s", resp.Status, bytes.TrimSpace(msg))
+
+  if resp.StatusCode >= 400 && resp.StatusCode <= 499 && resp.StatusCode != http.StatusTooManyRequests {
+    return fmt.Errorf("%w: %w", errRejected, err)
+  }
+
+  return err
+}
+
+// Writes lines over UDP, in datagrams of at most udpPayloadSize bytes. Delivery is not confirmed.
+type udpWriter struct {
+  addr string
+  conn net.Conn
+}
+
+func (w *udpWriter) write(ctx context.Context, body []byte) error {
+  if w.conn == nil {
+    var d net.Dialer
+    conn, err := d.DialContext(ctx, "udp", w.addr)
+
+    if err != nil {
+      return err
+    }
+
+    w.conn = conn
+  }
+
+  for len(body) > 0 {
+    n := len(body)
+
+    if n > udpPayloadSize {
+      // split on the last line fitting in the datagram, or send oversized lines on their own.
+      if n = bytes.LastIndexByte(body[:udpPayloadSize], '\n') + 1; n == 0 {
+        n = bytes.IndexByte(body, '\n') + 1
+      }
+
+      if n == 0 {
+        n = len(body)
+      }
+    }
+
+    if _, err := w.conn.Write(body[:n]); err != nil {
+      return err
+    }
+
+    body = body[n:]
+  }
+
+  return nil
+}
+
+// Options of the InfluxDB sink.
+type Options struct {
+  Measurement string
+  Precision Precision
+  // Max number of lines per write.
+  BatchSize int
+  // Hold lines until this long after the previous write, unless BatchSize lines are pending. They
+  // are then written even if no other reading is delivered.
+  FlushInterval time.Duration
+  // Where failed writes are kept, to be retried at the next delivery. Nil to drop them.
+  Buffer *diskqueue.Queue
+}
+
+// Sink writes readings as line protocol, with the time they were collected at.
+type Sink struct {
+  w writer
+  opts Options
+
+  // deliveries are serialized by the queue of the sink, but writes after FlushInterval happen on
+  // a timer.
+  mu sync.Mutex
+  pending []byte
+  pendingLines int
+  lastWrite time.Time
+  // writes the held lines once FlushInterval elapses, nil if no lines are held.
+  timer *time.Timer
+}
+
+func newSink(w writer, opts Options) *Sink {
+  return &Sink{w: w, opts: opts, lastWrite: time.Now()}
+}
+
+func (s *Sink) Deliver(ctx context.Context, readings []collector.SinkReading) error {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  for _, r := range readings {
+    s.pending = appendLines(s.pending, s.opts.Measurement, r, s.opts.Precision)
+    s.pendingLines += len(r.Temperatures)
+
+    if r.HasHumidity || r.HasBatteryLevel {
+      s.pendingLines += 1
+    }
+  }
+
+  if held := time.Since(s.lastWrite); s.pendingLines < s.opts.BatchSize && held < s.opts.FlushInterval {
+    if s.timer == nil {
+      s.timer = time.AfterFunc(s.opts.FlushInterval - held, s.flushHeld)
+    }
+
+    return nil
+  }
+
+  return s.flush(ctx)
+}
+
+// Write the lines held by FlushInterval, once it elapsed.
+func (s *Sink) flushHeld() {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  // written in the meantime.
+  if s.pendingLines == 0 {
+    return
+  }
+
+  ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+  defer cancel()
+
+  if err := s.flush(ctx); err != nil {
+    log.Warn().Err(err).Msg("influxdb: failed to write held lines")
+  }
+}
+
+// Write the buffered writes, oldest first, then the pending lines. Must be called with mu held.
+func (s *Sink) flush(ctx context.Context) error {
+  if s.timer != nil {
+    s.timer.Stop()
+    s.timer = nil
+  }
+
+  batches := splitLines(s.pending, s.opts.BatchSize)
+  s.pending, s.pendingLines, s.lastWrite = nil, 0, time.Now()
+
+  if err := s.retryBuffered(ctx); err != nil {
+    // keep the order of the writes.
+    s.buffer(batches)
+    return fmt.Errorf("failed to write buffered lines: %w", err)
+  }
+
+  for i, batch := range batches {
+    err := s.w.write(ctx, batch)
+
+    if errors.Is(err, errRejected) {
+      // retrying won't help, but the next batches might be fine.
+      log.Warn().Err(err).Msg("influxdb: dropping write rejected by the server")
+    } else if err != nil {
+      s.buffer(batches[i:])
+      return err
+    }
+  }
+
+  return nil
+}
+
+func (s *Sink) retryBuffered(ctx context.Context) error {
+  if s.opts.Buffer == nil {
+    return nil
+  }
+
+  for {
+    record, ok, err := s.opts.Buffer.Peek()
+
+    switch {
+    case err != nil:
+      // rather than blocking the writes behind it.
+      log.Warn().Err(err).Msg("influxdb: dropping unreadable buffered write")
+    case !ok:
+      return nil
+    default:
+      if err := s.retry(ctx, record); err != nil {
+        return err
+      }
+    }
+
+    if err := s.opts.Buffer.Pop(); err != nil {
+      return err
+    }
+  }
+}
+
+// Write a buffered write again. Writes which can't succeed are dropped.
+func (s *Sink) retry(ctx context.Context, record []byte) error {
+  precision, body, _ := bytes.Cut(record, []byte("\n"))
+
+  if Precision(precision) != s.opts.Precision {
+    log.Warn().
+      Str("Precision", string(precision)).
+      Msg("influxdb: dropping buffered write with a different precision")
+    return nil
+  }
+
+  if err := s.w.write(ctx, body); errors.Is(err, errRejected) {
+    log.Warn().Err(err).Msg("influxdb: dropping buffered write rejected by the server")
+  } else if err != nil {
+    return err
+  }
+
+  return nil
+}
+
+// Keep failed writes in the buffer, if any.
+func (s *Sink) buffer(batches [][]byte) {
+  if s.opts.Buffer == nil {
+    return
+  }
+
+  for _, batch := range batches {
+    record := append([]byte(string(s.opts.Precision) + "\n"), batch...)
+    dropped, err := s.opts.Buffer.Push(record)
+
+    if err != nil {
+      log.Error().Err(err).Msg("influxdb: failed to buffer write")
+    } else if dropped > 0 {
+      log.Warn().Int("Dropped", dropped).Msg("influxdb: buffer is full, dropped the oldest writes")
+    }
+  }
+
+  log.Debug().
+    Int("Writes", s.opts.Buffer.Len()).
+    Int64("Bytes", s.opts.Buffer.Size()).
+    Msg("influxdb: buffered failed writes for retry")
+}
+
+// Split lines in batches of at most n lines.
+func splitLines(lines []byte, n int) (batches [][]byte) {
+  for len(lines) > 0 {
+    end, count := 0, 0
+
+    for end < len(lines) && count < n {
+      next := bytes.IndexByte(lines[end:], '\n')
+
+      if next < 0 {
+        end = len(lines)
+        break
+      }
+
+      end += next + 1
+      count += 1
+    }
+
+    batches = append(batches, lines[:end])
+    lines = lines[end:]
+  }
+
+  return batches
+}
+
+// *io.Closer: write the lines held by FlushInterval.
+func (s *Sink) Close() error {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if s.timer != nil {
+    s.timer.Stop()
+    s.timer = nil
+  }
+
+  if s.pendingLines == 0 {
+    return nil
+  }
+
+  ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+  defer cancel()
+
+  return s.flush(ctx)
+}
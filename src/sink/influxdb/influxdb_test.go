@@ -0,0 +1,138 @@
+package influxdb
+
+import (
+  "context"
+  "io"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "sync/atomic"
+  "testing"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/internal/testutil"
+)
+
+var reading = collector.SinkReading{
+  Device: testutil.FakeDevice("bbq grill"),
+  Sample: model.Sample{
+    Reading: device.Reading{
+      Temperatures: []float32{21.5, 80},
+      RelativeHumidity: 45.1,
+      HasHumidity: true,
+      BatteryLevel: 80,
+      HasBatteryLevel: true,
+    },
+    Time: time.Unix(1700000000, 123456789),
+  },
+}
+
+func TestAppendLines(t *testing.T) {
+  got := string(appendLines(nil, "inkbird", reading, PrecisionMilliseconds))
+  want := `inkbird,addr=49:42:08:00:12:34,device=bbq\ grill,probe=0 temperature=21.5 1700000000123
+inkbird,addr=49:42:08:00:12:34,device=bbq\ grill,probe=1 temperature=80 1700000000123
+inkbird,addr=49:42:08:00:12:34,device=bbq\ grill humidity=45.1,battery=80i 1700000000123
+`
+
+  if got != want {
+    t.Errorf("got:\n%s\nwanted:\n%s", got, want)
+  }
+}
+
+func TestSinkRetriesBufferedWrites(t *testing.T) {
+  var failing atomic.Bool
+  bodies := make(chan string, 16)
+
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Path != "/api/v2/write" || r.URL.Query().Get("bucket") != "home" || r.Header.Get("Authorization") != "Token secret" {
+      t.Errorf("unexpected request %v %v", r.URL, r.Header)
+    }
+
+    if failing.Load() {
+      w.WriteHeader(http.StatusServiceUnavailable)
+      return
+    }
+
+    body, _ := io.ReadAll(r.Body)
+    bodies <- string(body)
+    w.WriteHeader(http.StatusNoContent)
+  }))
+  defer server.Close()
+
+  spec := device.MustParseDeviceSpec("url=" + server.URL + ", org=me, bucket=home, token=secret, " +
+    "precision=s, batch-size=2, buffer-dir=" + t.TempDir())
+  s, err := (&Factory{}).FromSpec(spec)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  failing.Store(true)
+
+  if err := s.Deliver(context.Background(), []collector.SinkReading{reading}); err == nil {
+    t.Fatal("delivery succeeded while the server is failing")
+  }
+
+  failing.Store(false)
+
+  // buffered writes go first, then the new lines, in batches of 2 lines.
+  second := reading
+  second.Time = reading.Time.Add(time.Minute)
+
+  if err := s.Deliver(context.Background(), []collector.SinkReading{second}); err != nil {
+    t.Fatal(err)
+  }
+
+  close(bodies)
+
+  var got []string
+
+  for body := range bodies {
+    got = append(got, body)
+  }
+
+  if len(got) != 4 {
+    t.Fatalf("got %d writes, wanted 4: %q", len(got), got)
+  }
+
+  for i, want := range []string{"1700000000", "1700000000", "1700000060", "1700000060"} {
+    if ts := got[i][len(got[i]) - len(want) - 1:len(got[i]) - 1]; ts != want {
+      t.Errorf("write %d: got timestamp %v, wanted %v", i, ts, want)
+    }
+  }
+}
+
+func TestSinkWritesHeldLines(t *testing.T) {
+  bodies := make(chan string, 1)
+
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    body, _ := io.ReadAll(r.Body)
+    bodies <- string(body)
+    w.WriteHeader(http.StatusNoContent)
+  }))
+  defer server.Close()
+
+  spec := device.MustParseDeviceSpec("url=" + server.URL + ", org=me, bucket=home, flush-interval=50ms")
+  s, err := (&Factory{}).FromSpec(spec)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if err := s.Deliver(context.Background(), []collector.SinkReading{reading}); err != nil {
+    t.Fatal(err)
+  }
+
+  // without any other delivery.
+  select {
+  case body := <-bodies:
+    if strings.Count(body, "\n") != 3 {
+      t.Errorf("got write %q, wanted 3 lines", body)
+    }
+  case <-time.After(5 * time.Second):
+    t.Fatal("held lines were never written")
+  }
+}
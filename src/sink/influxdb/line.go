@@ -0,0 +1,102 @@
+package influxdb
+
+import (
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+)
+
+// Precision of the timestamps written.
+type Precision string
+
+const (
+  PrecisionNanoseconds Precision = "ns"
+  PrecisionMicroseconds Precision = "us"
+  PrecisionMilliseconds Precision = "ms"
+  PrecisionSeconds Precision = "s"
+)
+
+func (p Precision) unit() time.Duration {
+  switch p {
+  case PrecisionMicroseconds:
+    return time.Microsecond
+  case PrecisionMilliseconds:
+    return time.Millisecond
+  case PrecisionSeconds:
+    return time.Second
+  default:
+    return time.Nanosecond
+  }
+}
+
+// The name of the precision in the v1 API, which differs for nanoseconds and microseconds.
+func (p Precision) v1() string {
+  switch p {
+  case PrecisionNanoseconds:
+    return "n"
+  case PrecisionMicroseconds:
+    return "u"
+  default:
+    return string(p)
+  }
+}
+
+var (
+  measurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+  tagEscaper = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+)
+
+// Encode a reading as lines of line protocol: one for each probe, tagged with its index, and one
+// for the values of the device itself, if any.
+//
+//   inkbird,addr=49:42:08:00:12:34,device=bbq,probe=0 temperature=21.5 1700000000000000000
+//   inkbird,addr=49:42:08:00:12:34,device=bbq humidity=45,battery=80i 1700000000000000000
+func appendLines(b []byte, measurement string, r collector.SinkReading, precision Precision) []byte {
+  // tags are sorted by key, as recommended for performance.
+  tags := ",addr=" + tagEscaper.Replace(r.Device.Addr().String()) +
+    ",device=" + tagEscaper.Replace(r.Device.Name())
+  ts := strconv.FormatInt(r.Time.UnixNano() / int64(precision.unit()), 10)
+  measurement = measurementEscaper.Replace(measurement)
+
+  for i, temp := range r.Temperatures {
+    b = append(b, measurement...)
+    b = append(b, tags...)
+    b = append(b, ",probe="...)
+    b = strconv.AppendInt(b, int64(i), 10)
+    b = append(b, " temperature="...)
+    b = strconv.AppendFloat(b, float64(temp), 'f', -1, 32)
+    b = append(b, ' ')
+    b = append(b, ts...)
+    b = append(b, '\n')
+  }
+
+  if !r.HasHumidity && !r.HasBatteryLevel {
+    return b
+  }
+
+  b = append(b, measurement...)
+  b = append(b, tags...)
+  sep := byte(' ')
+
+  if r.HasHumidity {
+    b = append(b, sep)
+    b = append(b, "humidity="...)
+    b = strconv.AppendFloat(b, float64(r.RelativeHumidity), 'f', -1, 32)
+    sep = ','
+  }
+
+  if r.HasBatteryLevel {
+    b = append(b, sep)
+    b = append(b, "battery="...)
+    b = strconv.AppendInt(b, int64(r.BatteryLevel), 10)
+    b = append(b, 'i')
+  }
+
+  b = append(b, ' ')
+  b = append(b, ts...)
+  b = append(b, '\n')
+
+  return b
+}
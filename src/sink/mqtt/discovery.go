@@ -0,0 +1,133 @@
+package mqtt
+
+import (
+  "fmt"
+  "strings"
+
+  "github.com/robertof/go-inkbird-exporter/device"
+)
+
+// The entities reported by a device, which decide its discovery configs.
+type discoveryShape struct {
+  probes int
+  humidity bool
+  battery bool
+}
+
+func shapeOf(r device.Reading) discoveryShape {
+  return discoveryShape{
+    probes: len(r.Temperatures),
+    humidity: r.HasHumidity,
+    battery: r.HasBatteryLevel,
+  }
+}
+
+// https://www.home-assistant.io/integrations/sensor.mqtt/
+type discoveryConfig struct {
+  Name string `json:"name"`
+  UniqueID string `json:"unique_id"`
+  ObjectID string `json:"object_id"`
+  StateTopic string `json:"state_topic"`
+  ValueTemplate string `json:"value_template"`
+  DeviceClass string `json:"device_class"`
+  StateClass string `json:"state_class"`
+  UnitOfMeasurement string `json:"unit_of_measurement"`
+  EntityCategory string `json:"entity_category,omitempty"`
+  ExpireAfter int `json:"expire_after,omitempty"`
+  Availability []discoveryAvailability `json:"availability"`
+  AvailabilityMode string `json:"availability_mode"`
+  Device discoveryDevice `json:"device"`
+}
+
+type discoveryAvailability struct {
+  Topic string `json:"topic"`
+}
+
+type discoveryDevice struct {
+  Identifiers []string `json:"identifiers"`
+  Connections [][2]string `json:"connections"`
+  Name string `json:"name"`
+  Manufacturer string `json:"manufacturer"`
+}
+
+// A config to publish on topic. A nil config removes the entity.
+type discoveryMessage struct {
+  topic string
+  config *discoveryConfig
+}
+
+// The discovery configs of a device reporting the given entities, along with removals of the
+// entities of the previously published shape which are gone.
+func (s *Sink) discoveryConfigs(dev device.Device, shape, previous discoveryShape) (out []discoveryMessage) {
+  nodeID := "inkbird_" + strings.ReplaceAll(strings.ToLower(dev.Addr().String()), ":", "")
+
+  entity := func(key, name, template, class, unit string) discoveryMessage {
+    return discoveryMessage{
+      topic: s.opts.DiscoveryPrefix + "/sensor/" + nodeID + "/" + key + "/config",
+      config: &discoveryConfig{
+        Name: name,
+        UniqueID: nodeID + "_" + key,
+        ObjectID: topicSafe(dev.Name()) + "_" + key,
+        StateTopic: s.deviceTopic(dev, "state"),
+        ValueTemplate: template,
+        DeviceClass: class,
+        StateClass: "measurement",
+        UnitOfMeasurement: unit,
+        ExpireAfter: int(s.opts.ExpireAfter.Seconds()),
+        Availability: []discoveryAvailability{
+          {Topic: s.statusTopic()},
+          {Topic: s.deviceTopic(dev, "availability")},
+        },
+        AvailabilityMode: "all",
+        Device: discoveryDevice{
+          Identifiers: []string{nodeID},
+          Connections: [][2]string{{"mac", strings.ToLower(dev.Addr().String())}},
+          Name: dev.Name(),
+          Manufacturer: "Inkbird",
+        },
+      },
+    }
+  }
+
+  removal := func(m discoveryMessage) discoveryMessage {
+    m.config = nil
+    return m
+  }
+
+  probe := func(i int) discoveryMessage {
+    template := fmt.Sprintf("{{ value_json.temperatures[%d] }}", i)
+
+    if i == 0 {
+      return entity("temperature", "Temperature", template, "temperature", "°C")
+    }
+
+    return entity(fmt.Sprintf("temperature_%d", i + 1), fmt.Sprintf("Temperature %d", i + 1), template, "temperature", "°C")
+  }
+
+  for i := 0; i < shape.probes; i += 1 {
+    out = append(out, probe(i))
+  }
+
+  for i := shape.probes; i < previous.probes; i += 1 {
+    out = append(out, removal(probe(i)))
+  }
+
+  humidity := entity("humidity", "Humidity", "{{ value_json.humidity }}", "humidity", "%")
+
+  if shape.humidity {
+    out = append(out, humidity)
+  } else if previous.humidity {
+    out = append(out, removal(humidity))
+  }
+
+  battery := entity("battery", "Battery", "{{ value_json.battery }}", "battery", "%")
+  battery.config.EntityCategory = "diagnostic"
+
+  if shape.battery {
+    out = append(out, battery)
+  } else if previous.battery {
+    out = append(out, removal(battery))
+  }
+
+  return out
+}
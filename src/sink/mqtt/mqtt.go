@@ -0,0 +1,481 @@
+// Package mqtt publishes readings to an MQTT broker, along with Home Assistant discovery configs.
+//
+// Topics, under the topic prefix:
+//
+//   - <prefix>/status: "online" or "offline" (retained, also the last will of the connection).
+//   - <prefix>/<device>/state: the last reading of the device, as a sink.Reading.
+//   - <prefix>/<device>/availability: "online" once the device has been read, "offline" once the
+//     exporter stops (retained).
+package mqtt
+
+import (
+  "context"
+  "crypto/tls"
+  "crypto/x509"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/url"
+  "os"
+  "regexp"
+  "strings"
+  "sync"
+  "time"
+
+  paho "github.com/eclipse/paho.mqtt.golang"
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/sink"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  specFieldBroker = "broker"
+  specFieldClientID = "client-id"
+  specFieldUsername = "username"
+  specFieldPassword = "password"
+  specFieldPasswordFile = "password-file"
+  specFieldTopicPrefix = "topic-prefix"
+  specFieldQoS = "qos"
+  specFieldRetain = "retain"
+  specFieldDiscovery = "discovery"
+  specFieldDiscoveryPrefix = "discovery-prefix"
+  specFieldExpireAfter = "expire-after"
+  specFieldTLSCA = "tls-ca"
+  specFieldTLSCert = "tls-cert"
+  specFieldTLSKey = "tls-key"
+  specFieldTLSInsecure = "tls-insecure"
+  specFieldTimeout = "timeout"
+
+  payloadOnline = "online"
+  payloadOffline = "offline"
+)
+
+var schema = device.Schema{
+  {
+    Name: specFieldBroker,
+    Type: device.ParamTypeString,
+    Required: true,
+    Description: "URL of the broker, e.g. 'tcp://broker:1883' or 'ssl://broker:8883'",
+  },
+  {
+    Name: specFieldClientID,
+    Type: device.ParamTypeString,
+    Default: "inkbird-exporter",
+    Description: "MQTT client ID. Must be unique among the clients of the broker",
+  },
+  {
+    Name: specFieldUsername,
+    Type: device.ParamTypeString,
+    Description: "Username to authenticate with",
+  },
+  {
+    Name: specFieldPassword,
+    Type: device.ParamTypeString,
+    Description: "Password to authenticate with",
+  },
+  {
+    Name: specFieldPasswordFile,
+    Type: device.ParamTypeString,
+    Description: "File to read the password from, instead of passing it on the command line",
+  },
+  {
+    Name: specFieldTopicPrefix,
+    Type: device.ParamTypeString,
+    Default: "inkbird",
+    Description: "Prefix of the state and availability topics",
+  },
+  {
+    Name: specFieldQoS,
+    Type: device.ParamTypeInt,
+    Default: "1",
+    AllowedValues: []string{"0", "1", "2"},
+    Description: "QoS of published messages",
+  },
+  {
+    Name: specFieldRetain,
+    Type: device.ParamTypeBool,
+    Default: "true",
+    Description: "Retain the state of each device on the broker",
+  },
+  {
+    Name: specFieldDiscovery,
+    Type: device.ParamTypeBool,
+    Default: "true",
+    Description: "Publish Home Assistant MQTT discovery configs for every device",
+  },
+  {
+    Name: specFieldDiscoveryPrefix,
+    Type: device.ParamTypeString,
+    Default: "homeassistant",
+    Description: "Discovery prefix of Home Assistant",
+  },
+  {
+    Name: specFieldExpireAfter,
+    Type: device.ParamTypeDuration,
+    Description: "If set, Home Assistant marks entities as unavailable when no reading is received for this long",
+  },
+  {
+    Name: specFieldTLSCA,
+    Type: device.ParamTypeString,
+    Description: "PEM file with the CA certificates used to verify the broker, instead of the system ones",
+  },
+  {
+    Name: specFieldTLSCert,
+    Type: device.ParamTypeString,
+    Description: "PEM file with the client certificate, for brokers requiring one. Requires tls-key",
+  },
+  {
+    Name: specFieldTLSKey,
+    Type: device.ParamTypeString,
+    Description: "PEM file with the key of the client certificate",
+  },
+  {
+    Name: specFieldTLSInsecure,
+    Type: device.ParamTypeBool,
+    Default: "false",
+    Description: "Don't verify the certificate of the broker",
+  },
+  {
+    Name: specFieldTimeout,
+    Type: device.ParamTypeDuration,
+    Default: "10s",
+    Description: "Timeout for connecting and publishing",
+  },
+}
+
+type Factory struct{}
+
+func (f *Factory) Schema() device.Schema {
+  return schema
+}
+
+func (f *Factory) FromSpec(spec device.DeviceSpec) (collector.Sink, error) {
+  params, err := f.Schema().Parse(spec)
+
+  if err != nil {
+    return nil, err
+  }
+
+  opts := Options{
+    Broker: params.String(specFieldBroker),
+    ClientID: params.String(specFieldClientID),
+    Username: params.String(specFieldUsername),
+    Password: params.String(specFieldPassword),
+    TopicPrefix: strings.TrimSuffix(params.String(specFieldTopicPrefix), "/"),
+    QoS: byte(params.Int(specFieldQoS)),
+    Retain: params.Bool(specFieldRetain),
+    ExpireAfter: params.Duration(specFieldExpireAfter),
+    Timeout: params.Duration(specFieldTimeout),
+  }
+
+  if u, err := url.Parse(opts.Broker); err != nil || u.Scheme == "" || u.Host == "" {
+    return nil, fmt.Errorf("%w: %s must be a URL such as tcp://broker:1883", device.ErrInvalidSpec, specFieldBroker)
+  }
+
+  if params.Bool(specFieldDiscovery) {
+    opts.DiscoveryPrefix = strings.TrimSuffix(params.String(specFieldDiscoveryPrefix), "/")
+  }
+
+  if file := params.String(specFieldPasswordFile); file != "" {
+    data, err := os.ReadFile(file)
+
+    if err != nil {
+      return nil, fmt.Errorf("%w: %s: %w", device.ErrInvalidSpec, specFieldPasswordFile, err)
+    }
+
+    opts.Password = strings.TrimRight(string(data), "\r\n")
+  }
+
+  opts.TLS, err = tlsConfig(params)
+
+  if err != nil {
+    return nil, err
+  }
+
+  return New(opts), nil
+}
+
+func tlsConfig(params device.Params) (*tls.Config, error) {
+  ca, cert, key := params.String(specFieldTLSCA), params.String(specFieldTLSCert), params.String(specFieldTLSKey)
+  insecure := params.Bool(specFieldTLSInsecure)
+
+  if ca == "" && cert == "" && key == "" && !insecure {
+    // the system defaults are used for ssl:// brokers.
+    return nil, nil
+  }
+
+  config := &tls.Config{InsecureSkipVerify: insecure}
+
+  if ca != "" {
+    pem, err := os.ReadFile(ca)
+
+    if err != nil {
+      return nil, fmt.Errorf("%w: %s: %w", device.ErrInvalidSpec, specFieldTLSCA, err)
+    }
+
+    config.RootCAs = x509.NewCertPool()
+
+    if !config.RootCAs.AppendCertsFromPEM(pem) {
+      return nil, fmt.Errorf("%w: %s: no certificates found in %v", device.ErrInvalidSpec, specFieldTLSCA, ca)
+    }
+  }
+
+  if (cert == "") != (key == "") {
+    return nil, fmt.Errorf("%w: %s and %s must be set together", device.ErrInvalidSpec, specFieldTLSCert, specFieldTLSKey)
+  }
+
+  if cert != "" {
+    pair, err := tls.LoadX509KeyPair(cert, key)
+
+    if err != nil {
+      return nil, fmt.Errorf("%w: %s: %w", device.ErrInvalidSpec, specFieldTLSCert, err)
+    }
+
+    config.Certificates = []tls.Certificate{pair}
+  }
+
+  return config, nil
+}
+
+// Options of the MQTT sink.
+type Options struct {
+  Broker string
+  ClientID string
+  Username, Password string
+  // Nil to use the system defaults.
+  TLS *tls.Config
+  TopicPrefix string
+  QoS byte
+  Retain bool
+  // Home Assistant discovery prefix. Empty disables discovery.
+  DiscoveryPrefix string
+  // Passed to Home Assistant as the expiry of the entities, if set.
+  ExpireAfter time.Duration
+  Timeout time.Duration
+}
+
+// Sink publishes readings to an MQTT broker. It connects on the first delivery and reconnects
+// automatically.
+type Sink struct {
+  opts Options
+  client paho.Client
+
+  connectOnce sync.Once
+  // completes once first connected, retrying until then.
+  connecting paho.Token
+
+  mu sync.Mutex
+  // devices whose availability has been published on the current connection.
+  available map[device.Device]bool
+  // discovery configs published on the current connection, by device.
+  discovered map[device.Device]discoveryShape
+}
+
+func New(opts Options) *Sink {
+  s := &Sink{
+    opts: opts,
+    available: make(map[device.Device]bool),
+    discovered: make(map[device.Device]discoveryShape),
+  }
+
+  clientOpts := paho.NewClientOptions().
+    AddBroker(opts.Broker).
+    SetClientID(opts.ClientID).
+    SetUsername(opts.Username).
+    SetPassword(opts.Password).
+    SetWill(s.statusTopic(), payloadOffline, 1, true).
+    SetConnectTimeout(opts.Timeout).
+    SetAutoReconnect(true).
+    SetConnectRetry(true).
+    SetOnConnectHandler(s.onConnect).
+    SetConnectionLostHandler(func(_ paho.Client, err error) {
+      log.Warn().Err(err).Str("Broker", opts.Broker).Msg("mqtt: connection lost, reconnecting")
+    })
+
+  if opts.TLS != nil {
+    clientOpts.SetTLSConfig(opts.TLS)
+  }
+
+  s.client = paho.NewClient(clientOpts)
+
+  return s
+}
+
+func (s *Sink) statusTopic() string {
+  return s.opts.TopicPrefix + "/status"
+}
+
+func (s *Sink) deviceTopic(dev device.Device, name string) string {
+  return s.opts.TopicPrefix + "/" + topicSafe(dev.Name()) + "/" + name
+}
+
+var unsafeTopicChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// Make a name usable as a topic level and as a Home Assistant object ID.
+func topicSafe(name string) string {
+  return unsafeTopicChars.ReplaceAllString(name, "_")
+}
+
+// Called on every (re)connection, from a goroutine of the client.
+func (s *Sink) onConnect(client paho.Client) {
+  log.Info().Str("Broker", s.opts.Broker).Msg("mqtt: connected")
+
+  // the broker might have lost retained messages: publish everything again.
+  s.mu.Lock()
+  s.available = make(map[device.Device]bool)
+  s.discovered = make(map[device.Device]discoveryShape)
+  s.mu.Unlock()
+
+  client.Publish(s.statusTopic(), 1, true, payloadOnline)
+
+  if s.opts.DiscoveryPrefix != "" {
+    // Home Assistant announces itself after restarting: publish the configs again then.
+    client.Subscribe(s.opts.DiscoveryPrefix + "/status", 1, func(_ paho.Client, msg paho.Message) {
+      if string(msg.Payload()) == payloadOnline {
+        s.mu.Lock()
+        s.discovered = make(map[device.Device]discoveryShape)
+        s.mu.Unlock()
+      }
+    })
+  }
+}
+
+// Wait for a token, bounded by the timeout and ctx.
+func (s *Sink) wait(ctx context.Context, token paho.Token) error {
+  select {
+  case <-token.Done():
+    return token.Error()
+  case <-ctx.Done():
+    return ctx.Err()
+  case <-time.After(s.opts.Timeout):
+    return errors.New("timed out waiting for the broker")
+  }
+}
+
+func (s *Sink) Deliver(ctx context.Context, readings []collector.SinkReading) error {
+  s.connectOnce.Do(func() {
+    log.Info().Str("Broker", s.opts.Broker).Msg("mqtt: connecting")
+    s.connecting = s.client.Connect()
+  })
+
+  if !s.client.IsConnectionOpen() {
+    // the first connection might still be in progress.
+    if err := s.wait(ctx, s.connecting); err != nil {
+      return fmt.Errorf("failed to connect to %v: %w", s.opts.Broker, err)
+    }
+
+    if !s.client.IsConnectionOpen() {
+      return fmt.Errorf("not connected to %v", s.opts.Broker)
+    }
+  }
+
+  for _, r := range readings {
+    if err := s.deliver(ctx, r); err != nil {
+      return fmt.Errorf("failed to publish reading of %v: %w", r.Device, err)
+    }
+  }
+
+  return nil
+}
+
+func (s *Sink) deliver(ctx context.Context, r collector.SinkReading) error {
+  if s.opts.DiscoveryPrefix != "" {
+    if err := s.discover(ctx, r); err != nil {
+      return err
+    }
+  }
+
+  state, err := json.Marshal(sink.NewReading(r))
+
+  if err != nil {
+    return err
+  }
+
+  if err := s.wait(ctx, s.client.Publish(s.deviceTopic(r.Device, "state"), s.opts.QoS, s.opts.Retain, state)); err != nil {
+    return err
+  }
+
+  s.mu.Lock()
+  available := s.available[r.Device]
+  s.mu.Unlock()
+
+  if available {
+    return nil
+  }
+
+  if err := s.wait(ctx, s.client.Publish(s.deviceTopic(r.Device, "availability"), 1, true, payloadOnline)); err != nil {
+    return err
+  }
+
+  s.mu.Lock()
+  s.available[r.Device] = true
+  s.mu.Unlock()
+
+  return nil
+}
+
+// Publish the discovery configs of the device, unless already published with the same entities.
+func (s *Sink) discover(ctx context.Context, r collector.SinkReading) error {
+  shape := shapeOf(r.Reading)
+
+  s.mu.Lock()
+  published, ok := s.discovered[r.Device]
+  s.mu.Unlock()
+
+  if ok && published == shape {
+    return nil
+  }
+
+  for _, c := range s.discoveryConfigs(r.Device, shape, published) {
+    var payload []byte
+
+    if c.config != nil {
+      var err error
+
+      if payload, err = json.Marshal(c.config); err != nil {
+        return err
+      }
+    }
+
+    // an empty payload removes an entity which is no longer reported.
+    if err := s.wait(ctx, s.client.Publish(c.topic, 1, true, payload)); err != nil {
+      return err
+    }
+  }
+
+  s.mu.Lock()
+  s.discovered[r.Device] = shape
+  s.mu.Unlock()
+
+  return nil
+}
+
+// *io.Closer: mark every device offline and disconnect.
+func (s *Sink) Close() error {
+  if !s.client.IsConnectionOpen() {
+    return nil
+  }
+
+  s.mu.Lock()
+  devices := make([]device.Device, 0, len(s.available))
+
+  for dev := range s.available {
+    devices = append(devices, dev)
+  }
+
+  s.mu.Unlock()
+
+  ctx := context.Background()
+  var errs []error
+
+  for _, dev := range devices {
+    errs = append(errs, s.wait(ctx, s.client.Publish(s.deviceTopic(dev, "availability"), 1, true, payloadOffline)))
+  }
+
+  errs = append(errs, s.wait(ctx, s.client.Publish(s.statusTopic(), 1, true, payloadOffline)))
+
+  s.client.Disconnect(uint(s.opts.Timeout.Milliseconds()))
+
+  return errors.Join(errs...)
+}
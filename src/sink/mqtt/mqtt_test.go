@@ -0,0 +1,171 @@
+package mqtt
+
+import (
+  "context"
+  "encoding/json"
+  "net"
+  "testing"
+  "time"
+
+  "github.com/eclipse/paho.mqtt.golang/packets"
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/internal/testutil"
+)
+
+// A minimal broker, accepting a single client and recording its publishes.
+func fakeBroker(t *testing.T) (url string, publishes <-chan *packets.PublishPacket, will <-chan *packets.ConnectPacket) {
+  l, err := net.Listen("tcp", "127.0.0.1:0")
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  t.Cleanup(func() { l.Close() })
+
+  pubCh := make(chan *packets.PublishPacket, 64)
+  connCh := make(chan *packets.ConnectPacket, 1)
+
+  go func() {
+    conn, err := l.Accept()
+
+    if err != nil {
+      return
+    }
+
+    defer conn.Close()
+
+    for {
+      p, err := packets.ReadPacket(conn)
+
+      if err != nil {
+        return
+      }
+
+      var reply packets.ControlPacket
+
+      switch p := p.(type) {
+      case *packets.ConnectPacket:
+        connCh <- p
+        reply = packets.NewControlPacket(packets.Connack)
+      case *packets.PublishPacket:
+        pubCh <- p
+
+        if p.Qos == 1 {
+          ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+          ack.MessageID = p.MessageID
+          reply = ack
+        }
+      case *packets.SubscribePacket:
+        ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+        ack.MessageID = p.MessageID
+        ack.ReturnCodes = p.Qoss
+        reply = ack
+      case *packets.PingreqPacket:
+        reply = packets.NewControlPacket(packets.Pingresp)
+      case *packets.DisconnectPacket:
+        return
+      }
+
+      if reply != nil {
+        if err := reply.Write(conn); err != nil {
+          return
+        }
+      }
+    }
+  }()
+
+  return "tcp://" + l.Addr().String(), pubCh, connCh
+}
+
+func TestSink(t *testing.T) {
+  broker, publishes, connects := fakeBroker(t)
+
+  s := New(Options{
+    Broker: broker,
+    ClientID: "test",
+    TopicPrefix: "inkbird",
+    QoS: 1,
+    Retain: true,
+    DiscoveryPrefix: "homeassistant",
+    Timeout: time.Second,
+  })
+
+  reading := collector.SinkReading{
+    Device: testutil.FakeDevice("bbq grill"),
+    Sample: model.Sample{
+      Reading: device.Reading{
+        Temperatures: []float32{21.5, 80},
+        RelativeHumidity: 45,
+        HasHumidity: true,
+      },
+      Time: time.Unix(1700000000, 0),
+    },
+  }
+
+  // the first delivery connects, and must not be lost.
+  if err := s.Deliver(context.Background(), []collector.SinkReading{reading}); err != nil {
+    t.Fatal(err)
+  }
+
+  connect := <-connects
+
+  if !connect.WillFlag || connect.WillTopic != "inkbird/status" || string(connect.WillMessage) != "offline" {
+    t.Errorf("unexpected last will: %v %q", connect.WillTopic, connect.WillMessage)
+  }
+
+  got := make(map[string][]byte)
+
+  for len(got) < 6 {
+    select {
+    case p := <-publishes:
+      if !p.Retain {
+        t.Errorf("%v: not retained", p.TopicName)
+      }
+
+      got[p.TopicName] = p.Payload
+    case <-time.After(time.Second):
+      t.Fatalf("timed out waiting for publishes, got %v", len(got))
+    }
+  }
+
+  if status := string(got["inkbird/status"]); status != "online" {
+    t.Errorf("status: got %q, wanted online", status)
+  }
+
+  if availability := string(got["inkbird/bbq_grill/availability"]); availability != "online" {
+    t.Errorf("availability: got %q, wanted online", availability)
+  }
+
+  var state map[string]any
+
+  if err := json.Unmarshal(got["inkbird/bbq_grill/state"], &state); err != nil {
+    t.Fatalf("state: %v", err)
+  }
+
+  if state["humidity"] != 45.0 || state["device"] != "bbq grill" {
+    t.Errorf("unexpected state %v", state)
+  }
+
+  for topic, want := range map[string]string{
+    "homeassistant/sensor/inkbird_494208001234/temperature/config": "temperature",
+    "homeassistant/sensor/inkbird_494208001234/temperature_2/config": "temperature",
+    "homeassistant/sensor/inkbird_494208001234/humidity/config": "humidity",
+  } {
+    var config discoveryConfig
+
+    if err := json.Unmarshal(got[topic], &config); err != nil {
+      t.Errorf("%v: %v", topic, err)
+      continue
+    }
+
+    if config.DeviceClass != want || config.StateTopic != "inkbird/bbq_grill/state" {
+      t.Errorf("%v: unexpected config %+v", topic, config)
+    }
+  }
+
+  if _, ok := got["homeassistant/sensor/inkbird_494208001234/battery/config"]; ok {
+    t.Error("battery entity published for a device without battery level")
+  }
+}
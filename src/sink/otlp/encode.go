@@ -0,0 +1,294 @@
+package otlp
+
+import (
+  "math"
+  "sort"
+  "time"
+
+  dto "github.com/prometheus/client_model/go"
+  "google.golang.org/protobuf/encoding/protowire"
+)
+
+// An attribute, with either a string or an integer value.
+type attribute struct {
+  key string
+  value any
+}
+
+func sortAttributes(attrs []attribute) []attribute {
+  sort.Slice(attrs, func(i, j int) bool { return attrs[i].key < attrs[j].key })
+  return attrs
+}
+
+type metricKind uint8
+
+const (
+  kindGauge metricKind = iota
+  // monotonic, cumulative sum.
+  kindCounter
+  kindHistogram
+  kindSummary
+)
+
+// A data point of any kind: only the fields relevant to the kind of its metric are encoded.
+type dataPoint struct {
+  attrs []attribute
+  start, time time.Time
+  value float64
+  // histograms and summaries.
+  count uint64
+  sum float64
+  // histograms: explicit bounds and the (non-cumulative) counts of each bucket, including +Inf.
+  bounds []float64
+  counts []uint64
+  // summaries: quantile and value pairs.
+  quantiles [][2]float64
+}
+
+type metric struct {
+  name, description, unit string
+  kind metricKind
+  points []dataPoint
+}
+
+// Convert metric families gathered from Prometheus collectors. Metrics without a timestamp get now,
+// and cumulative metrics start at start.
+func appendFamilies(out []metric, families []*dto.MetricFamily, start, now time.Time) []metric {
+  for _, family := range families {
+    m := metric{name: family.GetName(), description: family.GetHelp()}
+
+    switch family.GetType() {
+    case dto.MetricType_COUNTER:
+      m.kind = kindCounter
+    case dto.MetricType_HISTOGRAM:
+      m.kind = kindHistogram
+    case dto.MetricType_SUMMARY:
+      m.kind = kindSummary
+    default:
+      m.kind = kindGauge
+    }
+
+    for _, pm := range family.GetMetric() {
+      p := dataPoint{start: start, time: now}
+
+      if pm.TimestampMs != nil {
+        p.time = time.UnixMilli(pm.GetTimestampMs())
+      }
+
+      for _, l := range pm.GetLabel() {
+        p.attrs = append(p.attrs, attribute{l.GetName(), l.GetValue()})
+      }
+
+      switch m.kind {
+      case kindCounter:
+        p.value = pm.GetCounter().GetValue()
+      case kindGauge:
+        if pm.Gauge != nil {
+          p.value = pm.GetGauge().GetValue()
+        } else {
+          p.value = pm.GetUntyped().GetValue()
+        }
+      case kindHistogram:
+        h := pm.GetHistogram()
+        p.count, p.sum = h.GetSampleCount(), h.GetSampleSum()
+        prev := uint64(0)
+
+        for _, b := range h.GetBucket() {
+          if math.IsInf(b.GetUpperBound(), 1) {
+            continue
+          }
+
+          p.bounds = append(p.bounds, b.GetUpperBound())
+          p.counts = append(p.counts, b.GetCumulativeCount() - prev)
+          prev = b.GetCumulativeCount()
+        }
+
+        p.counts = append(p.counts, h.GetSampleCount() - prev)
+      case kindSummary:
+        s := pm.GetSummary()
+        p.count, p.sum = s.GetSampleCount(), s.GetSampleSum()
+
+        for _, q := range s.GetQuantile() {
+          p.quantiles = append(p.quantiles, [2]float64{q.GetQuantile(), q.GetValue()})
+        }
+      }
+
+      m.points = append(m.points, p)
+    }
+
+    out = append(out, m)
+  }
+
+  return out
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+  b = protowire.AppendTag(b, num, protowire.BytesType)
+  return protowire.AppendBytes(b, msg)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+  b = protowire.AppendTag(b, num, protowire.BytesType)
+  return protowire.AppendString(b, s)
+}
+
+func appendFixed64(b []byte, num protowire.Number, v uint64) []byte {
+  b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+  return protowire.AppendFixed64(b, v)
+}
+
+func appendDouble(b []byte, num protowire.Number, f float64) []byte {
+  return appendFixed64(b, num, math.Float64bits(f))
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+  b = protowire.AppendTag(b, num, protowire.VarintType)
+  return protowire.AppendVarint(b, v)
+}
+
+func timeNanos(t time.Time) uint64 {
+  if t.IsZero() {
+    return 0
+  }
+
+  return uint64(t.UnixNano())
+}
+
+// KeyValue { string key = 1; AnyValue value = 2; }
+// AnyValue { oneof { string string_value = 1; int64 int_value = 3; } }
+func appendAttribute(b []byte, num protowire.Number, a attribute) []byte {
+  var value []byte
+
+  switch v := a.value.(type) {
+  case int64:
+    value = appendVarint(value, 3, uint64(v))
+  default:
+    value = appendString(value, 1, v.(string))
+  }
+
+  var kv []byte
+  kv = appendString(kv, 1, a.key)
+  kv = appendMessage(kv, 2, value)
+
+  return appendMessage(b, num, kv)
+}
+
+// NumberDataPoint { attributes = 7; start_time_unix_nano = 2; time_unix_nano = 3; as_double = 4; }
+// HistogramDataPoint { attributes = 9; start = 2; time = 3; count = 4; sum = 5;
+//   bucket_counts = 6; explicit_bounds = 7; }
+// SummaryDataPoint { attributes = 7; start = 2; time = 3; count = 4; sum = 5; quantile_values = 6; }
+func encodeDataPoint(kind metricKind, p dataPoint) (b []byte) {
+  attrsField := protowire.Number(7)
+
+  if kind == kindHistogram {
+    attrsField = 9
+  }
+
+  for _, a := range p.attrs {
+    b = appendAttribute(b, attrsField, a)
+  }
+
+  if kind != kindGauge {
+    b = appendFixed64(b, 2, timeNanos(p.start))
+  }
+
+  b = appendFixed64(b, 3, timeNanos(p.time))
+
+  switch kind {
+  case kindGauge, kindCounter:
+    b = appendDouble(b, 4, p.value)
+  case kindHistogram:
+    b = appendFixed64(b, 4, p.count)
+    b = appendDouble(b, 5, p.sum)
+
+    var counts, bounds []byte
+
+    for _, c := range p.counts {
+      counts = protowire.AppendFixed64(counts, c)
+    }
+
+    for _, bound := range p.bounds {
+      bounds = protowire.AppendFixed64(bounds, math.Float64bits(bound))
+    }
+
+    b = appendMessage(b, 6, counts)
+    b = appendMessage(b, 7, bounds)
+  case kindSummary:
+    b = appendFixed64(b, 4, p.count)
+    b = appendDouble(b, 5, p.sum)
+
+    for _, q := range p.quantiles {
+      var v []byte
+      v = appendDouble(v, 1, q[0])
+      v = appendDouble(v, 2, q[1])
+      b = appendMessage(b, 6, v)
+    }
+  }
+
+  return b
+}
+
+// Metric { name = 1; description = 2; unit = 3; gauge = 5; sum = 7; histogram = 9; summary = 11; }
+// Gauge { data_points = 1; }
+// Sum { data_points = 1; aggregation_temporality = 2; is_monotonic = 3; }
+// Histogram { data_points = 1; aggregation_temporality = 2; }
+// Summary { data_points = 1; }
+func encodeMetric(m metric) (b []byte) {
+  b = appendString(b, 1, m.name)
+
+  if m.description != "" {
+    b = appendString(b, 2, m.description)
+  }
+
+  if m.unit != "" {
+    b = appendString(b, 3, m.unit)
+  }
+
+  var data []byte
+
+  for _, p := range m.points {
+    data = appendMessage(data, 1, encodeDataPoint(m.kind, p))
+  }
+
+  const temporalityCumulative = 2
+
+  switch m.kind {
+  case kindGauge:
+    return appendMessage(b, 5, data)
+  case kindCounter:
+    data = appendVarint(data, 2, temporalityCumulative)
+    data = appendVarint(data, 3, 1)
+    return appendMessage(b, 7, data)
+  case kindHistogram:
+    data = appendVarint(data, 2, temporalityCumulative)
+    return appendMessage(b, 9, data)
+  default:
+    return appendMessage(b, 11, data)
+  }
+}
+
+// ExportMetricsServiceRequest { repeated ResourceMetrics resource_metrics = 1; }
+// ResourceMetrics { Resource resource = 1; repeated ScopeMetrics scope_metrics = 2; }
+// Resource { repeated KeyValue attributes = 1; }
+// ScopeMetrics { InstrumentationScope scope = 1; repeated Metric metrics = 2; }
+// InstrumentationScope { string name = 1; string version = 2; }
+func encodeRequest(resource []attribute, scope string, metrics []metric) []byte {
+  var res, scopeMetrics, sc []byte
+
+  for _, a := range resource {
+    res = appendAttribute(res, 1, a)
+  }
+
+  sc = appendString(sc, 1, scope)
+  scopeMetrics = appendMessage(scopeMetrics, 1, sc)
+
+  for _, m := range metrics {
+    scopeMetrics = appendMessage(scopeMetrics, 2, encodeMetric(m))
+  }
+
+  var rm []byte
+  rm = appendMessage(rm, 1, res)
+  rm = appendMessage(rm, 2, scopeMetrics)
+
+  return appendMessage(nil, 1, rm)
+}
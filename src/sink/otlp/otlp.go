@@ -0,0 +1,389 @@
+// Package otlp pushes the sensor metrics, and optionally the metamonitoring metrics, to an
+// OpenTelemetry collector through OTLP, over either gRPC or HTTP.
+package otlp
+
+import (
+  "bytes"
+  "context"
+  "crypto/tls"
+  "crypto/x509"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io"
+  "net"
+  "net/http"
+  "net/url"
+  "os"
+  "strconv"
+  "time"
+
+  "github.com/prometheus/client_golang/prometheus"
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/rs/zerolog/log"
+  "golang.org/x/net/http2"
+)
+
+const (
+  specFieldEndpoint = "endpoint"
+  specFieldProtocol = "protocol"
+  specFieldTLSCA = "tls-ca"
+  specFieldTLSInsecure = "tls-insecure"
+  specFieldTimeout = "timeout"
+
+  // Prefixes of the parameters holding request headers and resource attributes.
+  specHeaderPrefix = "header."
+  specResourcePrefix = "resource."
+
+  ProtocolGRPC = "grpc"
+  ProtocolHTTP = "http"
+
+  scopeName = "github.com/robertof/go-inkbird-exporter"
+  grpcExportPath = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+  httpExportPath = "/v1/metrics"
+)
+
+var schema = device.Schema{
+  {
+    Name: specFieldEndpoint,
+    Type: device.ParamTypeString,
+    Required: true,
+    Description: "Collector endpoint, e.g. 'http://otel-collector:4317'. Over HTTP, /v1/metrics is appended unless a path is given",
+  },
+  {
+    Name: specFieldProtocol,
+    Type: device.ParamTypeString,
+    Default: ProtocolGRPC,
+    AllowedValues: []string{ProtocolGRPC, ProtocolHTTP},
+    Description: "Transport: gRPC, or protobuf over HTTP",
+  },
+  {
+    Name: specFieldTLSCA,
+    Type: device.ParamTypeString,
+    Description: "PEM file with the CA certificates used to verify the collector, instead of the system ones",
+  },
+  {
+    Name: specFieldTLSInsecure,
+    Type: device.ParamTypeBool,
+    Default: "false",
+    Description: "Don't verify the certificate of the collector",
+  },
+  {
+    Name: specFieldTimeout,
+    Type: device.ParamTypeDuration,
+    Default: "10s",
+    Description: "Timeout of each export",
+  },
+}
+
+// Factory creates OTLP sinks. Besides the schema, specs accept 'header.<name>=<value>' to add a
+// header to every request and 'resource.<key>=<value>' to set a resource attribute.
+type Factory struct{}
+
+func (f *Factory) Schema() device.Schema {
+  return schema
+}
+
+func (f *Factory) FromSpec(spec device.DeviceSpec) (collector.Sink, error) {
+  headers := spec.ExtractPrefixed(specHeaderPrefix)
+  resource := spec.ExtractPrefixed(specResourcePrefix)
+  params, err := f.Schema().Parse(spec)
+
+  if err != nil {
+    return nil, err
+  }
+
+  u, err := url.Parse(params.String(specFieldEndpoint))
+
+  if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+    return nil, fmt.Errorf("%w: %s must be an absolute http(s) URL", device.ErrInvalidSpec, specFieldEndpoint)
+  }
+
+  opts := Options{
+    Endpoint: u.String(),
+    Protocol: params.String(specFieldProtocol),
+    Headers: headers,
+    Resource: map[string]string{"service.name": "inkbird-exporter"},
+    Timeout: params.Duration(specFieldTimeout),
+  }
+
+  if host, err := os.Hostname(); err == nil {
+    opts.Resource["host.name"] = host
+  }
+
+  for k, v := range resource {
+    opts.Resource[k] = v
+  }
+
+  if ca := params.String(specFieldTLSCA); ca != "" || params.Bool(specFieldTLSInsecure) {
+    opts.TLS = &tls.Config{InsecureSkipVerify: params.Bool(specFieldTLSInsecure)}
+
+    if ca != "" {
+      pem, err := os.ReadFile(ca)
+
+      if err != nil {
+        return nil, fmt.Errorf("%w: %s: %w", device.ErrInvalidSpec, specFieldTLSCA, err)
+      }
+
+      opts.TLS.RootCAs = x509.NewCertPool()
+
+      if !opts.TLS.RootCAs.AppendCertsFromPEM(pem) {
+        return nil, fmt.Errorf("%w: %s: no certificates found in %v", device.ErrInvalidSpec, specFieldTLSCA, ca)
+      }
+    }
+  }
+
+  return New(opts), nil
+}
+
+// Options of the OTLP sink.
+type Options struct {
+  // Full URL of the collector. Over HTTP, httpExportPath is appended if it has no path.
+  Endpoint string
+  // ProtocolGRPC or ProtocolHTTP.
+  Protocol string
+  Headers map[string]string
+  // Attributes of the resource, i.e. the exporter itself.
+  Resource map[string]string
+  // If nil, the system defaults are used.
+  TLS *tls.Config
+  Timeout time.Duration
+}
+
+// Sink exports each delivery as a single OTLP request. Readings become gauges, with the device
+// and its labels as attributes. Failed exports are not retried.
+type Sink struct {
+  opts Options
+  url string
+  resource []attribute
+  client *http.Client
+  // start time of the cumulative metamonitoring metrics.
+  start time.Time
+  // if set, its metrics are exported along with the readings.
+  gatherer prometheus.Gatherer
+}
+
+func New(opts Options) *Sink {
+  s := &Sink{opts: opts, url: opts.Endpoint, start: time.Now()}
+
+  for k, v := range opts.Resource {
+    s.resource = append(s.resource, attribute{k, v})
+  }
+
+  sortAttributes(s.resource)
+
+  if opts.Protocol == ProtocolGRPC {
+    s.url = mustJoinPath(opts.Endpoint, grpcExportPath)
+
+    transport := &http2.Transport{TLSClientConfig: opts.TLS}
+
+    // gRPC without TLS: HTTP/2 with prior knowledge.
+    if u, _ := url.Parse(opts.Endpoint); u != nil && u.Scheme == "http" {
+      transport.AllowHTTP = true
+      transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+        var d net.Dialer
+        return d.DialContext(ctx, network, addr)
+      }
+    }
+
+    s.client = &http.Client{Transport: transport, Timeout: opts.Timeout}
+  } else {
+    if u, _ := url.Parse(opts.Endpoint); u != nil && (u.Path == "" || u.Path == "/") {
+      s.url = mustJoinPath(opts.Endpoint, httpExportPath)
+    }
+
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.TLSClientConfig = opts.TLS
+    s.client = &http.Client{Transport: transport, Timeout: opts.Timeout}
+  }
+
+  return s
+}
+
+func mustJoinPath(base, path string) string {
+  joined, err := url.JoinPath(base, path)
+
+  if err != nil {
+    panic(err)
+  }
+
+  return joined
+}
+
+// *sink.MetricsSink
+func (s *Sink) SetGatherer(g prometheus.Gatherer) {
+  s.gatherer = g
+}
+
+// Convert readings to sensor metrics, one data point per reading (and probe).
+func readingMetrics(readings []collector.SinkReading) []metric {
+  temperature := metric{name: "sensor.temperature", description: "Temperature of each probe", unit: "Cel"}
+  humidity := metric{name: "sensor.humidity", description: "Relative humidity", unit: "1"}
+  battery := metric{name: "sensor.battery", description: "Battery level", unit: "1"}
+
+  for _, r := range readings {
+    attrs := []attribute{
+      {"device.name", r.Device.Name()},
+      {"device.address", r.Device.Addr().String()},
+    }
+
+    for k, v := range r.Labels {
+      attrs = append(attrs, attribute{k, v})
+    }
+
+    sortAttributes(attrs)
+
+    for probe, temp := range r.Temperatures {
+      temperature.points = append(temperature.points, dataPoint{
+        attrs: append(append([]attribute{}, attrs...), attribute{"sensor.probe", int64(probe)}),
+        time: r.Time,
+        value: float64(temp),
+      })
+    }
+
+    if r.HasHumidity {
+      humidity.points = append(humidity.points, dataPoint{
+        attrs: attrs,
+        time: r.Time,
+        value: float64(r.RelativeHumidity) / 100,
+      })
+    }
+
+    if r.HasBatteryLevel {
+      battery.points = append(battery.points, dataPoint{
+        attrs: attrs,
+        time: r.Time,
+        value: float64(r.BatteryLevel) / 100,
+      })
+    }
+  }
+
+  var out []metric
+
+  for _, m := range []metric{temperature, humidity, battery} {
+    if len(m.points) > 0 {
+      out = append(out, m)
+    }
+  }
+
+  return out
+}
+
+func (s *Sink) Deliver(ctx context.Context, readings []collector.SinkReading) error {
+  all := readingMetrics(readings)
+
+  if s.gatherer != nil {
+    families, err := s.gatherer.Gather()
+
+    if err != nil {
+      log.Warn().Err(err).Msg("otlp: failed to gather some metamonitoring metrics")
+    }
+
+    all = appendFamilies(all, families, s.start, time.Now())
+  }
+
+  if len(all) == 0 {
+    return nil
+  }
+
+  body := encodeRequest(s.resource, scopeName, all)
+
+  if s.opts.Protocol == ProtocolGRPC {
+    return s.exportGRPC(ctx, body)
+  }
+
+  return s.exportHTTP(ctx, body)
+}
+
+func (s *Sink) newRequest(ctx context.Context, body []byte, contentType string) (*http.Request, error) {
+  req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+
+  if err != nil {
+    return nil, err
+  }
+
+  for k, v := range s.opts.Headers {
+    req.Header.Set(k, v)
+  }
+
+  req.Header.Set("Content-Type", contentType)
+  req.Header.Set("User-Agent", "go-inkbird-exporter")
+
+  return req, nil
+}
+
+func (s *Sink) exportHTTP(ctx context.Context, body []byte) error {
+  req, err := s.newRequest(ctx, body, "application/x-protobuf")
+
+  if err != nil {
+    return err
+  }
+
+  resp, err := s.client.Do(req)
+
+  if err != nil {
+    return err
+  }
+
+  defer resp.Body.Close()
+
+  if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+    return nil
+  }
+
+  msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+  return fmt.Errorf("unexpected status %v: %s", resp.Status, bytes.TrimSpace(msg))
+}
+
+// A unary gRPC call: the message is sent as a single length-prefixed frame, and the outcome is
+// reported through the grpc-status trailer (or header, for errors without a response body).
+func (s *Sink) exportGRPC(ctx context.Context, body []byte) error {
+  frame := make([]byte, 5, 5 + len(body))
+  binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+
+  req, err := s.newRequest(ctx, append(frame, body...), "application/grpc")
+
+  if err != nil {
+    return err
+  }
+
+  req.Header.Set("TE", "trailers")
+
+  resp, err := s.client.Do(req)
+
+  if err != nil {
+    return err
+  }
+
+  defer resp.Body.Close()
+
+  // trailers are only available once the body has been read.
+  if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+    return err
+  }
+
+  if resp.StatusCode != http.StatusOK {
+    return fmt.Errorf("unexpected status %v", resp.Status)
+  }
+
+  status, msg := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+
+  if status == "" {
+    status, msg = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+  }
+
+  if status == "" {
+    return errors.New("missing grpc-status in response")
+  }
+
+  if code, err := strconv.Atoi(status); err != nil || code != 0 {
+    if unescaped, err := url.PathUnescape(msg); err == nil {
+      msg = unescaped
+    }
+
+    return fmt.Errorf("export failed with gRPC status %v: %s", status, msg)
+  }
+
+  return nil
+}
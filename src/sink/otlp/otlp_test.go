@@ -0,0 +1,225 @@
+package otlp
+
+import (
+  "context"
+  "encoding/binary"
+  "io"
+  "math"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/internal/testutil"
+  "golang.org/x/net/http2"
+  "golang.org/x/net/http2/h2c"
+  "google.golang.org/protobuf/encoding/protowire"
+)
+
+// Decode a KeyValue, with either a string or an integer value.
+func decodeAttribute(t *testing.T, b []byte) (a attribute) {
+  testutil.DecodeFields(t, b, func(num protowire.Number, v []byte, _ uint64) {
+    if num == 1 {
+      a.key = string(v)
+      return
+    }
+
+    testutil.DecodeFields(t, v, func(num protowire.Number, v []byte, n uint64) {
+      if num == 1 {
+        a.value = string(v)
+      } else {
+        a.value = int64(n)
+      }
+    })
+  })
+
+  return a
+}
+
+type exported struct {
+  resource map[string]any
+  // gauge data points, keyed by metric name.
+  gauges map[string][]dataPoint
+}
+
+// Decode the gauges of an ExportMetricsServiceRequest with a single resource and scope.
+func decodeRequest(t *testing.T, b []byte) exported {
+  out := exported{resource: make(map[string]any), gauges: make(map[string][]dataPoint)}
+
+  testutil.DecodeFields(t, b, func(_ protowire.Number, rm []byte, _ uint64) {
+    testutil.DecodeFields(t, rm, func(num protowire.Number, v []byte, _ uint64) {
+      if num == 1 {
+        testutil.DecodeFields(t, v, func(_ protowire.Number, kv []byte, _ uint64) {
+          a := decodeAttribute(t, kv)
+          out.resource[a.key] = a.value
+        })
+
+        return
+      }
+
+      testutil.DecodeFields(t, v, func(num protowire.Number, m []byte, _ uint64) {
+        if num != 2 {
+          return
+        }
+
+        name := ""
+
+        testutil.DecodeFields(t, m, func(num protowire.Number, v []byte, _ uint64) {
+          switch num {
+          case 1:
+            name = string(v)
+          case 5:
+            testutil.DecodeFields(t, v, func(_ protowire.Number, dp []byte, _ uint64) {
+              var p dataPoint
+
+              testutil.DecodeFields(t, dp, func(num protowire.Number, v []byte, n uint64) {
+                switch num {
+                case 7:
+                  p.attrs = append(p.attrs, decodeAttribute(t, v))
+                case 3:
+                  p.time = time.Unix(0, int64(n))
+                case 4:
+                  p.value = math.Float64frombits(n)
+                }
+              })
+
+              out.gauges[name] = append(out.gauges[name], p)
+            })
+          }
+        })
+      })
+    })
+  })
+
+  return out
+}
+
+func TestSinkExports(t *testing.T) {
+  requests := make(chan exported, 1)
+
+  handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if r.Header.Get("X-Scope-OrgID") != "home" {
+      t.Errorf("missing custom header in %v", r.Header)
+    }
+
+    body, _ := io.ReadAll(r.Body)
+
+    switch r.URL.Path {
+    case grpcExportPath:
+      if r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc" {
+        t.Errorf("unexpected gRPC request %v %v", r.Proto, r.Header)
+      }
+
+      if len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body) - 5 {
+        t.Fatalf("invalid gRPC frame")
+      }
+
+      body = body[5:]
+
+      w.Header().Set("Content-Type", "application/grpc")
+      w.Header().Set("Trailer", "Grpc-Status")
+      w.Write([]byte{0, 0, 0, 0, 0})
+      w.Header().Set("Grpc-Status", "0")
+    case httpExportPath:
+      if r.Header.Get("Content-Type") != "application/x-protobuf" {
+        t.Errorf("unexpected headers %v", r.Header)
+      }
+    default:
+      t.Errorf("unexpected path %v", r.URL.Path)
+    }
+
+    requests <- decodeRequest(t, body)
+  })
+
+  server := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+  defer server.Close()
+
+  at := time.UnixMilli(1700000000000)
+  readings := []collector.SinkReading{{
+    Device: testutil.FakeDevice("fridge"),
+    Labels: map[string]string{"room": "kitchen"},
+    Sample: model.Sample{
+      Reading: device.Reading{Temperatures: []float32{4.5}, HasHumidity: true, RelativeHumidity: 40},
+      Time: at,
+    },
+  }}
+
+  for _, protocol := range []string{ProtocolGRPC, ProtocolHTTP} {
+    s := New(Options{
+      Endpoint: server.URL,
+      Protocol: protocol,
+      Headers: map[string]string{"X-Scope-OrgID": "home"},
+      Resource: map[string]string{"service.name": "inkbird-exporter", "deployment.environment": "test"},
+      Timeout: time.Second,
+    })
+
+    if err := s.Deliver(context.Background(), readings); err != nil {
+      t.Fatalf("%v: %v", protocol, err)
+    }
+
+    req := <-requests
+
+    if req.resource["service.name"] != "inkbird-exporter" || req.resource["deployment.environment"] != "test" {
+      t.Errorf("%v: unexpected resource %v", protocol, req.resource)
+    }
+
+    temp := req.gauges["sensor.temperature"]
+
+    if len(temp) != 1 || temp[0].value != 4.5 || !temp[0].time.Equal(at) {
+      t.Fatalf("%v: unexpected temperature %+v", protocol, temp)
+    }
+
+    attrs := make(map[string]any)
+
+    for _, a := range temp[0].attrs {
+      attrs[a.key] = a.value
+    }
+
+    want := map[string]any{
+      "device.address": "49:42:08:00:12:34",
+      "device.name": "fridge",
+      "room": "kitchen",
+      "sensor.probe": int64(0),
+    }
+
+    if len(attrs) != len(want) {
+      t.Errorf("%v: got attributes %v, wanted %v", protocol, attrs, want)
+    }
+
+    for k, v := range want {
+      if attrs[k] != v {
+        t.Errorf("%v: got attributes %v, wanted %v", protocol, attrs, want)
+        break
+      }
+    }
+
+    if humidity := req.gauges["sensor.humidity"]; len(humidity) != 1 || humidity[0].value != 0.4 {
+      t.Errorf("%v: unexpected humidity %+v", protocol, humidity)
+    }
+  }
+}
+
+func TestSinkReportsGRPCErrors(t *testing.T) {
+  server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    io.Copy(io.Discard, r.Body)
+
+    w.Header().Set("Content-Type", "application/grpc")
+    w.Header().Set("Grpc-Status", "3")
+    w.Header().Set("Grpc-Message", "invalid%20metrics")
+  }), &http2.Server{}))
+  defer server.Close()
+
+  s := New(Options{Endpoint: server.URL, Protocol: ProtocolGRPC, Timeout: time.Second})
+
+  err := s.Deliver(context.Background(), []collector.SinkReading{{
+    Device: testutil.FakeDevice("fridge"),
+    Sample: model.Sample{Reading: device.Reading{Temperatures: []float32{1}}, Time: time.Now()},
+  }})
+
+  if err == nil || err.Error() != "export failed with gRPC status 3: invalid metrics" {
+    t.Errorf("unexpected error %v", err)
+  }
+}
@@ -0,0 +1,149 @@
+package remotewrite
+
+import (
+  "math"
+  "sort"
+  "strconv"
+
+  dto "github.com/prometheus/client_model/go"
+  "google.golang.org/protobuf/encoding/protowire"
+)
+
+type label struct {
+  name, value string
+}
+
+// A single sample of a series.
+type series struct {
+  labels []label
+  value float64
+  // milliseconds since the epoch.
+  timestamp int64
+}
+
+// Flatten metric families into series, as Prometheus would when scraping them. Metrics without a
+// timestamp get defaultTimestamp. extra labels are added to every series, unless the metric has a
+// label with the same name: its own labels win, as with honor_labels.
+func appendFamilies(out []series, families []*dto.MetricFamily, extra []label, defaultTimestamp int64) []series {
+  for _, family := range families {
+    name := family.GetName()
+
+    for _, m := range family.GetMetric() {
+      ts := defaultTimestamp
+
+      if m.TimestampMs != nil {
+        ts = m.GetTimestampMs()
+      }
+
+      var labels []label
+
+      for _, l := range m.GetLabel() {
+        labels = append(labels, label{l.GetName(), l.GetValue()})
+      }
+
+      for _, e := range extra {
+        if !hasLabel(m.GetLabel(), e.name) {
+          labels = append(labels, e)
+        }
+      }
+
+      add := func(suffix string, value float64, more ...label) {
+        s := series{
+          labels: append(append([]label{{"__name__", name + suffix}}, labels...), more...),
+          value: value,
+          timestamp: ts,
+        }
+
+        sort.Slice(s.labels, func(i, j int) bool { return s.labels[i].name < s.labels[j].name })
+        out = append(out, s)
+      }
+
+      switch family.GetType() {
+      case dto.MetricType_COUNTER:
+        add("", m.GetCounter().GetValue())
+      case dto.MetricType_GAUGE:
+        add("", m.GetGauge().GetValue())
+      case dto.MetricType_UNTYPED:
+        add("", m.GetUntyped().GetValue())
+      case dto.MetricType_SUMMARY:
+        summary := m.GetSummary()
+
+        for _, q := range summary.GetQuantile() {
+          add("", q.GetValue(), label{"quantile", formatFloat(q.GetQuantile())})
+        }
+
+        add("_sum", summary.GetSampleSum())
+        add("_count", float64(summary.GetSampleCount()))
+      case dto.MetricType_HISTOGRAM:
+        histogram := m.GetHistogram()
+
+        for _, b := range histogram.GetBucket() {
+          add("_bucket", float64(b.GetCumulativeCount()), label{"le", formatFloat(b.GetUpperBound())})
+        }
+
+        add("_bucket", float64(histogram.GetSampleCount()), label{"le", "+Inf"})
+        add("_sum", histogram.GetSampleSum())
+        add("_count", float64(histogram.GetSampleCount()))
+      }
+    }
+  }
+
+  return out
+}
+
+func formatFloat(f float64) string {
+  if math.IsInf(f, 1) {
+    return "+Inf"
+  }
+
+  return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Encode series as a prometheus.WriteRequest protobuf message:
+//
+//   message WriteRequest { repeated TimeSeries timeseries = 1; }
+//   message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//   message Label { string name = 1; string value = 2; }
+//   message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(all []series) []byte {
+  var b, ts, msg []byte
+
+  for _, s := range all {
+    ts = ts[:0]
+
+    for _, l := range s.labels {
+      msg = msg[:0]
+      msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+      msg = protowire.AppendString(msg, l.name)
+      msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+      msg = protowire.AppendString(msg, l.value)
+
+      ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+      ts = protowire.AppendBytes(ts, msg)
+    }
+
+    msg = msg[:0]
+    msg = protowire.AppendTag(msg, 1, protowire.Fixed64Type)
+    msg = protowire.AppendFixed64(msg, math.Float64bits(s.value))
+    msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+    msg = protowire.AppendVarint(msg, uint64(s.timestamp))
+
+    ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+    ts = protowire.AppendBytes(ts, msg)
+
+    b = protowire.AppendTag(b, 1, protowire.BytesType)
+    b = protowire.AppendBytes(b, ts)
+  }
+
+  return b
+}
+
+func hasLabel(labels []*dto.LabelPair, name string) bool {
+  for _, l := range labels {
+    if l.GetName() == name {
+      return true
+    }
+  }
+
+  return false
+}
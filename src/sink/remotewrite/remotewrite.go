@@ -0,0 +1,315 @@
+// Package remotewrite pushes the sensor metrics, and optionally the metamonitoring metrics, through
+// the Prometheus remote write protocol, for exporters which can't be scraped.
+package remotewrite
+
+import (
+  "bytes"
+  "context"
+  "errors"
+  "fmt"
+  "io"
+  "net/http"
+  "net/url"
+  "os"
+  "strings"
+  "time"
+
+  "github.com/golang/snappy"
+  "github.com/prometheus/client_golang/prometheus"
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/metrics"
+  "github.com/robertof/go-inkbird-exporter/sink/diskqueue"
+  "github.com/rs/zerolog/log"
+)
+
+const (
+  specFieldURL = "url"
+  specFieldWALDir = "wal-dir"
+  specFieldWALSize = "wal-size"
+  specFieldJob = "job"
+  specFieldInstance = "instance"
+  specFieldUsername = "username"
+  specFieldPassword = "password"
+  specFieldBearerToken = "bearer-token"
+  specFieldBearerTokenFile = "bearer-token-file"
+  specFieldTimeout = "timeout"
+)
+
+var schema = device.Schema{
+  {
+    Name: specFieldURL,
+    Type: device.ParamTypeString,
+    Required: true,
+    Description: "Remote write endpoint, e.g. 'https://prometheus:9090/api/v1/write'",
+  },
+  {
+    Name: specFieldWALDir,
+    Type: device.ParamTypeString,
+    Required: true,
+    Description: "Directory where samples are queued until sent, so that they survive outages and restarts",
+  },
+  {
+    Name: specFieldWALSize,
+    Type: device.ParamTypeInt,
+    Default: "256",
+    Description: "Max size of the queue directory, in MiB. The oldest samples are dropped beyond it",
+  },
+  {
+    Name: specFieldJob,
+    Type: device.ParamTypeString,
+    Default: "inkbird-exporter",
+    Description: "Value of the job label of every series",
+  },
+  {
+    Name: specFieldInstance,
+    Type: device.ParamTypeString,
+    Description: "Value of the instance label of every series. Defaults to the hostname",
+  },
+  {
+    Name: specFieldUsername,
+    Type: device.ParamTypeString,
+    Description: "Username for basic authentication",
+  },
+  {
+    Name: specFieldPassword,
+    Type: device.ParamTypeString,
+    Description: "Password for basic authentication",
+  },
+  {
+    Name: specFieldBearerToken,
+    Type: device.ParamTypeString,
+    Description: "Bearer token to authenticate with",
+  },
+  {
+    Name: specFieldBearerTokenFile,
+    Type: device.ParamTypeString,
+    Description: "File to read the bearer token from, instead of passing it on the command line",
+  },
+  {
+    Name: specFieldTimeout,
+    Type: device.ParamTypeDuration,
+    Default: "30s",
+    Description: "Timeout of each request",
+  },
+}
+
+type Factory struct{}
+
+func (f *Factory) Schema() device.Schema {
+  return schema
+}
+
+func (f *Factory) FromSpec(spec device.DeviceSpec) (collector.Sink, error) {
+  params, err := f.Schema().Parse(spec)
+
+  if err != nil {
+    return nil, err
+  }
+
+  u, err := url.Parse(params.String(specFieldURL))
+
+  if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+    return nil, fmt.Errorf("%w: %s must be an absolute http(s) URL", device.ErrInvalidSpec, specFieldURL)
+  }
+
+  wal, err := diskqueue.Open(params.String(specFieldWALDir), int64(params.Int(specFieldWALSize)) << 20)
+
+  if err != nil {
+    return nil, fmt.Errorf("%w: %s: %w", device.ErrInvalidSpec, specFieldWALDir, err)
+  }
+
+  opts := Options{
+    URL: u.String(),
+    Job: params.String(specFieldJob),
+    Instance: params.String(specFieldInstance),
+    Username: params.String(specFieldUsername),
+    Password: params.String(specFieldPassword),
+    BearerToken: params.String(specFieldBearerToken),
+    Timeout: params.Duration(specFieldTimeout),
+  }
+
+  if opts.Instance == "" {
+    if opts.Instance, err = os.Hostname(); err != nil {
+      return nil, fmt.Errorf("%w: %s: %w", device.ErrInvalidSpec, specFieldInstance, err)
+    }
+  }
+
+  if file := params.String(specFieldBearerTokenFile); file != "" {
+    data, err := os.ReadFile(file)
+
+    if err != nil {
+      return nil, fmt.Errorf("%w: %s: %w", device.ErrInvalidSpec, specFieldBearerTokenFile, err)
+    }
+
+    opts.BearerToken = strings.TrimSpace(string(data))
+  }
+
+  return New(opts, wal), nil
+}
+
+// Options of the remote write sink.
+type Options struct {
+  URL string
+  // Values of the job and instance labels added to every series, unless it has its own.
+  Job, Instance string
+  Username, Password string
+  BearerToken string
+  Timeout time.Duration
+}
+
+// Sink queues the samples of every delivery in a write-ahead queue on disk, then sends the queue
+// in order, keeping whatever couldn't be sent for the next delivery.
+type Sink struct {
+  opts Options
+  labels []label
+  client *http.Client
+  wal *diskqueue.Queue
+  // if set, its metrics are pushed along with the readings.
+  gatherer prometheus.Gatherer
+}
+
+func New(opts Options, wal *diskqueue.Queue) *Sink {
+  return &Sink{
+    opts: opts,
+    labels: []label{{"instance", opts.Instance}, {"job", opts.Job}},
+    client: &http.Client{Timeout: opts.Timeout},
+    wal: wal,
+  }
+}
+
+// *sink.MetricsSink
+func (s *Sink) SetGatherer(g prometheus.Gatherer) {
+  s.gatherer = g
+}
+
+// Collects the sensor metrics of a single reading.
+type readingCollector struct {
+  collector.SinkReading
+}
+
+func (c readingCollector) Describe(ch chan<- *prometheus.Desc) {
+  prometheus.DescribeByCollect(c, ch)
+}
+
+func (c readingCollector) Collect(ch chan<- prometheus.Metric) {
+  for _, m := range metrics.SampleMetrics(c.Device, c.Sample) {
+    ch <- m
+  }
+}
+
+func (s *Sink) Deliver(ctx context.Context, readings []collector.SinkReading) error {
+  now := time.Now().UnixMilli()
+  var all []series
+
+  // readings of the same device can be delivered together: gather them separately.
+  for _, r := range readings {
+    reg := prometheus.NewRegistry()
+    reg.MustRegister(readingCollector{r})
+
+    families, err := reg.Gather()
+
+    if err != nil {
+      return err
+    }
+
+    all = appendFamilies(all, families, s.labels, now)
+  }
+
+  if s.gatherer != nil {
+    families, err := s.gatherer.Gather()
+
+    if err != nil {
+      log.Warn().Err(err).Msg("remotewrite: failed to gather some metamonitoring metrics")
+    }
+
+    all = appendFamilies(all, families, s.labels, now)
+  }
+
+  if len(all) > 0 {
+    dropped, err := s.wal.Push(snappy.Encode(nil, encodeWriteRequest(all)))
+
+    if err != nil {
+      return fmt.Errorf("failed to queue samples: %w", err)
+    } else if dropped > 0 {
+      log.Warn().Int("Dropped", dropped).Msg("remotewrite: queue is full, dropped the oldest samples")
+    }
+  }
+
+  return s.drain(ctx)
+}
+
+// The receiver refused the data itself: sending it again would fail again.
+var errRejected = errors.New("samples rejected")
+
+// Send the queued requests, oldest first, until the queue is empty or a request fails.
+func (s *Sink) drain(ctx context.Context) error {
+  sent := 0
+
+  for {
+    body, ok, err := s.wal.Peek()
+
+    if err != nil {
+      // rather than blocking the requests behind it.
+      log.Warn().Err(err).Msg("remotewrite: dropping unreadable queued samples")
+    } else if !ok {
+      break
+    } else if err := s.send(ctx, body); errors.Is(err, errRejected) {
+      log.Warn().Err(err).Msg("remotewrite: dropping samples rejected by the receiver")
+    } else if err != nil {
+      return fmt.Errorf("%w (%d requests queued)", err, s.wal.Len())
+    }
+
+    if err := s.wal.Pop(); err != nil {
+      return err
+    }
+
+    sent += 1
+  }
+
+  if sent > 1 {
+    log.Info().Int("Requests", sent).Msg("remotewrite: sent queued samples")
+  }
+
+  return nil
+}
+
+func (s *Sink) send(ctx context.Context, body []byte) error {
+  req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.opts.URL, bytes.NewReader(body))
+
+  if err != nil {
+    return err
+  }
+
+  req.Header.Set("Content-Encoding", "snappy")
+  req.Header.Set("Content-Type", "application/x-protobuf")
+  req.Header.Set("User-Agent", "go-inkbird-exporter")
+  req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+  if s.opts.BearerToken != "" {
+    req.Header.Set("Authorization", "Bearer " + s.opts.BearerToken)
+  } else if s.opts.Username != "" {
+    req.SetBasicAuth(s.opts.Username, s.opts.Password)
+  }
+
+  resp, err := s.client.Do(req)
+
+  if err != nil {
+    return err
+  }
+
+  defer resp.Body.Close()
+
+  if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+    return nil
+  }
+
+  msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+  err = fmt.Errorf("unexpected status %v: %s", resp.Status, bytes.TrimSpace(msg))
+
+  if resp.StatusCode >= 400 && resp.StatusCode <= 499 && resp.StatusCode != http.StatusTooManyRequests {
+    return fmt.Errorf("%w: %w", errRejected, err)
+  }
+
+  return err
+}
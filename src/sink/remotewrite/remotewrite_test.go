@@ -0,0 +1,178 @@
+package remotewrite
+
+import (
+  "context"
+  "io"
+  "math"
+  "net/http"
+  "net/http/httptest"
+  "slices"
+  "sync/atomic"
+  "testing"
+  "time"
+
+  "github.com/golang/snappy"
+  dto "github.com/prometheus/client_model/go"
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/internal/testutil"
+  "github.com/robertof/go-inkbird-exporter/sink/diskqueue"
+  "google.golang.org/protobuf/encoding/protowire"
+  "google.golang.org/protobuf/proto"
+)
+
+// Decode a WriteRequest into its series, keyed by metric name.
+func decodeWriteRequest(t *testing.T, b []byte) map[string]series {
+  out := make(map[string]series)
+
+  testutil.DecodeFields(t, b, func(_ protowire.Number, ts []byte, _ uint64) {
+    var s series
+    name := ""
+
+    testutil.DecodeFields(t, ts, func(num protowire.Number, v []byte, _ uint64) {
+      if num == 1 {
+        var l label
+
+        testutil.DecodeFields(t, v, func(num protowire.Number, v []byte, _ uint64) {
+          if num == 1 {
+            l.name = string(v)
+          } else {
+            l.value = string(v)
+          }
+        })
+
+        if l.name == "__name__" {
+          name = l.value
+        }
+
+        s.labels = append(s.labels, l)
+        return
+      }
+
+      testutil.DecodeFields(t, v, func(num protowire.Number, _ []byte, n uint64) {
+        if num == 1 {
+          s.value = math.Float64frombits(n)
+        } else {
+          s.timestamp = int64(n)
+        }
+      })
+    })
+
+    out[name] = s
+  })
+
+  return out
+}
+
+func TestSinkQueuesDuringOutages(t *testing.T) {
+  var failing atomic.Bool
+  requests := make(chan map[string]series, 16)
+
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if failing.Load() {
+      w.WriteHeader(http.StatusServiceUnavailable)
+      return
+    }
+
+    if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+      t.Errorf("unexpected headers %v", r.Header)
+    }
+
+    compressed, _ := io.ReadAll(r.Body)
+    body, err := snappy.Decode(nil, compressed)
+
+    if err != nil {
+      t.Errorf("invalid snappy body: %v", err)
+    }
+
+    requests <- decodeWriteRequest(t, body)
+  }))
+  defer server.Close()
+
+  wal, err := diskqueue.Open(t.TempDir(), 0)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  s := New(Options{URL: server.URL, Job: "inkbird", Instance: "pi", Timeout: time.Second}, wal)
+
+  reading := func(temp float32, at time.Time) []collector.SinkReading {
+    return []collector.SinkReading{{
+      Device: testutil.FakeDevice("fridge"),
+      Sample: model.Sample{
+        Reading: device.Reading{Temperatures: []float32{temp}},
+        Time: at,
+      },
+    }}
+  }
+
+  first := time.UnixMilli(1700000000000)
+  failing.Store(true)
+
+  if err := s.Deliver(context.Background(), reading(4, first)); err == nil {
+    t.Fatal("delivery succeeded while the receiver is failing")
+  }
+
+  failing.Store(false)
+
+  if err := s.Deliver(context.Background(), reading(5, first.Add(time.Minute))); err != nil {
+    t.Fatal(err)
+  }
+
+  // the samples queued during the outage are sent first, with their original timestamps.
+  for _, want := range []struct {
+    value float64
+    at time.Time
+  }{
+    {4, first},
+    {5, first.Add(time.Minute)},
+  } {
+    select {
+    case req := <-requests:
+      temp := req["sensor_temperature_celsius"]
+
+      if temp.value != want.value || temp.timestamp != want.at.UnixMilli() {
+        t.Errorf("got %v at %v, wanted %v at %v", temp.value, temp.timestamp, want.value, want.at.UnixMilli())
+      }
+
+      wantLabels := []label{{"__name__", "sensor_temperature_celsius"}, {"instance", "pi"}, {"job", "inkbird"}, {"name", "fridge"}, {"probe", "0"}}
+
+      if len(temp.labels) != len(wantLabels) {
+        t.Fatalf("got labels %v, wanted %v", temp.labels, wantLabels)
+      }
+
+      for i := range wantLabels {
+        if temp.labels[i] != wantLabels[i] {
+          t.Errorf("got labels %v, wanted %v", temp.labels, wantLabels)
+          break
+        }
+      }
+    default:
+      t.Fatal("missing request")
+    }
+  }
+
+  if wal.Len() != 0 {
+    t.Errorf("%d requests left in the queue", wal.Len())
+  }
+}
+
+func TestAppendFamiliesOwnLabelsWin(t *testing.T) {
+  families := []*dto.MetricFamily{{
+    Name: proto.String("up"),
+    Type: dto.MetricType_GAUGE.Enum(),
+    Metric: []*dto.Metric{{
+      Label: []*dto.LabelPair{{Name: proto.String("job"), Value: proto.String("other")}},
+      Gauge: &dto.Gauge{Value: proto.Float64(1)},
+    }},
+  }}
+
+  got := appendFamilies(nil, families, []label{{"instance", "pi"}, {"job", "inkbird"}}, 0)
+  want := []label{{"__name__", "up"}, {"instance", "pi"}, {"job", "other"}}
+
+  if len(got) != 1 || !slices.Equal(got[0].labels, want) {
+    t.Errorf("got %+v, wanted labels %v", got, want)
+  }
+}
@@ -0,0 +1,66 @@
+// Package sink holds the sinks pushing readings outside of Prometheus, see collector.Sink.
+package sink
+
+import (
+  "strings"
+  "time"
+
+  "github.com/prometheus/client_golang/prometheus"
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/device"
+)
+
+// Factory creates sinks of a given type from their spec, in the same `key=value` form used by
+// devices.
+type Factory interface {
+  // Schema describes the parameters accepted by FromSpec. Parameters shared by every sink, such
+  // as collector.SinkSchema, are extracted from the spec beforehand.
+  Schema() device.Schema
+  FromSpec(spec device.DeviceSpec) (collector.Sink, error)
+}
+
+// MetricsSink is implemented by sinks which can also push the metamonitoring metrics of the
+// exporter, gathered at every delivery.
+type MetricsSink interface {
+  collector.Sink
+  SetGatherer(g prometheus.Gatherer)
+}
+
+// Reading is the JSON representation of a reading shared by sinks.
+type Reading struct {
+  Device string `json:"device"`
+  Addr string `json:"addr"`
+  Time time.Time `json:"time"`
+  Temperatures []float32 `json:"temperatures"`
+  Humidity *float32 `json:"humidity,omitempty"`
+  Battery *uint8 `json:"battery,omitempty"`
+  RSSI *int `json:"rssi,omitempty"`
+  ProbeType string `json:"probe_type"`
+}
+
+func NewReading(r collector.SinkReading) Reading {
+  out := Reading{
+    Device: r.Device.Name(),
+    Addr: r.Device.Addr().String(),
+    Time: r.Time,
+    Temperatures: r.Temperatures,
+    ProbeType: strings.ToLower(r.ProbeType.String()),
+  }
+
+  if r.HasHumidity {
+    humidity := r.RelativeHumidity
+    out.Humidity = &humidity
+  }
+
+  if r.HasBatteryLevel {
+    battery := r.BatteryLevel
+    out.Battery = &battery
+  }
+
+  if r.HasRSSI {
+    rssi := r.RSSI
+    out.RSSI = &rssi
+  }
+
+  return out
+}
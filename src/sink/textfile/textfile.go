@@ -0,0 +1,83 @@
+// Package textfile writes the sensor metrics to a file read by the textfile collector of
+// node_exporter, for hosts where the exporter can't open a port of its own.
+package textfile
+
+import (
+  "context"
+  "fmt"
+  "os"
+  "path/filepath"
+  "strings"
+
+  "github.com/prometheus/client_golang/prometheus"
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/metrics"
+)
+
+const specFieldPath = "path"
+
+var schema = device.Schema{
+  {
+    Name: specFieldPath,
+    Type: device.ParamTypeString,
+    Required: true,
+    Description: "File to write, in the directory of the textfile collector, e.g. " +
+      "'/var/lib/node_exporter/textfile_collector/inkbird.prom'",
+  },
+}
+
+type Factory struct{}
+
+func (f *Factory) Schema() device.Schema {
+  return schema
+}
+
+func (f *Factory) FromSpec(spec device.DeviceSpec) (collector.Sink, error) {
+  params, err := f.Schema().Parse(spec)
+
+  if err != nil {
+    return nil, err
+  }
+
+  path := params.String(specFieldPath)
+
+  // node_exporter ignores any other file.
+  if !strings.HasSuffix(path, ".prom") {
+    return nil, fmt.Errorf("%w: %s must end with .prom", device.ErrInvalidSpec, specFieldPath)
+  }
+
+  if info, err := os.Stat(filepath.Dir(path)); err != nil || !info.IsDir() {
+    return nil, fmt.Errorf("%w: %s: %v is not a directory", device.ErrInvalidSpec, specFieldPath, filepath.Dir(path))
+  }
+
+  return New(path), nil
+}
+
+// Sink rewrites the file atomically at every delivery, with the last reading of every device
+// seen so far: the same metrics served on /metrics, without timestamps, which node_exporter
+// refuses.
+type Sink struct {
+  path string
+  samples map[device.Device]model.Sample
+  // if set, its metrics are written along with the readings.
+  gatherer prometheus.Gatherer
+}
+
+func New(path string) *Sink {
+  return &Sink{path: path, samples: make(map[device.Device]model.Sample)}
+}
+
+// *sink.MetricsSink
+func (s *Sink) SetGatherer(g prometheus.Gatherer) {
+  s.gatherer = g
+}
+
+func (s *Sink) Deliver(ctx context.Context, readings []collector.SinkReading) error {
+  for _, r := range readings {
+    s.samples[r.Device] = r.Sample
+  }
+
+  return prometheus.WriteToTextfile(s.path, metrics.SamplesGatherer(s.samples, s.gatherer))
+}
@@ -0,0 +1,72 @@
+package textfile
+
+import (
+  "context"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+
+  "github.com/prometheus/common/expfmt"
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/collector/model"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/internal/testutil"
+)
+
+func reading(name string, temp float32) collector.SinkReading {
+  return collector.SinkReading{
+    Device: testutil.FakeDevice(name),
+    Sample: model.Sample{Reading: device.Reading{Temperatures: []float32{temp}}, Time: time.Now()},
+  }
+}
+
+func TestSinkKeepsEveryDevice(t *testing.T) {
+  dir := t.TempDir()
+  path := filepath.Join(dir, "inkbird.prom")
+  s := New(path)
+
+  // each delivery holds a single device, but the file must hold both.
+  for _, r := range []collector.SinkReading{reading("fridge", 4), reading("freezer", -18), reading("fridge", 5)} {
+    if err := s.Deliver(context.Background(), []collector.SinkReading{r}); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  f, err := os.Open(path)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  defer f.Close()
+
+  families, err := (&expfmt.TextParser{}).TextToMetricFamilies(f)
+
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  got := make(map[string]float64)
+
+  for _, m := range families["sensor_temperature_celsius"].GetMetric() {
+    if m.TimestampMs != nil {
+      t.Errorf("metric written with a timestamp: %v", m)
+    }
+
+    for _, l := range m.GetLabel() {
+      if l.GetName() == "name" {
+        got[l.GetValue()] = m.GetGauge().GetValue()
+      }
+    }
+  }
+
+  if len(got) != 2 || got["fridge"] != 5 || got["freezer"] != -18 {
+    t.Errorf("got temperatures %v, wanted the last reading of both devices", got)
+  }
+
+  // temporary files are cleaned up.
+  if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+    t.Errorf("got %d files, wanted only %v", len(entries), path)
+  }
+}
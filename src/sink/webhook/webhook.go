@@ -0,0 +1,107 @@
+// Package webhook delivers readings to an HTTP endpoint, as a JSON array of sink.Reading.
+package webhook
+
+import (
+  "bytes"
+  "context"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/url"
+  "time"
+
+  "github.com/robertof/go-inkbird-exporter/collector"
+  "github.com/robertof/go-inkbird-exporter/device"
+  "github.com/robertof/go-inkbird-exporter/sink"
+)
+
+const (
+  specFieldURL = "url"
+  specFieldTimeout = "timeout"
+)
+
+var schema = device.Schema{
+  {
+    Name: specFieldURL,
+    Type: device.ParamTypeString,
+    Required: true,
+    Description: "URL receiving the readings in POST requests",
+  },
+  {
+    Name: specFieldTimeout,
+    Type: device.ParamTypeDuration,
+    Default: "10s",
+    Description: "Timeout of each request",
+  },
+}
+
+type Factory struct{}
+
+func (f *Factory) Schema() device.Schema {
+  return schema
+}
+
+func (f *Factory) FromSpec(spec device.DeviceSpec) (collector.Sink, error) {
+  params, err := f.Schema().Parse(spec)
+
+  if err != nil {
+    return nil, err
+  }
+
+  u, err := url.Parse(params.String(specFieldURL))
+
+  if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+    return nil, fmt.Errorf("%w: %s must be an absolute http(s) URL", device.ErrInvalidSpec, specFieldURL)
+  }
+
+  return New(u.String(), params.Duration(specFieldTimeout)), nil
+}
+
+// Sink posts each batch of readings to a URL.
+type Sink struct {
+  url string
+  client *http.Client
+}
+
+func New(url string, timeout time.Duration) *Sink {
+  return &Sink{
+    url: url,
+    client: &http.Client{Timeout: timeout},
+  }
+}
+
+func (s *Sink) Deliver(ctx context.Context, readings []collector.SinkReading) error {
+  body := make([]sink.Reading, len(readings))
+
+  for i, r := range readings {
+    body[i] = sink.NewReading(r)
+  }
+
+  data, err := json.Marshal(body)
+
+  if err != nil {
+    return err
+  }
+
+  req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+
+  if err != nil {
+    return err
+  }
+
+  req.Header.Set("Content-Type", "application/json")
+
+  resp, err := s.client.Do(req)
+
+  if err != nil {
+    return err
+  }
+
+  defer resp.Body.Close()
+
+  if resp.StatusCode < 200 || resp.StatusCode > 299 {
+    return fmt.Errorf("unexpected status %v", resp.Status)
+  }
+
+  return nil
+}
@@ -11,3 +11,16 @@ func Reverse[S ~[]E, E any](s S) S {
 
   return out
 }
+
+// Returns the elements of s for which keep returns true.
+func Filter[S ~[]E, E any](s S, keep func(E) bool) S {
+  var out S
+
+  for _, e := range s {
+    if keep(e) {
+      out = append(out, e)
+    }
+  }
+
+  return out
+}